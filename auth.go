@@ -0,0 +1,40 @@
+package udock
+
+import (
+	"errors"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+// RegistryAuth holds the credentials used when talking to a docker registry.
+// Either set Username and Password, or one of the tokens.
+type RegistryAuth struct {
+	Username string
+	Password string
+
+	// ServerAddress is the address of the registry, e.g. "ghcr.io".
+	ServerAddress string
+
+	// IdentityToken is used to authenticate the user and get an access token
+	// for the registry.
+	IdentityToken string
+
+	// RegistryToken is a bearer token sent to the registry.
+	RegistryToken string
+}
+
+// encode returns the credentials in the base64 encoded form the docker API
+// expects in the X-Registry-Auth header.
+func (a RegistryAuth) encode() (string, error) {
+	encoded, err := registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      a.Username,
+		Password:      a.Password,
+		ServerAddress: a.ServerAddress,
+		IdentityToken: a.IdentityToken,
+		RegistryToken: a.RegistryToken,
+	})
+	if err != nil {
+		return "", errors.Join(ErrRegistryAuth, err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,237 @@
+package udock
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+const (
+	// defaultDockerfile is the name of the Dockerfile we look for in the
+	// build context.
+	defaultDockerfile = "Dockerfile"
+
+	// dockerignoreFile is the name of the file listing paths that should be
+	// excluded from the build context.
+	dockerignoreFile = ".dockerignore"
+)
+
+// BuildOption is an option for BuildImage.
+type BuildOption func(*types.ImageBuildOptions)
+
+// WithBuildArg sets the build argument key to value, just like --build-arg
+// does for docker build.
+func WithBuildArg(key string, value string) BuildOption {
+	return func(o *types.ImageBuildOptions) {
+		if o.BuildArgs == nil {
+			o.BuildArgs = map[string]*string{}
+		}
+		o.BuildArgs[key] = &value
+	}
+}
+
+// WithBuildArgs sets multiple build arguments.
+func WithBuildArgs(args map[string]string) BuildOption {
+	return func(o *types.ImageBuildOptions) {
+		for k, v := range args {
+			WithBuildArg(k, v)(o)
+		}
+	}
+}
+
+// WithTarget selects which stage of a multi-stage Dockerfile to build.
+func WithTarget(stage string) BuildOption {
+	return func(o *types.ImageBuildOptions) {
+		o.Target = stage
+	}
+}
+
+// WithBuildLabels adds labels to the resulting image.
+func WithBuildLabels(labels map[string]string) BuildOption {
+	return func(o *types.ImageBuildOptions) {
+		if o.Labels == nil {
+			o.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			o.Labels[k] = v
+		}
+	}
+}
+
+// WithNoCache disables the build cache.
+func WithNoCache() BuildOption {
+	return func(o *types.ImageBuildOptions) {
+		o.NoCache = true
+	}
+}
+
+// BuildImage builds a docker image from the Dockerfile in contextDir and tags
+// it with tag.  Paths matched by a .dockerignore file in the root of the
+// context directory are not sent to the docker daemon.
+func (s *Session) BuildImage(contextDir string, tag string, opts ...BuildOption) error {
+	buildOptions := types.ImageBuildOptions{
+		Tags:       []string{tag},
+		Dockerfile: defaultDockerfile,
+		Remove:     true,
+	}
+	for _, opt := range opts {
+		opt(&buildOptions)
+	}
+
+	buildContext, err := createBuildContext(contextDir, buildOptions.Dockerfile)
+	if err != nil {
+		return errors.Join(ErrBuildContext, err)
+	}
+	defer buildContext.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerBuildTimeout)
+	defer cancel()
+
+	slog.Info("building image", "contextDir", contextDir, "tag", tag)
+	resp, err := s.client.ImageBuild(ctx, buildContext, buildOptions)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrBuildingImage, tag), err)
+	}
+	defer resp.Body.Close()
+
+	err = readJSONMessages(resp.Body, nil)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrBuildingImage, tag), err)
+	}
+	slog.Info("done building image", "tag", tag)
+	return nil
+}
+
+// createBuildContext returns a tar stream of contextDir, excluding whatever
+// the .dockerignore file in contextDir tells us to exclude.
+func createBuildContext(contextDir string, dockerfile string) (io.ReadCloser, error) {
+	excludes, err := readDockerignore(contextDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The daemon needs the Dockerfile and the .dockerignore file even if the
+	// user has chosen to exclude them, so we add exceptions for them.
+	if keep, _ := patternmatcher.MatchesOrParentMatches(dockerfile, excludes); keep {
+		excludes = append(excludes, "!"+dockerfile)
+	}
+	if keep, _ := patternmatcher.MatchesOrParentMatches(dockerignoreFile, excludes); keep {
+		excludes = append(excludes, "!"+dockerignoreFile)
+	}
+
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBuildContext(pw, contextDir, pm))
+	}()
+	return pr, nil
+}
+
+// writeBuildContext writes a tar archive of contextDir to w, skipping any path
+// that is matched by pm.
+func writeBuildContext(w io.Writer, contextDir string, pm *patternmatcher.PatternMatcher) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(contextDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		skip, err := pm.MatchesOrParentMatches(rel)
+		if err != nil {
+			return err
+		}
+		if skip {
+			// If there are exclusions (patterns starting with !) something
+			// below an excluded directory may still be included, so we
+			// have to descend into it.
+			if d.IsDir() && !pm.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// readDockerignore reads the exclude patterns from the .dockerignore file in
+// contextDir.  If there is no .dockerignore file we return an empty list.
+func readDockerignore(contextDir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(contextDir, dockerignoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ignorefile.ReadAll(f)
+}
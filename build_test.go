@@ -0,0 +1,95 @@
+package udock
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildContextDockerignore(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"Dockerfile":          "FROM scratch\n",
+		".dockerignore":       "# comment\n.git\n*.log\nnode_modules\n!keep.log\n",
+		"main.go":             "package main\n",
+		"debug.log":           "noise",
+		"keep.log":            "signal",
+		".git/HEAD":           "ref: refs/heads/main\n",
+		"node_modules/x/y.js": "junk",
+		"sub/file.txt":        "data",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	r, err := createBuildContext(dir, defaultDockerfile)
+	require.NoError(t, err)
+	defer r.Close()
+
+	names := tarEntryNames(t, r)
+	require.ElementsMatch(t, []string{
+		"Dockerfile",
+		".dockerignore",
+		"main.go",
+		"keep.log",
+		"sub/",
+		"sub/file.txt",
+	}, names)
+}
+
+func TestBuildContextKeepsDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("*\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0o644))
+
+	r, err := createBuildContext(dir, defaultDockerfile)
+	require.NoError(t, err)
+	defer r.Close()
+
+	names := tarEntryNames(t, r)
+	require.ElementsMatch(t, []string{"Dockerfile", ".dockerignore"}, names)
+}
+
+// tarEntryNames returns the names of all the entries in a tar stream.
+func tarEntryNames(t *testing.T, r io.Reader) []string {
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestBuildOptions(t *testing.T) {
+	opts := types.ImageBuildOptions{}
+	for _, opt := range []BuildOption{
+		WithBuildArg("VERSION", "1.2.3"),
+		WithBuildArgs(map[string]string{"EMPTY": ""}),
+		WithTarget("test"),
+		WithBuildLabels(map[string]string{"suite": "udock"}),
+		WithNoCache(),
+	} {
+		opt(&opts)
+	}
+
+	require.Equal(t, "1.2.3", *opts.BuildArgs["VERSION"])
+	require.NotNil(t, opts.BuildArgs["EMPTY"])
+	require.Equal(t, "", *opts.BuildArgs["EMPTY"])
+	require.Equal(t, "test", opts.Target)
+	require.Equal(t, map[string]string{"suite": "udock"}, opts.Labels)
+	require.True(t, opts.NoCache)
+}
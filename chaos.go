@@ -0,0 +1,162 @@
+package udock
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+)
+
+// defaultNetworkToolsImage is the image we run tc from when shaping the
+// network of a container.
+const defaultNetworkToolsImage = "nicolaka/netshoot:latest"
+
+// NetworkConditions describes degraded network conditions for ShapeNetwork.
+// Zero values mean no degradation of that kind.
+type NetworkConditions struct {
+	// Latency is added to every packet.
+	Latency time.Duration
+
+	// Jitter is the random variation of Latency.  It requires Latency to
+	// be set.
+	Jitter time.Duration
+
+	// PacketLoss is the percentage of packets dropped, e.g. 1.5.
+	PacketLoss float64
+
+	// Rate limits the bandwidth, in tc notation, e.g. "1mbit" or
+	// "512kbit".
+	Rate string
+
+	// Interface is the network interface to shape.  Defaults to "eth0".
+	Interface string
+
+	// Image is the image we run tc from.  It must contain the tc binary.
+	// Defaults to nicolaka/netshoot.
+	Image string
+}
+
+// ShapeNetwork degrades the network of a running container according to
+// cond, so tests can observe how an application copes with latency, jitter
+// and packet loss.  This works by running tc netem in a short-lived sidecar
+// that shares the network namespace of the container.  The conditions apply
+// to traffic leaving the container and stay in effect until ResetNetwork is
+// called or the container is removed.
+func (s *Session) ShapeNetwork(containerID string, cond NetworkConditions) error {
+	cmd, err := cond.netemCommand()
+	if err != nil {
+		return err
+	}
+	err = s.runNetworkTool(containerID, cond.image(), cmd, false)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shaped[containerID] == nil {
+		s.shaped[containerID] = map[string]string{}
+	}
+	s.shaped[containerID][cond.iface()] = cond.image()
+	return nil
+}
+
+// ResetNetwork removes any network degradation added by ShapeNetwork, on
+// every interface it shaped and using the image it ran tc from.  If the
+// session did not shape the network of the container, ResetNetwork tries
+// the default interface with the default image.
+func (s *Session) ResetNetwork(containerID string) error {
+	s.mu.Lock()
+	shaped := maps.Clone(s.shaped[containerID])
+	s.mu.Unlock()
+
+	if len(shaped) == 0 {
+		cond := NetworkConditions{}
+		shaped = map[string]string{cond.iface(): cond.image()}
+	}
+
+	var errs []error
+	for _, iface := range sortedKeys(shaped) {
+		err := s.runNetworkTool(containerID, shaped[iface], []string{"tc", "qdisc", "del", "dev", iface, "root"}, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.Lock()
+		delete(s.shaped[containerID], iface)
+		if len(s.shaped[containerID]) == 0 {
+			delete(s.shaped, containerID)
+		}
+		s.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// runNetworkTool runs cmd in a container sharing the network namespace of
+// containerID.  If ignoreMissing is set, errors from tc about there being
+// nothing to remove are ignored.
+func (s *Session) runNetworkTool(containerID string, dockerImage string, cmd []string, ignoreMissing bool) error {
+	err := s.PullImage(dockerImage)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.runToCompletion(dockerImage, cmd, networkToolTimeout,
+		WithNetworkOf(containerID),
+		WithCapAdd("NET_ADMIN"),
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.exitCode != 0 {
+		output := strings.TrimSpace(string(result.stdout) + string(result.stderr))
+		if ignoreMissing && (strings.Contains(output, "No such file or directory") || strings.Contains(output, "handle of zero")) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s exited with %d: %s", ErrShapingNetwork, strings.Join(cmd, " "), result.exitCode, output)
+	}
+	return nil
+}
+
+func (c NetworkConditions) image() string {
+	if c.Image == "" {
+		return defaultNetworkToolsImage
+	}
+	return c.Image
+}
+
+func (c NetworkConditions) iface() string {
+	if c.Interface == "" {
+		return "eth0"
+	}
+	return c.Interface
+}
+
+// netemCommand returns the tc command that sets up the conditions.
+func (c NetworkConditions) netemCommand() ([]string, error) {
+	if c.Jitter > 0 && c.Latency <= 0 {
+		return nil, fmt.Errorf("%w: jitter %s without latency", ErrInvalidOption, c.Jitter)
+	}
+
+	cmd := []string{"tc", "qdisc", "replace", "dev", c.iface(), "root", "netem"}
+	if c.Latency > 0 {
+		cmd = append(cmd, "delay", formatTCTime(c.Latency))
+		if c.Jitter > 0 {
+			cmd = append(cmd, formatTCTime(c.Jitter))
+		}
+	}
+	if c.PacketLoss > 0 {
+		cmd = append(cmd, "loss", fmt.Sprintf("%g%%", c.PacketLoss))
+	}
+	if c.Rate != "" {
+		cmd = append(cmd, "rate", c.Rate)
+	}
+	return cmd, nil
+}
+
+// formatTCTime formats a duration the way tc wants it.
+func formatTCTime(d time.Duration) string {
+	return fmt.Sprintf("%dus", d.Microseconds())
+}
@@ -0,0 +1,31 @@
+package udock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetemCommand(t *testing.T) {
+	cmd, err := NetworkConditions{}.netemCommand()
+	require.NoError(t, err)
+	require.Equal(t, []string{"tc", "qdisc", "replace", "dev", "eth0", "root", "netem"}, cmd)
+
+	cmd, err = NetworkConditions{
+		Latency:    100 * time.Millisecond,
+		Jitter:     20 * time.Millisecond,
+		PacketLoss: 1.5,
+		Rate:       "1mbit",
+		Interface:  "eth1",
+	}.netemCommand()
+	require.NoError(t, err)
+	require.Equal(t,
+		[]string{"tc", "qdisc", "replace", "dev", "eth1", "root", "netem", "delay", "100000us", "20000us", "loss", "1.5%", "rate", "1mbit"},
+		cmd,
+	)
+
+	// jitter without latency makes no sense to tc
+	_, err = NetworkConditions{Jitter: time.Millisecond}.netemCommand()
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,462 @@
+// Package compose brings up the services of a docker-compose file through a
+// udock session, so a compose based development environment can be used in
+// Go tests as it is.
+//
+// The commonly used parts of the compose file format are supported: images,
+// container names, commands and entrypoints, the environment and env files,
+// users, ports, volumes and bind mounts, depends_on and health checks.
+// Services that need building, and the long syntax for ports and volumes, are
+// not supported.
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/borud/udock"
+	"gopkg.in/yaml.v3"
+)
+
+// package errors
+var (
+	ErrParsing     = errors.New("error parsing compose file")
+	ErrUnsupported = errors.New("unsupported compose feature")
+	ErrDependency  = errors.New("invalid service dependency")
+	ErrNoService   = errors.New("no such service")
+)
+
+// Dependency conditions for depends_on.
+const (
+	ConditionStarted   = "service_started"
+	ConditionHealthy   = "service_healthy"
+	ConditionCompleted = "service_completed_successfully"
+)
+
+// Project is a parsed compose file.
+type Project struct {
+	// Name of the project.  Defaults to the name of the directory of the
+	// compose file.
+	Name string `yaml:"name"`
+
+	// Dir is the directory relative bind mounts and env files are
+	// resolved against.
+	Dir string `yaml:"-"`
+
+	// Services by name.
+	Services map[string]*Service `yaml:"services"`
+
+	// Volumes are the named volumes the services use.
+	Volumes map[string]*Volume `yaml:"volumes"`
+}
+
+// Volume is a named volume in a compose file.  Volumes are created when the
+// project is brought up and removed when it is brought down, unless they are
+// external.
+type Volume struct {
+	// External volumes must exist already.
+	External bool `yaml:"external"`
+
+	// Name of an external volume, if not the name it has in the compose
+	// file.
+	Name string `yaml:"name"`
+}
+
+// Service is a service in a compose file.
+type Service struct {
+	Image         string       `yaml:"image"`
+	Build         any          `yaml:"build"`
+	ContainerName string       `yaml:"container_name"`
+	Command       Command      `yaml:"command"`
+	Entrypoint    Command      `yaml:"entrypoint"`
+	Environment   Environment  `yaml:"environment"`
+	EnvFile       Command      `yaml:"env_file"`
+	User          string       `yaml:"user"`
+	Ports         []string     `yaml:"ports"`
+	Volumes       []string     `yaml:"volumes"`
+	DependsOn     DependsOn    `yaml:"depends_on"`
+	Healthcheck   *Healthcheck `yaml:"healthcheck"`
+}
+
+// Command is a command, entrypoint or list of files, which compose files
+// give either as a list or as a single string.  Strings are split into words
+// like a shell would.
+type Command []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		words, err := splitWords(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*c = words
+		return nil
+	}
+
+	var list []string
+	err := node.Decode(&list)
+	if err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// Environment holds environment variables, which compose files give either
+// as a map or as a list of KEY=VALUE.  Variables without a value take their
+// value from the environment of the test process, and are left out if it
+// does not have them.
+type Environment map[string]string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (e *Environment) UnmarshalYAML(node *yaml.Node) error {
+	env := Environment{}
+
+	if node.Kind == yaml.MappingNode {
+		var m map[string]*string
+		err := node.Decode(&m)
+		if err != nil {
+			return err
+		}
+		for k, v := range m {
+			env.set(k, v)
+		}
+		*e = env
+		return nil
+	}
+
+	var list []string
+	err := node.Decode(&list)
+	if err != nil {
+		return err
+	}
+	for _, entry := range list {
+		k, v, found := strings.Cut(entry, "=")
+		if found {
+			env.set(k, &v)
+		} else {
+			env.set(k, nil)
+		}
+	}
+	*e = env
+	return nil
+}
+
+// set sets key to value, or to its value in our environment if value is nil.
+func (e Environment) set(key string, value *string) {
+	if value != nil {
+		e[key] = *value
+		return
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		e[key] = v
+	}
+}
+
+// DependsOn maps the services a service depends on to the condition they
+// must meet before it is started.  Compose files give it either as a list of
+// services, which must have been started, or as a map.
+type DependsOn map[string]string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *DependsOn) UnmarshalYAML(node *yaml.Node) error {
+	deps := DependsOn{}
+
+	if node.Kind == yaml.MappingNode {
+		var m map[string]struct {
+			Condition string `yaml:"condition"`
+		}
+		err := node.Decode(&m)
+		if err != nil {
+			return err
+		}
+		for name, dep := range m {
+			if dep.Condition == "" {
+				dep.Condition = ConditionStarted
+			}
+			deps[name] = dep.Condition
+		}
+		*d = deps
+		return nil
+	}
+
+	var list []string
+	err := node.Decode(&list)
+	if err != nil {
+		return err
+	}
+	for _, name := range list {
+		deps[name] = ConditionStarted
+	}
+	*d = deps
+	return nil
+}
+
+// Healthcheck is the health check of a service.
+type Healthcheck struct {
+	Test     Healthtest `yaml:"test"`
+	Interval Duration   `yaml:"interval"`
+	Timeout  Duration   `yaml:"timeout"`
+	Retries  int        `yaml:"retries"`
+	Disable  bool       `yaml:"disable"`
+}
+
+// Healthtest is the test of a health check, which compose files give either
+// as a list as for HEALTHCHECK in a Dockerfile or as a single string that is
+// run by the shell.
+type Healthtest []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (h *Healthtest) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*h = Healthtest{"CMD-SHELL", node.Value}
+		return nil
+	}
+
+	var list []string
+	err := node.Decode(&list)
+	if err != nil {
+		return err
+	}
+	*h = list
+	return nil
+}
+
+// Duration is a duration in a compose file, e.g. "1m30s".
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	duration, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Load reads and parses the compose file at path.  Variables are
+// interpolated from the environment of the test process.
+func Load(path string) (*Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrParsing, path), err)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrParsing, path), err)
+	}
+
+	project, err := Parse(data, filepath.Dir(abs), os.LookupEnv)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrParsing, path), err)
+	}
+	return project, nil
+}
+
+// Parse parses a compose file, resolving relative paths against dir and
+// interpolating ${VAR} and ${VAR:-default} with the values lookup returns.
+// Like compose does, only the values of the file are interpolated, after it
+// has been parsed, so the values of variables cannot change its structure.
+// Keys that are not supported are logged and ignored.
+func Parse(data []byte, dir string, lookup func(string) (string, bool)) (*Project, error) {
+	var root yaml.Node
+	err := yaml.Unmarshal(data, &root)
+	if err != nil {
+		return nil, errors.Join(ErrParsing, err)
+	}
+
+	project := &Project{}
+	if root.Kind != 0 {
+		err = interpolateNode(&root, lookup)
+		if err != nil {
+			return nil, err
+		}
+		warnUnknownKeys(&root, reflect.TypeOf(project), "")
+
+		err = root.Decode(project)
+		if err != nil {
+			return nil, errors.Join(ErrParsing, err)
+		}
+	}
+
+	project.Dir = dir
+	if project.Name == "" {
+		project.Name = filepath.Base(dir)
+	}
+
+	for name, service := range project.Services {
+		if service == nil {
+			return nil, fmt.Errorf("%w: service %s is empty", ErrParsing, name)
+		}
+		if service.Build != nil {
+			return nil, fmt.Errorf("%w: service %s needs building, only images are supported", ErrUnsupported, name)
+		}
+		if service.Image == "" {
+			return nil, fmt.Errorf("%w: service %s has no image", ErrParsing, name)
+		}
+		for dep, condition := range service.DependsOn {
+			if _, ok := project.Services[dep]; !ok {
+				return nil, fmt.Errorf("%w: %s depends on unknown service %s", ErrDependency, name, dep)
+			}
+			switch condition {
+			case ConditionStarted, ConditionHealthy, ConditionCompleted:
+			default:
+				return nil, fmt.Errorf("%w: %s depends on %s with unknown condition %q", ErrDependency, name, dep, condition)
+			}
+		}
+	}
+	return project, nil
+}
+
+// interpolateNode interpolates the string values in node and below it.
+// Plain values that change are resolved again, so e.g. "retries: ${RETRIES}"
+// gives a number, while quoted values stay strings.
+func interpolateNode(node *yaml.Node, lookup func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!str" {
+			return nil
+		}
+		value, err := interpolate(node.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value && node.Style == 0 {
+			node.Tag = ""
+		}
+		node.Value = value
+
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			err := interpolateNode(node.Content[i], lookup)
+			if err != nil {
+				return err
+			}
+		}
+
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			err := interpolateNode(child, lookup)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// warnUnknownKeys logs the keys of mappings in node that are decoded into
+// structs without a field for them.  Extension keys, starting with "x-", are
+// left alone.
+func warnUnknownKeys(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			warnUnknownKeys(node.Content[i+1], t.Elem(), path+node.Content[i].Value+".")
+		}
+
+	case reflect.Struct:
+		fields := map[string]reflect.Type{}
+		for i := range t.NumField() {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := fields[key.Value]
+			switch {
+			case ok:
+				warnUnknownKeys(node.Content[i+1], fieldType, path+key.Value+".")
+			case !strings.HasPrefix(key.Value, "x-") && key.ShortTag() != "!!merge":
+				slog.Warn("ignoring unsupported compose key", "key", path+key.Value, "line", key.Line)
+			}
+		}
+	}
+}
+
+// interpolate replaces the variables in s with their values, see
+// udock.ExpandVarsFunc.
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	result, err := udock.ExpandVarsFunc(s, lookup)
+	if err != nil {
+		return "", errors.Join(ErrParsing, err)
+	}
+	return result, nil
+}
+
+// splitWords splits s into words the way a shell would, honouring single
+// and double quotes and backslash escapes.
+func splitWords(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("%w: unterminated quote in %q", ErrParsing, s)
+	}
+	if escaped {
+		return nil, fmt.Errorf("%w: trailing backslash in %q", ErrParsing, s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,243 @@
+package compose
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/borud/udock"
+	"github.com/stretchr/testify/require"
+)
+
+const testCompose = `
+services:
+  db:
+    image: postgres:${PG_VERSION:-16}
+    environment:
+      POSTGRES_PASSWORD: secret
+      PGDATA: /pgdata
+    volumes:
+      - data:/pgdata
+      - ./init.sql:/docker-entrypoint-initdb.d/init.sql:ro
+    ports:
+      - "127.0.0.1::5432"
+    healthcheck:
+      test: pg_isready -U postgres
+      interval: 1s
+      timeout: 5s
+      retries: 30
+
+  migrate:
+    image: migrate/migrate
+    command: -path /migrations -database "postgres://postgres:secret@db/postgres?sslmode=disable" up
+    depends_on:
+      db:
+        condition: service_healthy
+
+  app:
+    image: example/app:${APP_TAG}
+    environment:
+      - LOG_LEVEL=debug
+      - HOME
+    ports:
+      - "8080:80"
+    depends_on:
+      - db
+      - migrate
+
+volumes:
+  data:
+`
+
+func TestParse(t *testing.T) {
+	env := map[string]string{"APP_TAG": "v2", "HOME": "/home/test"}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	t.Setenv("HOME", "/home/test")
+
+	project, err := Parse([]byte(testCompose), "/src/shop", lookup)
+	require.NoError(t, err)
+	require.Equal(t, "shop", project.Name)
+	require.Len(t, project.Services, 3)
+	require.Contains(t, project.Volumes, "data")
+
+	db := project.Services["db"]
+	require.Equal(t, "postgres:16", db.Image)
+	require.Equal(t, Environment{"POSTGRES_PASSWORD": "secret", "PGDATA": "/pgdata"}, db.Environment)
+	require.Equal(t, Healthtest{"CMD-SHELL", "pg_isready -U postgres"}, db.Healthcheck.Test)
+	require.Equal(t, Duration(time.Second), db.Healthcheck.Interval)
+	require.Equal(t, 30, db.Healthcheck.Retries)
+
+	migrate := project.Services["migrate"]
+	require.Equal(t, Command{"-path", "/migrations", "-database", "postgres://postgres:secret@db/postgres?sslmode=disable", "up"}, migrate.Command)
+	require.Equal(t, DependsOn{"db": ConditionHealthy}, migrate.DependsOn)
+
+	app := project.Services["app"]
+	require.Equal(t, "example/app:v2", app.Image)
+	require.Equal(t, Environment{"LOG_LEVEL": "debug", "HOME": "/home/test"}, app.Environment)
+	require.Equal(t, DependsOn{"db": ConditionStarted, "migrate": ConditionStarted}, app.DependsOn)
+}
+
+func TestParseErrors(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+
+	_, err := Parse([]byte("services:\n  app:\n    build: .\n"), "/src", noEnv)
+	require.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = Parse([]byte("services:\n  app:\n    image: app\n    depends_on: [db]\n"), "/src", noEnv)
+	require.ErrorIs(t, err, ErrDependency)
+
+	_, err = Parse([]byte("services:\n  app:\n    image: app:${TAG:?set TAG}\n"), "/src", noEnv)
+	require.ErrorIs(t, err, ErrParsing)
+}
+
+func TestParseInterpolatesValues(t *testing.T) {
+	env := map[string]string{
+		"COMMAND": "echo 'a b'",
+		"VALUE":   "1\n    injected: true",
+		"RETRIES": "3",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	project, err := Parse([]byte(`
+version: "3.9"
+x-defaults: &defaults
+  image: app:${RETRIES}
+services:
+  app:
+    <<: *defaults
+    command: ${COMMAND}
+    environment:
+      VALUE: ${VALUE}
+      QUOTED: "${RETRIES}"
+    restart: always
+    healthcheck:
+      test: ["CMD", "true"]
+      retries: ${RETRIES}
+`), "/src", lookup)
+	require.NoError(t, err)
+
+	app := project.Services["app"]
+	require.Equal(t, "app:3", app.Image)
+	require.Equal(t, Command{"echo", "a b"}, app.Command)
+	require.Equal(t, Environment{"VALUE": env["VALUE"], "QUOTED": "3"}, app.Environment)
+	require.Equal(t, 3, app.Healthcheck.Retries)
+}
+
+func TestInterpolate(t *testing.T) {
+	lookup := func(key string) (string, bool) {
+		v, ok := map[string]string{"SET": "value", "EMPTY": ""}[key]
+		return v, ok
+	}
+
+	for in, want := range map[string]string{
+		"$SET ${SET}":         "value value",
+		"${EMPTY:-default}":   "default",
+		"${EMPTY-default}":    "",
+		"${UNSET-default}":    "default",
+		"$$SET costs $$5":     "$SET costs $5",
+		"${UNSET}/path":       "/path",
+		"no variables at all": "no variables at all",
+	} {
+		got, err := interpolate(in, lookup)
+		require.NoError(t, err)
+		require.Equal(t, want, got, in)
+	}
+
+	_, err := interpolate("${EMPTY:?must be set}", lookup)
+	require.ErrorIs(t, err, ErrParsing)
+}
+
+func TestSplitWords(t *testing.T) {
+	words, err := splitWords(`sh -c 'echo "hello world"' a\ b "c\"d"`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"sh", "-c", `echo "hello world"`, "a b", `c"d`}, words)
+
+	_, err = splitWords(`echo "unterminated`)
+	require.ErrorIs(t, err, ErrParsing)
+}
+
+func TestParsePort(t *testing.T) {
+	for in, want := range map[string][3]string{
+		"80":                      {"", "", "80"},
+		"8080:80":                 {"", "8080", "80"},
+		"127.0.0.1:8080:80/udp":   {"127.0.0.1", "8080", "80/udp"},
+		"127.0.0.1::80":           {"127.0.0.1", "", "80"},
+		"9090-9091:8080-8081":     {"", "9090-9091", "8080-8081"},
+		"[::1]:8080:80":           {"::1", "8080", "80"},
+		"[2001:db8::1]:53:53/udp": {"2001:db8::1", "53", "53/udp"},
+	} {
+		ip, host, container, err := parsePort(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, [3]string{ip, host, container}, in)
+	}
+
+	_, _, _, err := parsePort("1:2:3:4")
+	require.ErrorIs(t, err, ErrParsing)
+}
+
+func TestParseVolume(t *testing.T) {
+	source, target, mode, err := parseVolume("./init.sql:/init.sql:ro")
+	require.NoError(t, err)
+	require.Equal(t, []string{"./init.sql", "/init.sql", "ro"}, []string{source, target, mode})
+	require.True(t, isPath(source))
+
+	source, target, _, err = parseVolume("data:/var/lib/data")
+	require.NoError(t, err)
+	require.Equal(t, "data", source)
+	require.Equal(t, "/var/lib/data", target)
+	require.False(t, isPath(source))
+
+	source, _, _, err = parseVolume("/var/lib/data")
+	require.NoError(t, err)
+	require.Empty(t, source)
+
+	_, _, _, err = parseVolume("data:relative")
+	require.ErrorIs(t, err, ErrParsing)
+}
+
+func TestUpDown(t *testing.T) {
+	udock.SkipIfUnavailable(t)
+
+	session, err := udock.Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	project, err := Parse([]byte(`
+services:
+  echo:
+    image: hashicorp/http-echo:latest
+    command: ["-text", "hello from compose"]
+    ports:
+      - "5678"
+`), t.TempDir(), func(string) (string, bool) { return "", false })
+	require.NoError(t, err)
+
+	deployment, err := project.Up(session)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, deployment.Down())
+	}()
+
+	endpoint, err := deployment.Endpoint("echo", "5678")
+	require.NoError(t, err)
+
+	_, err = deployment.Endpoint("nope", "5678")
+	require.ErrorIs(t, err, ErrNoService)
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + endpoint + "/")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body) == "hello from compose\n"
+	}, 30*time.Second, 100*time.Millisecond)
+}
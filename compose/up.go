@@ -0,0 +1,412 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/borud/udock"
+)
+
+// waitTimeout is how long a service gets to meet the condition its
+// dependents depend on.
+const waitTimeout = 2 * time.Minute
+
+// Deployment is a project that has been brought up with Up.
+type Deployment struct {
+	session *udock.Session
+	project *Project
+
+	networkID   string
+	networkName string
+
+	// volumes maps the names of the volumes in the compose file to the
+	// names of the docker volumes, and created lists the volumes we
+	// created and so have to remove.
+	volumes map[string]string
+	created []string
+
+	// containers maps the services to the IDs of their containers and
+	// hostIPs to the host address their ports are reachable at.
+	containers map[string]string
+	hostIPs    map[string]string
+}
+
+// Up brings the project up through the session, the way docker compose up
+// would.  The services get a network of their own where they can reach each
+// other by service name, and are started in dependency order, waiting for
+// the conditions of depends_on before starting dependents.  Missing images
+// are pulled.  If anything fails, whatever was brought up is brought down
+// again.
+func (p *Project) Up(s *udock.Session) (*Deployment, error) {
+	services := sortedKeys(p.Services)
+
+	var images []string
+	for _, name := range services {
+		images = append(images, p.Services[name].Image)
+	}
+	err := s.EnsureImages(images)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Deployment{
+		session:    s,
+		project:    p,
+		volumes:    map[string]string{},
+		containers: map[string]string{},
+		hostIPs:    map[string]string{},
+	}
+
+	d.networkName = udock.UniqueName(p.Name, "")
+	d.networkID, err = s.CreateNetwork(d.networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range sortedKeys(p.Volumes) {
+		vol := p.Volumes[name]
+		if vol != nil && vol.External {
+			d.volumes[name] = name
+			if vol.Name != "" {
+				d.volumes[name] = vol.Name
+			}
+			continue
+		}
+
+		volumeName, err := s.CreateVolume(udock.UniqueName(p.Name+"-"+name, ""))
+		if err != nil {
+			return nil, errors.Join(err, d.Down())
+		}
+		d.volumes[name] = volumeName
+		d.created = append(d.created, volumeName)
+	}
+
+	specs := map[string]udock.ContainerSpec{}
+	for _, name := range services {
+		spec, hostIP, err := d.containerSpec(name, p.Services[name])
+		if err != nil {
+			return nil, errors.Join(err, d.Down())
+		}
+		specs[name] = spec
+		d.hostIPs[name] = hostIP
+	}
+
+	// The conditions of depends_on are what the dependents need of the
+	// dependency, which for RunAll means the dependency is not ready
+	// until it meets all of them.
+	needs := map[string]map[string]bool{}
+	for _, name := range services {
+		for dep, condition := range p.Services[name].DependsOn {
+			if needs[dep] == nil {
+				needs[dep] = map[string]bool{}
+			}
+			needs[dep][condition] = true
+		}
+	}
+
+	var all []udock.ContainerSpec
+	for _, name := range services {
+		spec := specs[name]
+		for _, dep := range sortedKeys(p.Services[name].DependsOn) {
+			spec.DependsOn = append(spec.DependsOn, specs[dep].Name)
+		}
+
+		// A service that has completed is not going to become healthy,
+		// so completion wins.
+		switch {
+		case needs[name][ConditionCompleted]:
+			spec.WaitFor = udock.WaitForExit()
+		case needs[name][ConditionHealthy]:
+			spec.WaitFor = udock.WaitForHealthy()
+		}
+		spec.WaitTimeout = waitTimeout
+		all = append(all, spec)
+	}
+
+	containers, err := s.RunAll(all...)
+	if err != nil {
+		return nil, errors.Join(err, d.Down())
+	}
+	for _, name := range services {
+		d.containers[name] = containers[specs[name].Name]
+	}
+	return d, nil
+}
+
+// containerSpec translates the service to a container spec, and returns the
+// host address its ports are reachable at.
+func (d *Deployment) containerSpec(name string, service *Service) (udock.ContainerSpec, string, error) {
+	containerName := service.ContainerName
+	if containerName == "" {
+		containerName = udock.UniqueName(d.project.Name+"-"+name, "")
+	}
+
+	// Compose leaves containers around when they exit, which we need for
+	// service_completed_successfully.
+	opts := []udock.ContainerOption{
+		udock.WithNetworkMode(d.networkName),
+		udock.WithNetworkAliases(name),
+		udock.WithAutoRemove(false),
+	}
+
+	// The environment overrides env files.
+	for _, envFile := range service.EnvFile {
+		opts = append(opts, udock.WithEnvFile(d.resolve(envFile)))
+	}
+	if len(service.Environment) > 0 {
+		opts = append(opts, udock.WithEnv(service.Environment))
+	}
+
+	if len(service.Entrypoint) > 0 {
+		opts = append(opts, udock.WithEntrypoint(service.Entrypoint...))
+	}
+	if len(service.Command) > 0 {
+		opts = append(opts, udock.WithCmd(service.Command...))
+	}
+	if service.User != "" {
+		opts = append(opts, udock.WithUser(service.User))
+	}
+
+	if hc := service.Healthcheck; hc != nil {
+		switch {
+		case hc.Disable:
+			opts = append(opts, udock.WithHealthCheck([]string{"NONE"}, 0, 0, 0))
+		case len(hc.Test) > 0:
+			opts = append(opts, udock.WithHealthCheck(hc.Test, time.Duration(hc.Interval), time.Duration(hc.Timeout), hc.Retries))
+		default:
+			// Compose would keep the test of the image and only change
+			// its timing, which WithHealthCheck cannot do.
+			slog.Warn("ignoring healthcheck without a test, the one of the image is used as it is", "service", name)
+		}
+	}
+
+	ports := map[string]string{}
+	hostIP := ""
+	for _, p := range service.Ports {
+		ip, hostPort, containerPort, err := parsePort(p)
+		if err != nil {
+			return udock.ContainerSpec{}, "", fmt.Errorf("%w: service %s", err, name)
+		}
+		if ip != "" && hostIP != "" && ip != hostIP {
+			return udock.ContainerSpec{}, "", fmt.Errorf("%w: service %s publishes ports on more than one host address", ErrUnsupported, name)
+		}
+		if ip != "" {
+			hostIP = ip
+		}
+
+		if hostPort == "" {
+			opts = append(opts, udock.WithPublishedPorts(containerPort))
+			continue
+		}
+		if _, exists := ports[hostPort]; exists {
+			return udock.ContainerSpec{}, "", fmt.Errorf("%w: service %s publishes host port %s twice", ErrParsing, name, hostPort)
+		}
+		ports[hostPort] = containerPort
+	}
+	if hostIP != "" {
+		opts = append(opts, udock.WithHostIP(hostIP))
+	}
+
+	for _, v := range service.Volumes {
+		opt, err := d.volumeMount(v)
+		if err != nil {
+			return udock.ContainerSpec{}, "", fmt.Errorf("%w: service %s", err, name)
+		}
+		opts = append(opts, opt)
+	}
+
+	// Ports published on all addresses are reachable on loopback.
+	switch hostIP {
+	case "", "0.0.0.0", "::":
+		hostIP = "127.0.0.1"
+	}
+
+	return udock.ContainerSpec{
+		Image:   service.Image,
+		Name:    containerName,
+		Ports:   ports,
+		Options: opts,
+	}, hostIP, nil
+}
+
+// volumeMount translates a volume in the short syntax of compose files,
+// e.g. "data:/var/lib/postgresql/data" or "./init.sql:/init.sql:ro", to a
+// container option.  Anonymous volumes are created in the session.
+func (d *Deployment) volumeMount(v string) (udock.ContainerOption, error) {
+	source, target, mode, err := parseVolume(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mountOpts []udock.MountOption
+		selinux   bool
+	)
+	for _, m := range strings.Split(mode, ",") {
+		switch m {
+		case "", "rw":
+		case "ro":
+			mountOpts = append(mountOpts, udock.MountReadOnly())
+		case "z":
+			mountOpts = append(mountOpts, udock.MountSELinuxShared())
+			selinux = true
+		case "Z":
+			mountOpts = append(mountOpts, udock.MountSELinuxPrivate())
+			selinux = true
+		default:
+			return nil, fmt.Errorf("%w: volume mode %q in %q", ErrUnsupported, m, v)
+		}
+	}
+
+	if isPath(source) {
+		return udock.WithBindMount(d.resolve(source), target, false, mountOpts...), nil
+	}
+	if selinux {
+		return nil, fmt.Errorf("%w: SELinux labels on volume %q", ErrUnsupported, v)
+	}
+
+	volumeName, ok := d.volumes[source]
+	if source == "" {
+		var err error
+		volumeName, err = d.session.CreateVolume("")
+		if err != nil {
+			return nil, err
+		}
+		d.created = append(d.created, volumeName)
+	} else if !ok {
+		return nil, fmt.Errorf("%w: volume %s is not declared", ErrParsing, source)
+	}
+	return udock.WithVolumeMount(volumeName, target, mountOpts...), nil
+}
+
+// Container returns the ID of the container of the service, or the empty
+// string if there is no such service.
+func (d *Deployment) Container(service string) string {
+	return d.containers[service]
+}
+
+// Endpoint returns the host:port address the container port, e.g. "5432",
+// of the service is reachable at from the test.
+func (d *Deployment) Endpoint(service string, containerPort string) (string, error) {
+	containerID, ok := d.containers[service]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNoService, service)
+	}
+
+	hostPort, err := d.session.GetMappedPort(containerID, containerPort)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(d.hostIPs[service], hostPort), nil
+}
+
+// Network returns the name of the network of the project.
+func (d *Deployment) Network() string {
+	return d.networkName
+}
+
+// Down brings the project down again, removing the containers, the network
+// and the volumes that were created.
+func (d *Deployment) Down() error {
+	var errs []error
+	for _, name := range sortedKeys(d.containers) {
+		errs = append(errs, d.session.RemoveContainer(d.containers[name]))
+		delete(d.containers, name)
+	}
+
+	if d.networkID != "" {
+		errs = append(errs, d.session.RemoveNetwork(d.networkID))
+		d.networkID = ""
+	}
+	for _, name := range d.created {
+		errs = append(errs, d.session.RemoveVolume(name))
+	}
+	d.created = nil
+
+	return errors.Join(errs...)
+}
+
+// resolve resolves a path in the compose file against the directory of the
+// project, expanding a leading ~ to the home directory.
+func (d *Deployment) resolve(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(d.project.Dir, path)
+}
+
+// parsePort parses a port in the short syntax of compose files, e.g. "80",
+// "8080:80", "127.0.0.1:8080:80", "127.0.0.1::80" or "9090-9091:8080-8081/udp".
+func parsePort(p string) (hostIP string, hostPort string, containerPort string, err error) {
+	parts := strings.Split(p, ":")
+	switch len(parts) {
+	case 1:
+		return "", "", parts[0], nil
+	case 2:
+		return "", parts[0], parts[1], nil
+	case 3:
+		return parts[0], parts[1], parts[2], nil
+	}
+
+	// IPv6 host addresses have colons of their own and are in brackets
+	if strings.HasPrefix(p, "[") {
+		end := strings.Index(p, "]:")
+		if end > 0 {
+			rest := strings.SplitN(p[end+2:], ":", 2)
+			if len(rest) == 2 {
+				return p[1:end], rest[0], rest[1], nil
+			}
+		}
+	}
+	return "", "", "", fmt.Errorf("%w: invalid port %q", ErrParsing, p)
+}
+
+// parseVolume parses a volume in the short syntax of compose files into
+// its source, target and mode.  The source of anonymous volumes is empty.
+func parseVolume(v string) (source string, target string, mode string, err error) {
+	parts := strings.Split(v, ":")
+	switch len(parts) {
+	case 1:
+		target = parts[0]
+	case 2:
+		source, target = parts[0], parts[1]
+	case 3:
+		source, target, mode = parts[0], parts[1], parts[2]
+	default:
+		return "", "", "", fmt.Errorf("%w: invalid volume %q", ErrParsing, v)
+	}
+
+	if !strings.HasPrefix(target, "/") {
+		return "", "", "", fmt.Errorf("%w: volume target %q is not an absolute path", ErrParsing, v)
+	}
+	return source, target, mode, nil
+}
+
+// isPath returns true if the source of a volume is a host path rather than
+// the name of a volume.
+func isPath(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,534 @@
+package udock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// defaultHostIP is the host address published ports are bound to unless
+// WithHostIP says otherwise.  We bind to loopback so test containers are not
+// exposed on every interface of the host.
+const defaultHostIP = "127.0.0.1"
+
+// ContainerOption is an option for CreateContainer.
+type ContainerOption func(*containerSpec) error
+
+// containerSpec is everything we need to create a container.
+type containerSpec struct {
+	config        *container.Config
+	hostConfig    *container.HostConfig
+	networkConfig *network.NetworkingConfig
+
+	// hostIPs are the host addresses published ports are bound to.
+	hostIPs []string
+
+	// publish lists container ports that are published on host ports
+	// assigned by docker.
+	publish []string
+
+	// free lists container ports that are published on free host ports
+	// picked by resolveFreePorts, see WithFreePorts.
+	free []string
+
+	// freePorts are the container ports resolveFreePorts picked free host
+	// ports for.
+	freePorts map[nat.Port]bool
+
+	// macAddress is the MAC address of the container on its primary
+	// network.
+	macAddress string
+
+	// networkAliases are extra names the container can be reached by on
+	// its primary network.
+	networkAliases []string
+
+	// binds are the host paths mounted into the container.
+	binds []bindMount
+
+	// files are copied into the container after it has been created.
+	files []containerFile
+
+	// platform overrides the platform of the session for this container.
+	platform *ocispec.Platform
+
+	// vars are the variables expanded by the options, if WithVars has
+	// been given.
+	vars map[string]string
+}
+
+// WithHostIP binds the published ports of the container to the host
+// addresses ips rather than 127.0.0.1.  Use "0.0.0.0" to publish on all
+// interfaces, which is needed if docker runs on a different machine than the
+// tests.  IPv6 addresses such as "::1" are supported, and giving both an IPv4
+// and an IPv6 address publishes the ports on both for dual-stack testing.  At
+// least one address must be given.
+func WithHostIP(ips ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		if len(ips) == 0 {
+			return fmt.Errorf("%w: no host address given", ErrInvalidOption)
+		}
+		spec.hostIPs = nil
+		for _, ip := range ips {
+			ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("%w: invalid host address %q", ErrInvalidOption, ip)
+			}
+			spec.hostIPs = append(spec.hostIPs, ip)
+		}
+		return nil
+	}
+}
+
+// WithPublishedPorts publishes the container ports on free host ports picked
+// by docker, e.g. WithPublishedPorts("5432/tcp", "30000-30010/udp").  Use
+// GetMappedPort to find out which host port a container port ended up on.
+// This avoids the race inherent in picking a free port ourselves.
+func WithPublishedPorts(containerPorts ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.publish = append(spec.publish, containerPorts...)
+		return nil
+	}
+}
+
+// WithAutoRemove controls whether docker removes the container when it
+// exits.  Containers are automatically removed by default.
+func WithAutoRemove(enabled bool) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.AutoRemove = enabled
+		return nil
+	}
+}
+
+// WithNetworkMode sets the network mode of the container, e.g. "host",
+// "none", "bridge", the name of a network or "container:<id>".
+func WithNetworkMode(mode string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.NetworkMode = container.NetworkMode(mode)
+		return nil
+	}
+}
+
+// WithNetworkAliases gives the container extra names other containers on its
+// network can reach it by, e.g. the name of a service.  Aliases only work on
+// user defined networks, so combine it with WithNetworkMode or a session
+// network.
+func WithNetworkAliases(aliases ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.networkAliases = append(spec.networkAliases, aliases...)
+		return nil
+	}
+}
+
+// WithNetworkOf makes the container share the network namespace of the
+// container containerID.  This is how sidecars such as toxiproxy or tcpdump
+// get to see the traffic of the container they are attached to.  A container
+// sharing the network of another container cannot publish ports of its own;
+// ports have to be published by the container owning the network.
+func WithNetworkOf(containerID string) ContainerOption {
+	return WithNetworkMode("container:" + containerID)
+}
+
+// WithDNS sets the DNS servers the container uses, e.g. the address of a
+// CoreDNS fixture.
+func WithDNS(servers ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		for _, server := range servers {
+			if net.ParseIP(server) == nil {
+				return fmt.Errorf("%w: invalid DNS server address %q", ErrInvalidOption, server)
+			}
+		}
+		spec.hostConfig.DNS = append(spec.hostConfig.DNS, servers...)
+		return nil
+	}
+}
+
+// WithDNSSearch sets the DNS search domains of the container.
+func WithDNSSearch(domains ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.DNSSearch = append(spec.hostConfig.DNSSearch, domains...)
+		return nil
+	}
+}
+
+// WithDNSOptions sets resolver options for the container, e.g. "ndots:2".
+func WithDNSOptions(options ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.DNSOptions = append(spec.hostConfig.DNSOptions, options...)
+		return nil
+	}
+}
+
+// WithExtraHosts adds entries to /etc/hosts in the container.  Entries are
+// of the form "hostname:address", where address is an IP address or the
+// special value "host-gateway", which resolves to the host, e.g.
+// "host.docker.internal:host-gateway".
+func WithExtraHosts(hosts ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		for _, host := range hosts {
+			name, addr, ok := strings.Cut(host, ":")
+			if !ok || name == "" || (addr != "host-gateway" && net.ParseIP(strings.Trim(addr, "[]")) == nil) {
+				return fmt.Errorf("%w: invalid extra host %q, expected hostname:address", ErrInvalidOption, host)
+			}
+		}
+		spec.hostConfig.ExtraHosts = append(spec.hostConfig.ExtraHosts, hosts...)
+		return nil
+	}
+}
+
+// WithHostname sets the hostname of the container.  Software such as Kafka
+// and RabbitMQ embeds the hostname in the addresses it advertises, so it
+// often needs to be controlled.
+func WithHostname(hostname string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.config.Hostname = hostname
+		return nil
+	}
+}
+
+// WithDomainname sets the domain name of the container.
+func WithDomainname(domainname string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.config.Domainname = domainname
+		return nil
+	}
+}
+
+// WithMACAddress sets the MAC address of the container on its primary
+// network, for software that keys licenses or DHCP leases on the MAC.
+func WithMACAddress(mac string) ContainerOption {
+	return func(spec *containerSpec) error {
+		_, err := net.ParseMAC(mac)
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: invalid MAC address %q", ErrInvalidOption, mac), err)
+		}
+		spec.macAddress = mac
+		return nil
+	}
+}
+
+// WithCmd overrides the command of the image, e.g. WithCmd("sleep",
+// "infinity") for containers that are only used through exec, or to pass
+// flags to the server binary.
+func WithCmd(cmd ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		cmd, err := spec.expandAll(cmd)
+		if err != nil {
+			return err
+		}
+		spec.config.Cmd = cmd
+		return nil
+	}
+}
+
+// WithEntrypoint overrides the entrypoint of the image.  If cmd is not
+// overridden as well it is passed to the new entrypoint as arguments.
+func WithEntrypoint(entrypoint ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		entrypoint, err := spec.expandAll(entrypoint)
+		if err != nil {
+			return err
+		}
+		spec.config.Entrypoint = entrypoint
+		return nil
+	}
+}
+
+// WithUser sets the user the container runs as, given as "user", "uid",
+// "user:group" or "uid:gid", e.g. WithUser("1000:1000") so files written to
+// bind mounts are owned by you rather than root.
+func WithUser(user string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.config.User = user
+		return nil
+	}
+}
+
+// WithLabels adds labels to the container, e.g. the name of the test or an
+// ID for the suite, which can later be used for finding and cleaning up
+// containers.
+func WithLabels(labels map[string]string) ContainerOption {
+	return func(spec *containerSpec) error {
+		if spec.config.Labels == nil {
+			spec.config.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			spec.config.Labels[k] = v
+		}
+		return nil
+	}
+}
+
+// WithSysctl sets the namespaced kernel parameter key to value in the
+// container, e.g. WithSysctl("net.core.somaxconn", "4096").
+func WithSysctl(key string, value string) ContainerOption {
+	return func(spec *containerSpec) error {
+		if key == "" {
+			return fmt.Errorf("%w: empty sysctl name", ErrInvalidOption)
+		}
+		if spec.hostConfig.Sysctls == nil {
+			spec.hostConfig.Sysctls = map[string]string{}
+		}
+		spec.hostConfig.Sysctls[key] = value
+		return nil
+	}
+}
+
+// WithPidMode sets the PID namespace of the container, either "host" or
+// "container:<id>", so a debugging sidecar can see the processes of the
+// container it is attached to.
+func WithPidMode(mode string) ContainerOption {
+	return func(spec *containerSpec) error {
+		pidMode := container.PidMode(mode)
+		if !pidMode.IsHost() && pidMode.Container() == "" {
+			return fmt.Errorf("%w: invalid PID mode %q", ErrInvalidOption, mode)
+		}
+		spec.hostConfig.PidMode = pidMode
+		return nil
+	}
+}
+
+// WithIpcMode sets the IPC namespace of the container, which is one of
+// "none", "private", "shareable", "host" or "container:<id>".  Containers
+// that should share memory with each other need one of them to be
+// "shareable" and the others to use "container:<id>" of that container.
+func WithIpcMode(mode string) ContainerOption {
+	return func(spec *containerSpec) error {
+		ipcMode := container.IpcMode(mode)
+		if ipcMode.IsEmpty() || !ipcMode.Valid() || (ipcMode.IsContainer() && ipcMode.Container() == "") {
+			return fmt.Errorf("%w: invalid IPC mode %q", ErrInvalidOption, mode)
+		}
+		spec.hostConfig.IpcMode = ipcMode
+		return nil
+	}
+}
+
+// WithInit runs an init process as PID 1 in the container that forwards
+// signals and reaps zombie processes, for images whose entrypoints spawn
+// children and then hang when the container is stopped.
+func WithInit() ContainerOption {
+	return func(spec *containerSpec) error {
+		init := true
+		spec.hostConfig.Init = &init
+		return nil
+	}
+}
+
+// WithTTY allocates a pseudo-TTY for the container, for programs that behave
+// differently when they run on a terminal.  With a TTY stdout and stderr are
+// combined into a single stream.
+func WithTTY() ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.config.Tty = true
+		return nil
+	}
+}
+
+// WithOpenStdin keeps stdin of the container open so it can be attached to
+// and written to, like docker run -i.  If once is set stdin is closed after
+// the first client that attached to it detaches.
+func WithOpenStdin(once bool) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.config.OpenStdin = true
+		spec.config.AttachStdin = true
+		spec.config.StdinOnce = once
+		return nil
+	}
+}
+
+// WithStopSignal sets the signal StopContainer sends the container to make it
+// stop, e.g. "SIGQUIT" for nginx to shut down gracefully.
+func WithStopSignal(signal string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.config.StopSignal = signal
+		return nil
+	}
+}
+
+// WithStopTimeout sets how long StopContainer waits for the container to exit
+// after sending it the stop signal before it is killed.  The timeout is
+// rounded up to whole seconds.
+func WithStopTimeout(timeout time.Duration) ContainerOption {
+	return func(spec *containerSpec) error {
+		if timeout < 0 {
+			return fmt.Errorf("%w: stop timeout cannot be negative, got %s", ErrInvalidOption, timeout)
+		}
+		seconds := int((timeout + time.Second - 1) / time.Second)
+		spec.config.StopTimeout = &seconds
+		return nil
+	}
+}
+
+// WithContainerPlatform runs the container for platform, of the form
+// os/arch[/variant], rather than the platform of the session.  This selects
+// which variant of a multi-arch image that is already present is run, e.g.
+// "linux/amd64" on an arm64 host with emulation.
+func WithContainerPlatform(platform string) ContainerOption {
+	return func(spec *containerSpec) error {
+		p, err := parsePlatform(platform)
+		if err != nil {
+			return err
+		}
+		spec.platform = p
+		return nil
+	}
+}
+
+// WithHealthCheck sets the docker health check of the container, overriding
+// the one of the image.  test is as for HEALTHCHECK in a Dockerfile, e.g.
+// []string{"CMD-SHELL", "pg_isready -U postgres"} or []string{"CMD",
+// "redis-cli", "ping"}.  Zero durations and retries mean the docker defaults.
+func WithHealthCheck(test []string, interval time.Duration, timeout time.Duration, retries int) ContainerOption {
+	return func(spec *containerSpec) error {
+		if len(test) == 0 {
+			return fmt.Errorf("%w: empty health check", ErrInvalidOption)
+		}
+		spec.config.Healthcheck = &container.HealthConfig{
+			Test:     test,
+			Interval: interval,
+			Timeout:  timeout,
+			Retries:  retries,
+		}
+		return nil
+	}
+}
+
+// primaryNetwork returns the name of the network the container is attached
+// to when it is created.
+func (spec *containerSpec) primaryNetwork() string {
+	mode := spec.hostConfig.NetworkMode
+	if mode.IsDefault() || mode == "" {
+		return network.NetworkBridge
+	}
+	return mode.NetworkName()
+}
+
+// primaryEndpoint returns the endpoint settings for the primary network of
+// the container, adding them if there are none yet.
+func (spec *containerSpec) primaryEndpoint() *network.EndpointSettings {
+	name := spec.primaryNetwork()
+	endpoint := spec.networkConfig.EndpointsConfig[name]
+	if endpoint == nil {
+		endpoint = &network.EndpointSettings{}
+		spec.networkConfig.EndpointsConfig[name] = endpoint
+	}
+	return endpoint
+}
+
+// newContainerSpec builds the spec for a container running dockerImage with
+// the ports published as described for CreateContainer.
+func newContainerSpec(dockerImage string, ports map[string]string, opts ...ContainerOption) (*containerSpec, error) {
+	spec := &containerSpec{
+		config: &container.Config{
+			Image:        dockerImage,
+			Tty:          false,
+			ExposedPorts: nat.PortSet{},
+		},
+		hostConfig: &container.HostConfig{
+			PortBindings: nat.PortMap{},
+			AutoRemove:   true,
+		},
+		networkConfig: &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{},
+		},
+		hostIPs: []string{defaultHostIP},
+	}
+
+	for _, opt := range opts {
+		err := opt(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	portmap, exposedPorts, err := portBindings(ports, spec.hostIPs)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range spec.publish {
+		containerPorts, err := newPorts(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, containerPort := range containerPorts {
+			for _, hostIP := range spec.hostIPs {
+				portmap[containerPort] = append(portmap[containerPort], nat.PortBinding{HostIP: hostIP})
+			}
+			exposedPorts[containerPort] = struct{}{}
+		}
+	}
+	for _, p := range spec.free {
+		containerPorts, err := newPorts(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, containerPort := range containerPorts {
+			for _, hostIP := range spec.hostIPs {
+				portmap[containerPort] = append(portmap[containerPort], nat.PortBinding{HostIP: hostIP, HostPort: freePort})
+			}
+			exposedPorts[containerPort] = struct{}{}
+		}
+	}
+	for port, bindings := range portmap {
+		spec.hostConfig.PortBindings[port] = append(spec.hostConfig.PortBindings[port], bindings...)
+	}
+	for port := range exposedPorts {
+		spec.config.ExposedPorts[port] = struct{}{}
+	}
+
+	if spec.hostConfig.NetworkMode.IsContainer() && len(spec.hostConfig.PortBindings) > 0 {
+		return nil, fmt.Errorf("%w: cannot publish ports when sharing the network of another container", ErrPortMap)
+	}
+	if spec.hostConfig.NetworkMode.IsContainer() && (spec.config.Hostname != "" || spec.config.Domainname != "") {
+		return nil, fmt.Errorf("%w: cannot set hostname when sharing the network of another container", ErrInvalidOption)
+	}
+
+	err = spec.validateResources()
+	if err != nil {
+		return nil, err
+	}
+
+	// Files are copied into the root filesystem after the container has
+	// been created, which docker refuses if it is read-only.
+	if spec.hostConfig.ReadonlyRootfs && len(spec.files) > 0 {
+		return nil, fmt.Errorf("%w: cannot add files to a read-only root filesystem, mount them instead", ErrInvalidOption)
+	}
+
+	uid, gid, numeric := numericUser(spec.config.User)
+	for i := range spec.files {
+		if spec.files[i].ownedByUser && numeric {
+			spec.files[i].uid = uid
+			spec.files[i].gid = gid
+		}
+	}
+
+	for _, b := range spec.binds {
+		spec.hostConfig.Binds = append(spec.hostConfig.Binds, b.bind())
+	}
+
+	if spec.macAddress != "" {
+		mode := spec.hostConfig.NetworkMode
+		if mode.IsContainer() || mode.IsHost() || mode.IsNone() {
+			return nil, fmt.Errorf("%w: cannot set MAC address with network mode %s", ErrInvalidOption, mode)
+		}
+		spec.primaryEndpoint().MacAddress = spec.macAddress
+	}
+
+	if len(spec.networkAliases) > 0 {
+		mode := spec.hostConfig.NetworkMode
+		if mode == "" || !mode.IsUserDefined() {
+			return nil, fmt.Errorf("%w: network aliases need a user defined network, not %q", ErrInvalidOption, spec.primaryNetwork())
+		}
+		endpoint := spec.primaryEndpoint()
+		endpoint.Aliases = append(endpoint.Aliases, spec.networkAliases...)
+	}
+
+	return spec, nil
+}
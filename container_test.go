@@ -0,0 +1,264 @@
+package udock
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerSpecHostIP(t *testing.T) {
+	spec, err := newContainerSpec("postgres:16", map[string]string{"15432": "5432"})
+	require.NoError(t, err)
+	require.Equal(t, "postgres:16", spec.config.Image)
+	require.Equal(t, nat.PortMap{"5432/tcp": {{HostIP: "127.0.0.1", HostPort: "15432"}}}, spec.hostConfig.PortBindings)
+	require.Equal(t, nat.PortSet{"5432/tcp": {}}, spec.config.ExposedPorts)
+
+	spec, err = newContainerSpec("postgres:16", map[string]string{"15432": "5432"}, WithHostIP("0.0.0.0"))
+	require.NoError(t, err)
+	require.Equal(t, nat.PortMap{"5432/tcp": {{HostIP: "0.0.0.0", HostPort: "15432"}}}, spec.hostConfig.PortBindings)
+}
+
+func TestContainerSpecPublishedPorts(t *testing.T) {
+	spec, err := newContainerSpec("postgres:16", nil, WithPublishedPorts("5432", "53/udp"))
+	require.NoError(t, err)
+	require.Equal(t, nat.PortMap{
+		"5432/tcp": {{HostIP: "127.0.0.1"}},
+		"53/udp":   {{HostIP: "127.0.0.1"}},
+	}, spec.hostConfig.PortBindings)
+	require.Equal(t, nat.PortSet{"5432/tcp": {}, "53/udp": {}}, spec.config.ExposedPorts)
+
+	_, err = newContainerSpec("postgres:16", nil, WithPublishedPorts("5432/icmp"))
+	require.ErrorIs(t, err, ErrPortMap)
+}
+
+func TestContainerSpecIPv6HostIP(t *testing.T) {
+	spec, err := newContainerSpec("nginx", map[string]string{"8080": "80"}, WithHostIP("127.0.0.1", "[::1]"))
+	require.NoError(t, err)
+	require.Equal(t, nat.PortMap{"80/tcp": {
+		{HostIP: "127.0.0.1", HostPort: "8080"},
+		{HostIP: "::1", HostPort: "8080"},
+	}}, spec.hostConfig.PortBindings)
+
+	_, err = newContainerSpec("nginx", nil, WithHostIP("localhost"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("nginx", nil, WithHostIP())
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestContainerSpecNetworkOf(t *testing.T) {
+	spec, err := newContainerSpec("nicolaka/netshoot", nil, WithNetworkOf("abc123"))
+	require.NoError(t, err)
+	require.Equal(t, "container:abc123", string(spec.hostConfig.NetworkMode))
+
+	_, err = newContainerSpec("nicolaka/netshoot", map[string]string{"8080": "80"}, WithNetworkOf("abc123"))
+	require.ErrorIs(t, err, ErrPortMap)
+}
+
+func TestContainerSpecDNS(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil,
+		WithDNS("10.0.0.53", "fd00::53"),
+		WithDNSSearch("svc.test", "test"),
+		WithDNSOptions("ndots:2"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.53", "fd00::53"}, spec.hostConfig.DNS)
+	require.Equal(t, []string{"svc.test", "test"}, spec.hostConfig.DNSSearch)
+	require.Equal(t, []string{"ndots:2"}, spec.hostConfig.DNSOptions)
+
+	_, err = newContainerSpec("alpine", nil, WithDNS("dns.example.com"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestContainerSpecExtraHosts(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil, WithExtraHosts(
+		"api.example.test:172.17.0.5",
+		"host.docker.internal:host-gateway",
+		"v6.example.test:fd00::5",
+	))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"api.example.test:172.17.0.5",
+		"host.docker.internal:host-gateway",
+		"v6.example.test:fd00::5",
+	}, spec.hostConfig.ExtraHosts)
+
+	for _, invalid := range []string{"api.example.test", ":172.17.0.5", "api.example.test:somewhere"} {
+		_, err = newContainerSpec("alpine", nil, WithExtraHosts(invalid))
+		require.ErrorIs(t, err, ErrInvalidOption, invalid)
+	}
+}
+
+func TestContainerSpecHostname(t *testing.T) {
+	spec, err := newContainerSpec("rabbitmq", nil, WithHostname("rabbit1"), WithDomainname("cluster.test"))
+	require.NoError(t, err)
+	require.Equal(t, "rabbit1", spec.config.Hostname)
+	require.Equal(t, "cluster.test", spec.config.Domainname)
+
+	_, err = newContainerSpec("rabbitmq", nil, WithHostname("rabbit1"), WithNetworkOf("abc123"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestContainerSpecMACAddress(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil, WithMACAddress("02:42:ac:11:00:42"))
+	require.NoError(t, err)
+	require.Equal(t, "02:42:ac:11:00:42", spec.networkConfig.EndpointsConfig["bridge"].MacAddress)
+
+	spec, err = newContainerSpec("alpine", nil, WithNetworkMode("testnet"), WithMACAddress("02:42:ac:11:00:42"))
+	require.NoError(t, err)
+	require.Equal(t, "02:42:ac:11:00:42", spec.networkConfig.EndpointsConfig["testnet"].MacAddress)
+
+	_, err = newContainerSpec("alpine", nil, WithMACAddress("not-a-mac"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("alpine", nil, WithNetworkMode("host"), WithMACAddress("02:42:ac:11:00:42"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestContainerSpecNetworkAliases(t *testing.T) {
+	spec, err := newContainerSpec("postgres", nil, WithNetworkMode("testnet"), WithNetworkAliases("db", "postgres"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"db", "postgres"}, spec.networkConfig.EndpointsConfig["testnet"].Aliases)
+
+	_, err = newContainerSpec("postgres", nil, WithNetworkAliases("db"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestContainerSpecCmd(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil, WithEntrypoint("/bin/sh", "-c"), WithCmd("sleep infinity"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"/bin/sh", "-c"}, []string(spec.config.Entrypoint))
+	require.Equal(t, []string{"sleep infinity"}, []string(spec.config.Cmd))
+}
+
+func TestContainerSpecUser(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil, WithUser("1000:1000"))
+	require.NoError(t, err)
+	require.Equal(t, "1000:1000", spec.config.User)
+}
+
+func TestContainerSpecLabels(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil,
+		WithLabels(map[string]string{"test": "TestFoo", "suite": "a"}),
+		WithLabels(map[string]string{"suite": "b"}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"test": "TestFoo", "suite": "b"}, spec.config.Labels)
+}
+
+func TestContainerSpecSysctl(t *testing.T) {
+	spec, err := newContainerSpec("redis", nil,
+		WithSysctl("net.core.somaxconn", "4096"),
+		WithSysctl("net.ipv4.tcp_syncookies", "0"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"net.core.somaxconn":      "4096",
+		"net.ipv4.tcp_syncookies": "0",
+	}, spec.hostConfig.Sysctls)
+
+	_, err = newContainerSpec("redis", nil, WithSysctl("", "1"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestContainerSpecNamespaces(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil, WithPidMode("container:abc123"), WithIpcMode("shareable"))
+	require.NoError(t, err)
+	require.Equal(t, container.PidMode("container:abc123"), spec.hostConfig.PidMode)
+	require.Equal(t, container.IPCModeShareable, spec.hostConfig.IpcMode)
+
+	for _, invalid := range []string{"", "container:", "private"} {
+		_, err = newContainerSpec("alpine", nil, WithPidMode(invalid))
+		require.ErrorIs(t, err, ErrInvalidOption, invalid)
+	}
+	for _, invalid := range []string{"", "container:", "shared"} {
+		_, err = newContainerSpec("alpine", nil, WithIpcMode(invalid))
+		require.ErrorIs(t, err, ErrInvalidOption, invalid)
+	}
+}
+
+func TestContainerSpecInit(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil)
+	require.NoError(t, err)
+	require.Nil(t, spec.hostConfig.Init)
+
+	spec, err = newContainerSpec("alpine", nil, WithInit())
+	require.NoError(t, err)
+	require.True(t, *spec.hostConfig.Init)
+}
+
+func TestContainerSpecTTY(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil)
+	require.NoError(t, err)
+	require.False(t, spec.config.Tty)
+	require.False(t, spec.config.OpenStdin)
+
+	spec, err = newContainerSpec("alpine", nil, WithTTY(), WithOpenStdin(true))
+	require.NoError(t, err)
+	require.True(t, spec.config.Tty)
+	require.True(t, spec.config.OpenStdin)
+	require.True(t, spec.config.AttachStdin)
+	require.True(t, spec.config.StdinOnce)
+}
+
+func TestContainerSpecStop(t *testing.T) {
+	spec, err := newContainerSpec("nginx", nil, WithStopSignal("SIGQUIT"), WithStopTimeout(1500*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, "SIGQUIT", spec.config.StopSignal)
+	require.Equal(t, 2, *spec.config.StopTimeout)
+
+	_, err = newContainerSpec("nginx", nil, WithStopTimeout(-time.Second))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestContainerSpecPlatform(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil)
+	require.NoError(t, err)
+	require.Nil(t, spec.platform)
+
+	spec, err = newContainerSpec("alpine", nil, WithContainerPlatform("linux/arm64/v8"))
+	require.NoError(t, err)
+	require.Equal(t, "linux", spec.platform.OS)
+	require.Equal(t, "arm64", spec.platform.Architecture)
+	require.Equal(t, "v8", spec.platform.Variant)
+
+	_, err = newContainerSpec("alpine", nil, WithContainerPlatform("amd64"))
+	require.ErrorIs(t, err, ErrInvalidPlatform)
+}
+
+func TestContainerSpecHealthCheck(t *testing.T) {
+	spec, err := newContainerSpec("redis", nil, WithHealthCheck([]string{"CMD", "redis-cli", "ping"}, time.Second, 2*time.Second, 5))
+	require.NoError(t, err)
+	require.Equal(t, &container.HealthConfig{
+		Test:     []string{"CMD", "redis-cli", "ping"},
+		Interval: time.Second,
+		Timeout:  2 * time.Second,
+		Retries:  5,
+	}, spec.config.Healthcheck)
+
+	_, err = newContainerSpec("redis", nil, WithHealthCheck(nil, 0, 0, 0))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestProxyEnv(t *testing.T) {
+	for _, name := range proxyEnvVars {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("NO_PROXY", "localhost,127.0.0.1")
+
+	s := &Session{}
+	require.NoError(t, WithProxyEnv()(s))
+
+	spec, err := newContainerSpec("alpine", nil, s.defaultContainerOptions()...)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		"HTTPS_PROXY=http://proxy.example.com:3128",
+		"NO_PROXY=localhost,127.0.0.1",
+	}, spec.config.Env)
+}
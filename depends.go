@@ -0,0 +1,168 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RunAll runs the specs like Run.  Each spec is started once the specs named
+// in its DependsOn have been started and become ready, and specs that do not
+// depend on each other are started concurrently, up to the limit set with
+// WithStartParallelism.  Every spec must have a name that is unique among the
+// specs.  Returns the IDs of the containers by name.  If any of the specs
+// fails to run, no more are started and the containers that were started are
+// removed again.
+func (s *Session) RunAll(specs ...ContainerSpec) (map[string]string, error) {
+	return s.runAll(context.Background(), specs)
+}
+
+// runAll does the work of RunAll, starting no more specs once ctx is done.
+func (s *Session) runAll(ctx context.Context, specs []ContainerSpec) (map[string]string, error) {
+	order, err := startOrder(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	index := map[string]int{}
+	done := make([]chan struct{}, len(specs))
+	for i, spec := range specs {
+		index[spec.Name] = i
+		done[i] = make(chan struct{})
+	}
+
+	parallelism := s.startParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	slots := make(chan struct{}, parallelism)
+	failed := make(chan struct{})
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		containers = map[string]string{}
+		errs       []error
+	)
+
+	for _, i := range order {
+		wg.Add(1)
+		go func(spec ContainerSpec) {
+			defer wg.Done()
+			defer close(done[i])
+
+			for _, dep := range spec.DependsOn {
+				select {
+				case <-done[index[dep]]:
+				case <-failed:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-failed:
+				return
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+
+			// a dependency may have failed while we waited for a slot
+			select {
+			case <-failed:
+				return
+			default:
+			}
+
+			containerID, err := s.runContext(ctx, spec)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if len(errs) == 0 {
+					close(failed)
+				}
+				errs = append(errs, errors.Join(fmt.Errorf("%w: starting %s", ErrDependency, spec.Name), err))
+				return
+			}
+			containers[spec.Name] = containerID
+		}(specs[i])
+	}
+	wg.Wait()
+
+	// specs that were still waiting when ctx was done were never started
+	if len(errs) == 0 && len(containers) < len(specs) {
+		errs = append(errs, ctx.Err())
+	}
+	if len(errs) > 0 {
+		for _, containerID := range containers {
+			errs = append(errs, s.RemoveContainer(containerID))
+		}
+		return nil, errors.Join(errs...)
+	}
+	return containers, nil
+}
+
+// startOrder returns the indices of the specs in an order where each spec
+// comes after the specs it depends on, and fails if a spec depends on a spec
+// that does not exist or on itself through a cycle.  Specs that do not
+// depend on each other keep the order they were given in.
+func startOrder(specs []ContainerSpec) ([]int, error) {
+	index := map[string]int{}
+	for i, spec := range specs {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("%w: spec for %s has no name", ErrDependency, spec.Image)
+		}
+		if _, exists := index[spec.Name]; exists {
+			return nil, fmt.Errorf("%w: more than one spec named %s", ErrDependency, spec.Name)
+		}
+		index[spec.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(specs))
+	order := make([]int, 0, len(specs))
+
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		path = append(slices.Clip(path), specs[i].Name)
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("%w: dependency cycle %s", ErrDependency, strings.Join(path, " -> "))
+		case done:
+			return nil
+		}
+
+		state[i] = visiting
+		for _, dep := range specs[i].DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return fmt.Errorf("%w: %s depends on unknown spec %s", ErrDependency, specs[i].Name, dep)
+			}
+			err := visit(j, path)
+			if err != nil {
+				return err
+			}
+		}
+		state[i] = done
+		order = append(order, i)
+		return nil
+	}
+
+	for i := range specs {
+		err := visit(i, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
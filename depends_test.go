@@ -0,0 +1,35 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartOrder(t *testing.T) {
+	order, err := startOrder([]ContainerSpec{
+		{Name: "app", DependsOn: []string{"db", "cache"}},
+		{Name: "migrate", DependsOn: []string{"db"}},
+		{Name: "db"},
+		{Name: "cache"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3, 0, 1}, order)
+
+	_, err = startOrder([]ContainerSpec{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"c"}},
+		{Name: "c", DependsOn: []string{"a"}},
+	})
+	require.ErrorIs(t, err, ErrDependency)
+	require.ErrorContains(t, err, "a -> b -> c -> a")
+
+	_, err = startOrder([]ContainerSpec{{Name: "app", DependsOn: []string{"db"}}})
+	require.ErrorIs(t, err, ErrDependency)
+
+	_, err = startOrder([]ContainerSpec{{Name: "db"}, {Name: "db"}})
+	require.ErrorIs(t, err, ErrDependency)
+
+	_, err = startOrder([]ContainerSpec{{Image: "postgres"}})
+	require.ErrorIs(t, err, ErrDependency)
+}
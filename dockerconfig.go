@@ -0,0 +1,235 @@
+package udock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+const (
+	// dockerHubServerAddress is the server address docker uses as the key
+	// for Docker Hub credentials.
+	dockerHubServerAddress = "https://index.docker.io/v1/"
+
+	// credentialHelperPrefix is the prefix of credential helper binaries.
+	credentialHelperPrefix = "docker-credential-"
+
+	// credentialHelperTokenUsername is the username credential helpers return
+	// when the secret is an identity token.
+	credentialHelperTokenUsername = "<token>"
+)
+
+// dockerConfig is the subset of the docker config.json file we care about.
+type dockerConfig struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// credentialHelperResponse is what a credential helper writes on stdout for
+// the get command.
+type credentialHelperResponse struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// dockerConfigPath returns the location of the docker config file.
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// loadDockerConfig reads the docker config file.  A missing config file is
+// not an error, it just gives us an empty config.
+func loadDockerConfig() (*dockerConfig, error) {
+	path, err := dockerConfigPath()
+	if err != nil {
+		return nil, errors.Join(ErrDockerConfig, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &dockerConfig{}, nil
+	}
+	if err != nil {
+		return nil, errors.Join(ErrDockerConfig, err)
+	}
+
+	var config dockerConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrDockerConfig, path), err)
+	}
+	return &config, nil
+}
+
+// registryServerAddress returns the server address docker uses for the
+// registry hosting dockerImage.
+func registryServerAddress(dockerImage string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(dockerImage)
+	if err != nil {
+		return "", err
+	}
+
+	domain := reference.Domain(named)
+	if domain == "docker.io" {
+		return dockerHubServerAddress, nil
+	}
+	return domain, nil
+}
+
+// registryHostname strips the scheme and path from a server address, which
+// is how docker matches keys in the auths section of the config file.
+func registryHostname(serverAddress string) string {
+	hostname := strings.TrimPrefix(serverAddress, "https://")
+	hostname = strings.TrimPrefix(hostname, "http://")
+	hostname, _, _ = strings.Cut(hostname, "/")
+	return hostname
+}
+
+// authFor resolves the credentials for the registry at serverAddress.  If
+// there are no credentials for the registry we return an empty RegistryAuth.
+func (c *dockerConfig) authFor(serverAddress string) (RegistryAuth, error) {
+	hostname := registryHostname(serverAddress)
+
+	// per-registry credential helpers take precedence, then the default
+	// credential store and finally credentials stored in the file itself.
+	for key, helper := range c.CredHelpers {
+		if registryHostname(key) == hostname {
+			return credentialHelperGet(helper, serverAddress)
+		}
+	}
+
+	if c.CredsStore != "" {
+		return credentialHelperGet(c.CredsStore, serverAddress)
+	}
+
+	for key, entry := range c.Auths {
+		if registryHostname(key) != hostname {
+			continue
+		}
+
+		auth := RegistryAuth{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			ServerAddress: serverAddress,
+			IdentityToken: entry.IdentityToken,
+			RegistryToken: entry.RegistryToken,
+		}
+
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return RegistryAuth{}, errors.Join(fmt.Errorf("%w: invalid auth for %s", ErrDockerConfig, key), err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return RegistryAuth{}, fmt.Errorf("%w: invalid auth for %s", ErrDockerConfig, key)
+			}
+			auth.Username = username
+			auth.Password = password
+		}
+		return auth, nil
+	}
+
+	return RegistryAuth{}, nil
+}
+
+// credentialHelperGet asks the credential helper docker-credential-<helper>
+// for the credentials for serverAddress.
+func credentialHelperGet(helper string, serverAddress string) (RegistryAuth, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(credentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		// helpers report missing credentials on stdout and exit non-zero.
+		// Not having credentials is not an error, we just go anonymous.
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return RegistryAuth{}, nil
+		}
+		return RegistryAuth{}, errors.Join(
+			fmt.Errorf("%w: %s: %s", ErrCredentialHelper, helper, strings.TrimSpace(stdout.String()+stderr.String())),
+			err,
+		)
+	}
+
+	var resp credentialHelperResponse
+	err = json.Unmarshal(stdout.Bytes(), &resp)
+	if err != nil {
+		return RegistryAuth{}, errors.Join(fmt.Errorf("%w: %s", ErrCredentialHelper, helper), err)
+	}
+
+	if resp.Username == credentialHelperTokenUsername {
+		return RegistryAuth{
+			ServerAddress: serverAddress,
+			IdentityToken: resp.Secret,
+		}, nil
+	}
+
+	return RegistryAuth{
+		Username:      resp.Username,
+		Password:      resp.Secret,
+		ServerAddress: serverAddress,
+	}, nil
+}
+
+// resolveAuth returns the credentials to use for talking to the registry
+// hosting dockerImage.  Explicitly given credentials win, and if there are
+// none and the session is configured to use the docker config, we look
+// there.
+func (s *Session) resolveAuth(dockerImage string, explicit *RegistryAuth) (string, error) {
+	if explicit != nil {
+		return explicit.encode()
+	}
+
+	if !s.useDockerConfig {
+		return "", nil
+	}
+
+	serverAddress, err := registryServerAddress(dockerImage)
+	if err != nil {
+		return "", errors.Join(ErrRegistryAuth, err)
+	}
+
+	config, err := loadDockerConfig()
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := config.authFor(serverAddress)
+	if err != nil {
+		return "", err
+	}
+	if auth == (RegistryAuth{}) {
+		return "", nil
+	}
+	return auth.encode()
+}
@@ -0,0 +1,89 @@
+package udock
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryServerAddress(t *testing.T) {
+	for image, want := range map[string]string{
+		"postgres":                     dockerHubServerAddress,
+		"hashicorp/http-echo:latest":   dockerHubServerAddress,
+		"ghcr.io/borud/udock:v1":       "ghcr.io",
+		"localhost:5000/test/image:v1": "localhost:5000",
+	} {
+		got, err := registryServerAddress(image)
+		require.NoError(t, err)
+		require.Equal(t, want, got, image)
+	}
+}
+
+func TestDockerConfigAuth(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	// fake credential helper that hands out a token for one registry and
+	// reports missing credentials for everything else.
+	helper := `#!/bin/sh
+read server
+if [ "$server" = "registry.example.com" ]; then
+  echo '{"ServerURL":"registry.example.com","Username":"<token>","Secret":"s3cret"}'
+  exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hubuser:hubpass")) + `"},
+    "ghcr.io": {"identitytoken": "id-token"}
+  },
+  "credHelpers": {
+    "registry.example.com": "fake",
+    "other.example.com": "fake"
+  }
+}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600))
+
+	cfg, err := loadDockerConfig()
+	require.NoError(t, err)
+
+	auth, err := cfg.authFor(dockerHubServerAddress)
+	require.NoError(t, err)
+	require.Equal(t, "hubuser", auth.Username)
+	require.Equal(t, "hubpass", auth.Password)
+
+	auth, err = cfg.authFor("ghcr.io")
+	require.NoError(t, err)
+	require.Equal(t, "id-token", auth.IdentityToken)
+
+	auth, err = cfg.authFor("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", auth.IdentityToken)
+
+	auth, err = cfg.authFor("other.example.com")
+	require.NoError(t, err)
+	require.Equal(t, RegistryAuth{}, auth)
+
+	auth, err = cfg.authFor("unknown.example.com")
+	require.NoError(t, err)
+	require.Equal(t, RegistryAuth{}, auth)
+}
+
+func TestDockerConfigMissing(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	cfg, err := loadDockerConfig()
+	require.NoError(t, err)
+
+	auth, err := cfg.authFor(dockerHubServerAddress)
+	require.NoError(t, err)
+	require.Equal(t, RegistryAuth{}, auth)
+}
@@ -0,0 +1,149 @@
+package udock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Dump writes what there is to know about the containers of the session to
+// dir, one directory per container named after it, so failures that only
+// happen on CI can be debugged after the fact.  For each container we write
+//
+//	inspect.json  the output of docker inspect
+//	stdout.log    everything the container has written to stdout
+//	stderr.log    everything the container has written to stderr
+//	stats.json    resource usage, if the container is running
+//	events.json   the docker events for the container since the session
+//	              was created, one JSON object per line
+//
+// Containers that were removed, including those that were auto removed when
+// they exited, are gone and cannot be dumped.  Dump carries on past
+// containers it fails to dump and reports them in the error.
+func (s *Session) Dump(dir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerDumpTimeout)
+	defer cancel()
+
+	containers, err := s.client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelSession+"="+s.id)),
+	})
+	if err != nil {
+		return errors.Join(ErrDumpingDiagnostics, err)
+	}
+
+	history, err := s.containerEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, c := range containers {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+
+		err := s.dumpContainer(ctx, filepath.Join(dir, name), c.ID, history[c.ID])
+		if err != nil {
+			errs = append(errs, errors.Join(fmt.Errorf("%w: %s", ErrDumpingDiagnostics, name), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dumpContainer writes the diagnostics for a single container to dir.
+func (s *Session) dumpContainer(ctx context.Context, dir string, containerID string, history []events.Message) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+	}
+	inspect, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	stdout, stderr, err := s.containerLogs(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	var eventLines []byte
+	for _, e := range history {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		eventLines = append(append(eventLines, line...), '\n')
+	}
+
+	for file, contents := range map[string][]byte{
+		"inspect.json": inspect,
+		"stdout.log":   stdout,
+		"stderr.log":   stderr,
+		"events.json":  eventLines,
+	} {
+		err := os.WriteFile(filepath.Join(dir, file), contents, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if info.State == nil || !info.State.Running {
+		return nil
+	}
+	stats, err := s.client.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	defer stats.Body.Close()
+
+	f, err := os.Create(filepath.Join(dir, "stats.json"))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, stats.Body)
+	return errors.Join(err, f.Close())
+}
+
+// containerEvents returns the docker events for the containers of the
+// session since it was created, by container ID.
+func (s *Session) containerEvents(ctx context.Context) (map[string][]events.Message, error) {
+	messages, errs := s.client.Events(ctx, events.ListOptions{
+		Since: s.created.Format(time.RFC3339Nano),
+		Until: time.Now().Format(time.RFC3339Nano),
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("label", LabelSession+"="+s.id),
+		),
+	})
+
+	history := map[string][]events.Message{}
+	for {
+		select {
+		case e := <-messages:
+			history[e.Actor.ID] = append(history[e.Actor.ID], e)
+
+		case err := <-errs:
+			if err == nil || errors.Is(err, io.EOF) {
+				return history, nil
+			}
+			return nil, errors.Join(ErrDumpingDiagnostics, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package udock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// WithEnv sets environment variables in the container.  Variables that have
+// already been set, e.g. by WithProxyEnv, are replaced.
+func WithEnv(env map[string]string) ContainerOption {
+	return func(spec *containerSpec) error {
+		keys := make([]string, 0, len(env))
+		for key := range env {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			err := WithEnvVar(key, env[key])(spec)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithEnvVar sets the environment variable key to value in the container.  If
+// the variable has already been set it is replaced.
+func WithEnvVar(key string, value string) ContainerOption {
+	return func(spec *containerSpec) error {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("%w: invalid environment variable name %q", ErrInvalidOption, key)
+		}
+		value, err := spec.expand(value)
+		if err != nil {
+			return err
+		}
+		spec.config.Env = setEnv(spec.config.Env, key, value)
+		return nil
+	}
+}
+
+// setEnv sets key to value in env, which is a list of "key=value" strings.
+func setEnv(env []string, key string, value string) []string {
+	entry := key + "=" + value
+	for i, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			env[i] = entry
+			return env
+		}
+	}
+	return append(env, entry)
+}
+
+// WithEnvFile reads environment variables from a dotenv file and sets them in
+// the container.  Like with docker run --env-file each line is KEY=VALUE,
+// blank lines and lines starting with # are ignored, and a line with just
+// KEY takes the value from the environment of the current process, or is
+// skipped if it is not set there.  In addition values may be single or double
+// quoted, double quoted values may contain \n, \t, \" and \\ escapes, unquoted
+// values may be followed by a # comment, and lines may start with "export ".
+func WithEnvFile(path string) ContainerOption {
+	return func(spec *containerSpec) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: %s", ErrReadingEnvFile, path), err)
+		}
+		defer f.Close()
+
+		vars, err := parseEnvFile(f)
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: %s", ErrReadingEnvFile, path), err)
+		}
+
+		for _, v := range vars {
+			spec.config.Env = setEnv(spec.config.Env, v.key, v.value)
+		}
+		return nil
+	}
+}
+
+// envVar is an environment variable read from an env file.
+type envVar struct {
+	key   string
+	value string
+}
+
+// parseEnvFile parses the dotenv formatted r, see WithEnvFile.
+func parseEnvFile(r io.Reader) ([]envVar, error) {
+	var vars []envVar
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNum, key)
+		}
+
+		if !hasValue {
+			if value, ok := os.LookupEnv(key); ok {
+				vars = append(vars, envVar{key: key, value: value})
+			}
+			continue
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		vars = append(vars, envVar{key: key, value: value})
+	}
+
+	return vars, scanner.Err()
+}
+
+// parseEnvValue unquotes value and strips trailing comments from unquoted
+// values.
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return value[1 : end+1], nil
+
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				return sb.String(), nil
+
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case 'r':
+					sb.WriteByte('\r')
+				default:
+					sb.WriteByte(value[i])
+				}
+
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double quote")
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,79 @@
+package udock
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnv(t *testing.T) {
+	spec, err := newContainerSpec("postgres:16", nil,
+		func(spec *containerSpec) error {
+			spec.config.Env = []string{"HTTP_PROXY=http://proxy:3128"}
+			return nil
+		},
+		WithEnv(map[string]string{
+			"POSTGRES_USER":     "udock",
+			"POSTGRES_PASSWORD": "secret",
+		}),
+		WithEnvVar("POSTGRES_PASSWORD", "changed"),
+		WithEnvVar("HTTP_PROXY", ""),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"HTTP_PROXY=",
+		"POSTGRES_PASSWORD=changed",
+		"POSTGRES_USER=udock",
+	}, spec.config.Env)
+
+	_, err = newContainerSpec("postgres:16", nil, WithEnvVar("A=B", "C"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("postgres:16", nil, WithEnvVar("", "C"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestParseEnvFile(t *testing.T) {
+	t.Setenv("UDOCK_FROM_HOST", "host value")
+
+	vars, err := parseEnvFile(strings.NewReader(`
+# database settings
+POSTGRES_USER=udock
+export POSTGRES_DB = test # the database
+POSTGRES_PASSWORD="se\"cr#et\n"
+SINGLE='no \n escapes # here'
+EMPTY=
+UDOCK_FROM_HOST
+UDOCK_NOT_SET_ANYWHERE
+`))
+	require.NoError(t, err)
+	require.Equal(t, []envVar{
+		{key: "POSTGRES_USER", value: "udock"},
+		{key: "POSTGRES_DB", value: "test"},
+		{key: "POSTGRES_PASSWORD", value: "se\"cr#et\n"},
+		{key: "SINGLE", value: `no \n escapes # here`},
+		{key: "EMPTY", value: ""},
+		{key: "UDOCK_FROM_HOST", value: "host value"},
+	}, vars)
+
+	_, err = parseEnvFile(strings.NewReader(`A="unterminated`))
+	require.Error(t, err)
+
+	_, err = parseEnvFile(strings.NewReader(`=value`))
+	require.Error(t, err)
+}
+
+func TestWithEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.env")
+	require.NoError(t, os.WriteFile(path, []byte("A=1\nB=2\n"), 0o600))
+
+	spec, err := newContainerSpec("postgres:16", nil, WithEnvVar("A", "0"), WithEnvFile(path))
+	require.NoError(t, err)
+	require.Equal(t, []string{"A=1", "B=2"}, spec.config.Env)
+
+	_, err = newContainerSpec("postgres:16", nil, WithEnvFile(filepath.Join(t.TempDir(), "missing.env")))
+	require.ErrorIs(t, err, ErrReadingEnvFile)
+}
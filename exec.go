@@ -0,0 +1,87 @@
+package udock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ExecResult is the outcome of running a command in a container with Exec.
+type ExecResult struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// Exec runs cmd in the running container and waits for it to finish.  A
+// non-zero exit code is not an error, check ExitCode.
+func (s *Session) Exec(containerID string, cmd ...string) (ExecResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerExecTimeout)
+	defer cancel()
+
+	return s.exec(ctx, containerID, cmd, nil)
+}
+
+// exec runs cmd in the container and waits for it to finish or ctx to be
+// done.  If stdin is not nil it is written to the standard input of cmd,
+// which is closed afterwards.
+func (s *Session) exec(ctx context.Context, containerID string, cmd []string, stdin []byte) (ExecResult, error) {
+	created, err := s.client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return ExecResult{}, errors.Join(fmt.Errorf("%w: %v in %s", ErrExecuting, cmd, containerID), err)
+	}
+
+	resp, err := s.client.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return ExecResult{}, errors.Join(fmt.Errorf("%w: %v in %s", ErrExecuting, cmd, containerID), err)
+	}
+	defer resp.Close()
+
+	if stdin != nil {
+		// cmd may exit without reading all of stdin, and then its exit
+		// code tells what happened, so write errors are of no interest.
+		go func() {
+			_, _ = resp.Conn.Write(stdin)
+			_ = resp.CloseWrite()
+		}()
+	}
+
+	// The hijacked connection does not care about ctx, so we close it if
+	// ctx is done before the command is.
+	copied := make(chan error, 1)
+	var stdout, stderr bytes.Buffer
+	go func() {
+		_, err := stdcopy.StdCopy(&stdout, &stderr, resp.Reader)
+		copied <- err
+	}()
+
+	select {
+	case err = <-copied:
+		if err != nil {
+			return ExecResult{}, errors.Join(fmt.Errorf("%w: %v in %s", ErrExecuting, cmd, containerID), err)
+		}
+	case <-ctx.Done():
+		resp.Close()
+		return ExecResult{}, fmt.Errorf("%w: running %v in %s", ErrTimeout, cmd, containerID)
+	}
+
+	inspect, err := s.client.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return ExecResult{}, errors.Join(fmt.Errorf("%w: %v in %s", ErrExecuting, cmd, containerID), err)
+	}
+
+	return ExecResult{
+		ExitCode: inspect.ExitCode,
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package udock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+)
+
+// goldenRepository is the repository golden images are tagged in.
+const goldenRepository = "udock-golden"
+
+// RunGolden is Run for containers whose init steps are too slow to run for
+// every test run, e.g. migrating a database and loading it with fixtures.
+// The first time, the container is run as usual and then committed to a
+// locally tagged golden image.  After that, until the golden image is
+// removed, containers are created from the golden image and the init steps
+// and init containers are skipped.
+//
+// The tag is derived from the image and options of the spec and from key.
+// Init steps cannot be compared, so change key, e.g. to a hash of the
+// fixtures, whenever the init steps would produce a different result.
+//
+// Only the filesystem of the container ends up in the golden image.  Data
+// written to volumes, including the anonymous volumes of paths the image
+// declares as VOLUME, is not, so make sure the init steps write their
+// results elsewhere (e.g. set PGDATA for postgres).  Init containers run
+// before the container exists, so whatever they prepare, e.g. in a volume,
+// is not in the golden image either and has to be redone by the init steps.
+// Files added with WithFile do end up in the golden image, and so would
+// secrets, which is why specs with WithSecret are rejected.
+func (s *Session) RunGolden(spec ContainerSpec, key string) (string, error) {
+	spec, err := spec.expandVars()
+	if err != nil {
+		return "", err
+	}
+	tag, err := goldenTag(spec, key)
+	if err != nil {
+		return "", err
+	}
+
+	inspectCtx, inspectCancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer inspectCancel()
+
+	inspect, _, err := s.client.ImageInspectWithRaw(inspectCtx, tag)
+	if err == nil {
+		// The golden image is only as trustworthy as the image it was
+		// made from, so that is the one we verify.
+		err = s.verifyImage(spec.Image)
+		if err != nil {
+			return "", err
+		}
+		s.mu.Lock()
+		s.verified[inspect.ID] = true
+		s.mu.Unlock()
+
+		slog.Info("starting from golden image", "image", spec.Image, "golden", tag)
+		golden := spec
+		golden.Image = tag
+		golden.Init = nil
+		golden.InitContainers = nil
+		return s.run(context.Background(), golden, golden.Options...)
+	}
+	if !errdefs.IsNotFound(err) {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrInspectingImage, tag), err)
+	}
+
+	containerID, err := s.run(context.Background(), spec, spec.Options...)
+	if err != nil {
+		return "", err
+	}
+
+	// Setting up the container may take a long time, so the clock for
+	// committing it only starts now.
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCommitTimeout)
+	defer cancel()
+
+	_, err = s.client.ContainerCommit(ctx, containerID, container.CommitOptions{
+		Reference: tag,
+		Pause:     true,
+	})
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrCreatingGoldenImage, tag), err, s.RemoveContainer(containerID))
+	}
+	slog.Info("created golden image", "image", spec.Image, "golden", tag)
+
+	return containerID, nil
+}
+
+// RemoveGoldenImages removes all golden images, forcing RunGolden to run the
+// init steps again.
+func (s *Session) RemoveGoldenImages() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRemoveImageTimeout)
+	defer cancel()
+
+	images, err := s.client.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", goldenRepository)),
+	})
+	if err != nil {
+		return errors.Join(ErrListingImages, err)
+	}
+
+	var errs []error
+	for _, img := range images {
+		_, err := s.client.ImageRemove(ctx, img.ID, image.RemoveOptions{Force: true})
+		if err != nil {
+			errs = append(errs, errors.Join(fmt.Errorf("%w: %s", ErrRemovingImage, img.ID), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// goldenTag returns the tag of the golden image for spec and key.  The
+// session is left out so the golden image outlives it.  Specs with secrets
+// cannot have a golden image since the secrets would end up in it.
+func goldenTag(spec ContainerSpec, key string) (string, error) {
+	built, err := newContainerSpec(spec.Image, spec.Ports, spec.Options...)
+	if err != nil {
+		return "", err
+	}
+	if built.config.Labels[LabelSecrets] != "" {
+		return "", fmt.Errorf("%w: golden image of %s would contain secrets", ErrInvalidOption, spec.Image)
+	}
+	hash, err := built.hash()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(hash + "\x00" + key))
+	return goldenRepository + ":" + hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,39 @@
+package udock
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoldenTag(t *testing.T) {
+	spec := ContainerSpec{
+		Image:   "postgres:16",
+		Options: []ContainerOption{WithEnvVar("PGDATA", "/pgdata")},
+	}
+
+	tag, err := goldenTag(spec, "fixtures-v1")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(tag, goldenRepository+":"))
+
+	again, err := goldenTag(spec, "fixtures-v1")
+	require.NoError(t, err)
+	require.Equal(t, tag, again)
+
+	// changing the key invalidates the golden image
+	other, err := goldenTag(spec, "fixtures-v2")
+	require.NoError(t, err)
+	require.NotEqual(t, tag, other)
+
+	// and so does changing the spec
+	spec.Options = append(spec.Options, WithEnvVar("POSTGRES_PASSWORD", "secret"))
+	other, err = goldenTag(spec, "fixtures-v1")
+	require.NoError(t, err)
+	require.NotEqual(t, tag, other)
+
+	// secrets must not end up in the golden image
+	spec.Options = append(spec.Options, WithSecret(Secret{Value: []byte("secret"), File: "/run/secrets/pw"}))
+	_, err = goldenTag(spec, "fixtures-v1")
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
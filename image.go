@@ -0,0 +1,579 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TagImage creates the tag target referring to the source image.
+func (s *Session) TagImage(source string, target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTagImageTimeout)
+	defer cancel()
+
+	err := s.client.ImageTag(ctx, source, target)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s -> %s", ErrTaggingImage, source, target), err)
+	}
+	return nil
+}
+
+// PushOption is an option for PushImage.
+type PushOption func(*pushOptions)
+
+type pushOptions struct {
+	progress func(PushProgress)
+}
+
+// PushProgress is a progress update for one layer of an image being pushed.
+type PushProgress struct {
+	// Image is the image being pushed.
+	Image string
+	// Layer is the ID of the layer this update is for.  It is empty for
+	// updates that concern the image as a whole.
+	Layer string
+	// Status is the status reported by docker, e.g. "Pushing", "Layer
+	// already exists" or "Pushed".
+	Status string
+	// Current is the number of bytes uploaded so far.
+	Current int64
+	// Total is the size of the layer in bytes, if known.
+	Total int64
+}
+
+// WithPushProgress makes PushImage call fn for every progress update it gets
+// from docker while pushing.  fn is called from the goroutine calling
+// PushImage.
+func WithPushProgress(fn func(PushProgress)) PushOption {
+	return func(o *pushOptions) {
+		o.progress = fn
+	}
+}
+
+// PushImage pushes an image to a registry using the credentials in auth.
+// Progress is logged at debug level, and reported to the function given with
+// WithPushProgress, and errors reported by the registry during the push are
+// returned.  If auth is empty and the session was created with
+// WithDockerConfigAuth the credentials are looked up in the docker config.
+func (s *Session) PushImage(dockerImage string, auth RegistryAuth, opts ...PushOption) error {
+	var o pushOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var explicit *RegistryAuth
+	if auth != (RegistryAuth{}) {
+		explicit = &auth
+	}
+
+	encodedAuth, err := s.resolveAuth(dockerImage, explicit)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPushTimeout)
+	defer cancel()
+
+	slog.Info("pushing image", "dockerImage", dockerImage)
+	resp, err := s.client.ImagePush(ctx, dockerImage, image.PushOptions{RegistryAuth: encodedAuth})
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrPushingImage, dockerImage), err)
+	}
+	defer resp.Close()
+
+	err = readJSONMessages(resp, func(msg jsonmessage.JSONMessage) {
+		slog.Debug("push progress", "dockerImage", dockerImage, "id", msg.ID, "status", msg.Status, "progress", msg.ProgressMessage)
+		if o.progress != nil {
+			o.progress(newPushProgress(dockerImage, msg))
+		}
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrPushingImage, dockerImage), err)
+	}
+	slog.Info("done pushing image", "dockerImage", dockerImage)
+	return nil
+}
+
+// newPushProgress converts a message from the push stream to a PushProgress.
+func newPushProgress(dockerImage string, msg jsonmessage.JSONMessage) PushProgress {
+	progress := PushProgress{
+		Image:  dockerImage,
+		Layer:  msg.ID,
+		Status: msg.Status,
+	}
+	if msg.Progress != nil {
+		progress.Current = msg.Progress.Current
+		progress.Total = msg.Progress.Total
+	}
+	return progress
+}
+
+// ImageDigests returns the repository digests (e.g. "sha256:...") of a local
+// image.  Images that have never been pulled from or pushed to a registry
+// have no repository digests.
+func (s *Session) ImageDigests(dockerImage string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerImageVerifyTimeout)
+	defer cancel()
+
+	inspect, _, err := s.client.ImageInspectWithRaw(ctx, s.localImage(dockerImage))
+	if errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotPresent, dockerImage)
+	}
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrInspectingImage, dockerImage), err)
+	}
+
+	return repoDigests(inspect.RepoDigests), nil
+}
+
+// repoDigests extracts the unique digests from a list of repository digests
+// of the form repository@digest.
+func repoDigests(repoDigests []string) []string {
+	digests := []string{}
+	for _, repoDigest := range repoDigests {
+		_, dgst, ok := strings.Cut(repoDigest, "@")
+		if ok && !slices.Contains(digests, dgst) {
+			digests = append(digests, dgst)
+		}
+	}
+	return digests
+}
+
+// VerifyImageDigest returns a nil error if the local image dockerImage, which
+// is typically referenced by tag, resolves to the content with the digest
+// expected.  Use this to pin test environments to exact image content.
+func (s *Session) VerifyImageDigest(dockerImage string, expected string) error {
+	want, err := digest.Parse(expected)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrInvalidDigest, expected), err)
+	}
+
+	digests, err := s.ImageDigests(dockerImage)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(digests, want.String()) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is %v, expected %s", ErrDigestMismatch, dockerImage, digests, want)
+}
+
+// SaveImage writes the images in dockerImages to w as a tar archive in the
+// same format as docker save.  The archive can be loaded again with
+// LoadImage.
+func (s *Session) SaveImage(dockerImages []string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerSaveImageTimeout)
+	defer cancel()
+
+	resp, err := s.client.ImageSave(ctx, dockerImages)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %v", ErrSavingImage, dockerImages), err)
+	}
+	defer resp.Close()
+
+	_, err = io.Copy(w, resp)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %v", ErrSavingImage, dockerImages), err)
+	}
+	return nil
+}
+
+// LoadImage loads images from a tar archive as produced by SaveImage or
+// docker save.
+func (s *Session) LoadImage(r io.Reader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerLoadImageTimeout)
+	defer cancel()
+
+	resp, err := s.client.ImageLoad(ctx, r, true)
+	if err != nil {
+		return errors.Join(ErrLoadingImage, err)
+	}
+	defer resp.Body.Close()
+
+	if !resp.JSON {
+		_, err = io.Copy(io.Discard, resp.Body)
+		if err != nil {
+			return errors.Join(ErrLoadingImage, err)
+		}
+		return nil
+	}
+
+	err = readJSONMessages(resp.Body, func(msg jsonmessage.JSONMessage) {
+		if msg.Stream != "" {
+			slog.Info("loaded image", "message", strings.TrimSpace(msg.Stream))
+		}
+	})
+	if err != nil {
+		return errors.Join(ErrLoadingImage, err)
+	}
+	return nil
+}
+
+// PruneImagesOptions controls which images PruneImages removes.  The zero
+// value removes all dangling images.
+type PruneImagesOptions struct {
+	// All removes all unused images rather than just dangling ones.
+	All bool
+
+	// Until only removes images created more than Until ago.
+	Until time.Duration
+
+	// Labels only removes images with these labels.  Labels are given as
+	// "key" or "key=value".
+	Labels []string
+
+	// ExcludeLabels only removes images without these labels.  Labels are
+	// given as "key" or "key=value".
+	ExcludeLabels []string
+}
+
+// PruneReport describes what was removed by a prune operation.
+type PruneReport struct {
+	// Deleted lists the IDs of whatever was deleted.
+	Deleted []string
+
+	// SpaceReclaimed is the number of bytes freed.
+	SpaceReclaimed uint64
+}
+
+// PruneImages removes unused images and reports what was removed and how much
+// disk space was reclaimed.
+func (s *Session) PruneImages(opts PruneImagesOptions) (PruneReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPruneTimeout)
+	defer cancel()
+
+	report, err := s.client.ImagesPrune(ctx, opts.filters())
+	if err != nil {
+		return PruneReport{}, errors.Join(ErrPruningImages, err)
+	}
+
+	deleted := []string{}
+	for _, d := range report.ImagesDeleted {
+		if d.Deleted != "" {
+			deleted = append(deleted, d.Deleted)
+		}
+	}
+
+	return PruneReport{
+		Deleted:        deleted,
+		SpaceReclaimed: report.SpaceReclaimed,
+	}, nil
+}
+
+// filters returns the prune filters for the options.
+func (o PruneImagesOptions) filters() filters.Args {
+	args := filters.NewArgs()
+	if o.All {
+		args.Add("dangling", "false")
+	}
+	if o.Until > 0 {
+		args.Add("until", o.Until.String())
+	}
+	for _, label := range o.Labels {
+		args.Add("label", label)
+	}
+	for _, label := range o.ExcludeLabels {
+		args.Add("label!", label)
+	}
+	return args
+}
+
+// ImageHistoryEntry is an entry in the history of an image.  Each entry
+// corresponds to an instruction in the Dockerfile used to build the image.
+type ImageHistoryEntry struct {
+	// ID of the layer.  Layers that come from intermediate build images
+	// have the ID "<missing>".
+	ID string
+
+	// Created is when the layer was created.
+	Created time.Time
+
+	// CreatedBy is the command that created the layer.
+	CreatedBy string
+
+	// Size of the layer in bytes.  Instructions that only change metadata
+	// (ENV, CMD, LABEL etc) have zero size.
+	Size int64
+
+	// Comment associated with the layer.
+	Comment string
+
+	// Tags referring to the layer.
+	Tags []string
+}
+
+// ImageHistory returns the history of an image, newest layer first.
+func (s *Session) ImageHistory(dockerImage string) ([]ImageHistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer cancel()
+
+	items, err := s.client.ImageHistory(ctx, s.localImage(dockerImage))
+	if errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotPresent, dockerImage)
+	}
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrInspectingImage, dockerImage), err)
+	}
+
+	history := make([]ImageHistoryEntry, 0, len(items))
+	for _, item := range items {
+		history = append(history, ImageHistoryEntry{
+			ID:        item.ID,
+			Created:   time.Unix(item.Created, 0),
+			CreatedBy: item.CreatedBy,
+			Size:      item.Size,
+			Comment:   item.Comment,
+			Tags:      item.Tags,
+		})
+	}
+	return history, nil
+}
+
+// ImageInfo is a simplified view of the result of inspecting an image.
+type ImageInfo struct {
+	// ID is the content-addressable ID of the image.
+	ID string
+
+	// Tags lists the tags referring to the image.
+	Tags []string
+
+	// Digests lists the repository digests of the image.
+	Digests []string
+
+	// Created is when the image was created.
+	Created time.Time
+
+	// LastTagTime is when the image was last tagged locally, which
+	// PullImage does whenever it pulls it.  It is zero if the image has not
+	// been tagged since the docker daemon got it.
+	LastTagTime time.Time
+
+	// Size is the total size of the image in bytes.
+	Size int64
+
+	// Platform of the image, e.g. "linux/amd64".
+	Platform string
+
+	// Entrypoint of the image.
+	Entrypoint []string
+
+	// Cmd is the default command of the image.
+	Cmd []string
+
+	// Env is the environment of the image.
+	Env map[string]string
+
+	// ExposedPorts lists the ports exposed by the image in sorted order,
+	// e.g. "5432/tcp".
+	ExposedPorts []string
+
+	// Labels of the image.
+	Labels map[string]string
+
+	// WorkingDir is the working directory of the image.
+	WorkingDir string
+
+	// User is the user the image runs as.
+	User string
+}
+
+// InspectImage returns information about a local image.
+func (s *Session) InspectImage(dockerImage string) (ImageInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer cancel()
+
+	inspect, _, err := s.client.ImageInspectWithRaw(ctx, s.localImage(dockerImage))
+	if errdefs.IsNotFound(err) {
+		return ImageInfo{}, fmt.Errorf("%w: %s", ErrImageNotPresent, dockerImage)
+	}
+	if err != nil {
+		return ImageInfo{}, errors.Join(fmt.Errorf("%w: %s", ErrInspectingImage, dockerImage), err)
+	}
+
+	return newImageInfo(inspect), nil
+}
+
+// newImageInfo converts the result of inspecting an image to an ImageInfo.
+func newImageInfo(inspect types.ImageInspect) ImageInfo {
+	info := ImageInfo{
+		ID:           inspect.ID,
+		Tags:         inspect.RepoTags,
+		Digests:      repoDigests(inspect.RepoDigests),
+		Size:         inspect.Size,
+		Platform:     formatPlatform(&ocispec.Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant}),
+		Env:          map[string]string{},
+		ExposedPorts: []string{},
+		Labels:       map[string]string{},
+		LastTagTime:  inspect.Metadata.LastTagTime,
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+	if err == nil {
+		info.Created = created
+	}
+
+	if inspect.Config == nil {
+		return info
+	}
+
+	info.Entrypoint = inspect.Config.Entrypoint
+	info.Cmd = inspect.Config.Cmd
+	info.WorkingDir = inspect.Config.WorkingDir
+	info.User = inspect.Config.User
+	info.Env = parseEnv(inspect.Config.Env)
+	for port := range inspect.Config.ExposedPorts {
+		info.ExposedPorts = append(info.ExposedPorts, string(port))
+	}
+	slices.Sort(info.ExposedPorts)
+	for k, v := range inspect.Config.Labels {
+		info.Labels[k] = v
+	}
+	return info
+}
+
+// parseEnv converts a list of KEY=value strings to a map.
+func parseEnv(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
+
+// ListImagesOptions controls which images ListImages returns.  The zero value
+// lists all tagged images and dangling images.
+type ListImagesOptions struct {
+	// References only lists images matching one of these references.  The
+	// references may contain globs, e.g. "postgres:*" or "*/http-echo".
+	References []string
+
+	// Labels only lists images with these labels.  Labels are given as
+	// "key" or "key=value".
+	Labels []string
+
+	// Dangling only lists dangling (untagged) images.
+	Dangling bool
+
+	// Before only lists images created before the given image.
+	Before string
+
+	// Since only lists images created after the given image.
+	Since string
+}
+
+// ImageSummary is a summary of an image as returned by ListImages.
+type ImageSummary struct {
+	// ID is the content-addressable ID of the image.
+	ID string
+
+	// Tags lists the tags referring to the image.
+	Tags []string
+
+	// Digests lists the repository digests of the image.
+	Digests []string
+
+	// Created is when the image was created.
+	Created time.Time
+
+	// Size is the total size of the image in bytes.
+	Size int64
+
+	// Labels of the image.
+	Labels map[string]string
+}
+
+// ListImages lists the local images matching opts.
+func (s *Session) ListImages(opts ListImagesOptions) ([]ImageSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerImageVerifyTimeout)
+	defer cancel()
+
+	images, err := s.client.ImageList(ctx, image.ListOptions{Filters: opts.filters()})
+	if err != nil {
+		return nil, errors.Join(ErrListingImages, err)
+	}
+
+	summaries := make([]ImageSummary, 0, len(images))
+	for _, img := range images {
+		summaries = append(summaries, ImageSummary{
+			ID:      img.ID,
+			Tags:    img.RepoTags,
+			Digests: repoDigests(img.RepoDigests),
+			Created: time.Unix(img.Created, 0),
+			Size:    img.Size,
+			Labels:  img.Labels,
+		})
+	}
+	return summaries, nil
+}
+
+// filters returns the list filters for the options.
+func (o ListImagesOptions) filters() filters.Args {
+	args := filters.NewArgs()
+	for _, ref := range o.References {
+		args.Add("reference", ref)
+	}
+	for _, label := range o.Labels {
+		args.Add("label", label)
+	}
+	if o.Dangling {
+		args.Add("dangling", "true")
+	}
+	if o.Before != "" {
+		args.Add("before", o.Before)
+	}
+	if o.Since != "" {
+		args.Add("since", o.Since)
+	}
+	return args
+}
+
+// ImportImage creates the image dockerImage from a root filesystem tarball
+// read from r.  The tarball may be compressed.  changes are Dockerfile
+// instructions applied to the image, e.g. `CMD ["/bin/sh"]` or "ENV
+// FOO=bar", since an imported image has no configuration of its own.
+func (s *Session) ImportImage(r io.Reader, dockerImage string, changes ...string) error {
+	return s.importImage(image.ImportSource{Source: r, SourceName: "-"}, dockerImage, changes)
+}
+
+// ImportImageFromURL is like ImportImage but the docker daemon fetches the
+// root filesystem tarball from url.
+func (s *Session) ImportImageFromURL(url string, dockerImage string, changes ...string) error {
+	return s.importImage(image.ImportSource{SourceName: url}, dockerImage, changes)
+}
+
+// importImage does the work of ImportImage and ImportImageFromURL.
+func (s *Session) importImage(source image.ImportSource, dockerImage string, changes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerLoadImageTimeout)
+	defer cancel()
+
+	resp, err := s.client.ImageImport(ctx, source, dockerImage, image.ImportOptions{
+		Changes:  changes,
+		Platform: formatPlatform(s.platform),
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrImportingImage, dockerImage), err)
+	}
+	defer resp.Close()
+
+	err = readJSONMessages(resp, nil)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrImportingImage, dockerImage), err)
+	}
+	slog.Info("imported image", "dockerImage", dockerImage)
+	return nil
+}
@@ -0,0 +1,172 @@
+package udock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneImagesFilters(t *testing.T) {
+	args := PruneImagesOptions{}.filters()
+	require.Equal(t, 0, args.Len())
+
+	args = PruneImagesOptions{
+		All:           true,
+		Until:         24 * time.Hour,
+		Labels:        []string{"udock", "suite=integration"},
+		ExcludeLabels: []string{"keep"},
+	}.filters()
+
+	require.Equal(t, []string{"false"}, args.Get("dangling"))
+	require.Equal(t, []string{"24h0m0s"}, args.Get("until"))
+	require.ElementsMatch(t, []string{"udock", "suite=integration"}, args.Get("label"))
+	require.Equal(t, []string{"keep"}, args.Get("label!"))
+}
+
+func TestNewImageInfo(t *testing.T) {
+	info := newImageInfo(types.ImageInspect{
+		ID:           "sha256:aaaa",
+		RepoTags:     []string{"postgres:16"},
+		RepoDigests:  []string{"postgres@sha256:bbbb", "mirror.example.com/library/postgres@sha256:bbbb"},
+		Created:      "2024-01-02T03:04:05.123456789Z",
+		Size:         1234,
+		Os:           "linux",
+		Architecture: "arm64",
+		Variant:      "v8",
+		Metadata:     image.Metadata{LastTagTime: time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)},
+		Config: &container.Config{
+			Entrypoint:   []string{"docker-entrypoint.sh"},
+			Cmd:          []string{"postgres"},
+			Env:          []string{"PATH=/usr/bin:/bin", "PGDATA=/var/lib/postgresql/data", "EMPTY="},
+			ExposedPorts: nat.PortSet{"5432/tcp": {}, "1234/udp": {}},
+			Labels:       map[string]string{"maintainer": "someone"},
+		},
+	})
+
+	require.Equal(t, "sha256:aaaa", info.ID)
+	require.Equal(t, []string{"postgres:16"}, info.Tags)
+	require.Equal(t, []string{"sha256:bbbb"}, info.Digests)
+	require.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC), info.Created)
+	require.Equal(t, time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC), info.LastTagTime)
+	require.Equal(t, int64(1234), info.Size)
+	require.Equal(t, "linux/arm64/v8", info.Platform)
+	require.Equal(t, []string{"docker-entrypoint.sh"}, info.Entrypoint)
+	require.Equal(t, []string{"postgres"}, info.Cmd)
+	require.Equal(t, map[string]string{
+		"PATH":   "/usr/bin:/bin",
+		"PGDATA": "/var/lib/postgresql/data",
+		"EMPTY":  "",
+	}, info.Env)
+	require.Equal(t, []string{"1234/udp", "5432/tcp"}, info.ExposedPorts)
+	require.Equal(t, map[string]string{"maintainer": "someone"}, info.Labels)
+
+	// images without config are fine too
+	info = newImageInfo(types.ImageInspect{ID: "sha256:cccc", Os: "linux", Architecture: "amd64"})
+	require.Equal(t, "linux/amd64", info.Platform)
+	require.Empty(t, info.ExposedPorts)
+}
+
+func TestListImagesFilters(t *testing.T) {
+	args := ListImagesOptions{}.filters()
+	require.Equal(t, 0, args.Len())
+
+	args = ListImagesOptions{
+		References: []string{"postgres:*", "*/http-echo"},
+		Labels:     []string{"suite=integration"},
+		Dangling:   true,
+		Before:     "postgres:16",
+		Since:      "postgres:14",
+	}.filters()
+
+	require.ElementsMatch(t, []string{"postgres:*", "*/http-echo"}, args.Get("reference"))
+	require.Equal(t, []string{"suite=integration"}, args.Get("label"))
+	require.Equal(t, []string{"true"}, args.Get("dangling"))
+	require.Equal(t, []string{"postgres:16"}, args.Get("before"))
+	require.Equal(t, []string{"postgres:14"}, args.Get("since"))
+}
+
+func TestMirroredImageLookups(t *testing.T) {
+	const (
+		dgst   = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		pinned = "alpine@" + dgst
+		mirror = "mirror.internal.example.com/library/" + pinned
+	)
+
+	// a docker daemon that only knows the image by the mirror reference
+	// it was pulled as
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutPrefix(r.URL.Path, "/v1.45/images/")
+		switch {
+		case ok && name == mirror+"/json":
+			_ = json.NewEncoder(w).Encode(types.ImageInspect{ID: "sha256:aaaa", RepoDigests: []string{mirror}})
+		case ok && name == mirror+"/history":
+			_ = json.NewEncoder(w).Encode([]image.HistoryResponseItem{{ID: "sha256:aaaa", CreatedBy: "ADD rootfs"}})
+		default:
+			http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+	s := newSession(c)
+
+	_, err = s.ImageDigests(pinned)
+	require.ErrorIs(t, err, ErrImageNotPresent)
+
+	// pinned by digest alone there is no tag to add, so the daemon is not
+	// asked to tag anything
+	require.NoError(t, s.tagPulled(pinned, mirror))
+
+	digests, err := s.ImageDigests(pinned)
+	require.NoError(t, err)
+	require.Equal(t, []string{dgst}, digests)
+	require.NoError(t, s.VerifyImageDigest(pinned, dgst))
+
+	history, err := s.ImageHistory(pinned)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, "ADD rootfs", history[0].CreatedBy)
+}
+
+func TestPushProgress(t *testing.T) {
+	const img = "registry.example.com/app:1.0"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1.45/images/registry.example.com/app/push" {
+			http.Error(w, `{"message":"unexpected request"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"The push refers to repository [registry.example.com/app]"}
+{"status":"Pushing","id":"abc123","progressDetail":{"current":512,"total":2048}}
+{"status":"Pushed","id":"abc123"}
+`))
+	}))
+	defer server.Close()
+
+	c, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+	s := newSession(c)
+
+	var updates []PushProgress
+	err = s.PushImage(img, RegistryAuth{}, WithPushProgress(func(p PushProgress) {
+		updates = append(updates, p)
+	}))
+	require.NoError(t, err)
+
+	require.Equal(t, []PushProgress{
+		{Image: img, Status: "The push refers to repository [registry.example.com/app]"},
+		{Image: img, Layer: "abc123", Status: "Pushing", Current: 512, Total: 2048},
+		{Image: img, Layer: "abc123", Status: "Pushed"},
+	}, updates)
+}
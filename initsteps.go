@@ -0,0 +1,104 @@
+package udock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// InitStep is something Run does to a container after it has become ready,
+// e.g. loading a schema into a database, so tests get a container that is
+// already seeded.
+type InitStep interface {
+	// RunInitStep runs the step.  An error aborts Run and the container is
+	// removed.
+	RunInitStep(ctx context.Context, s *Session, containerID string) error
+}
+
+// InitFunc adapts a function to the InitStep interface.
+type InitFunc func(ctx context.Context, s *Session, containerID string) error
+
+// RunInitStep calls f.
+func (f InitFunc) RunInitStep(ctx context.Context, s *Session, containerID string) error {
+	return f(ctx, s, containerID)
+}
+
+// InitExec runs cmd in the container.  The step fails unless cmd exits with
+// code 0.
+func InitExec(cmd ...string) InitStep {
+	return InitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		return s.execOK(ctx, containerID, cmd, nil)
+	})
+}
+
+// InitScript runs cmd with script on its standard input and /dev/stdin as
+// its last argument, e.g. InitScript(schema, "psql", "-U", "postgres", "-f")
+// for loading a SQL file.  Nothing is written to the container, so this
+// works with WithReadOnlyRootFS too.  The step fails unless cmd exits with
+// code 0.
+func InitScript(script []byte, cmd ...string) InitStep {
+	return InitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		return s.execOK(ctx, containerID, append(slices.Clip(cmd), "/dev/stdin"), script)
+	})
+}
+
+// InitHTTP sends an HTTP request with body to urlPath on the host port mapped
+// to containerPort, e.g. for creating an index through a REST API.  The step
+// fails unless the response has a 2xx status code.
+func InitHTTP(method string, containerPort string, urlPath string, contentType string, body []byte) InitStep {
+	return InitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		addr, err := s.MappedAddress(containerID, containerPort)
+		if err != nil {
+			return err
+		}
+		url := "http://" + addr + urlPath
+
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s %s returned %s", method, urlPath, resp.Status)
+		}
+		return nil
+	})
+}
+
+// runInitSteps runs the steps in order, stopping at the first one that
+// fails.
+func (s *Session) runInitSteps(ctx context.Context, containerID string, steps []InitStep) error {
+	for i, step := range steps {
+		err := step.RunInitStep(ctx, s, containerID)
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: step %d for %s", ErrInitStep, i+1, containerID), err)
+		}
+	}
+	return nil
+}
+
+// execOK runs cmd in the container with stdin, see exec, and returns an
+// error, including the output of cmd, unless it exits with code 0.
+func (s *Session) execOK(ctx context.Context, containerID string, cmd []string, stdin []byte) error {
+	result, err := s.exec(ctx, containerID, cmd, stdin)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		output := bytes.TrimSpace(bytes.Join([][]byte{result.Stdout, result.Stderr}, nil))
+		return fmt.Errorf("%v exited with code %d: %s", cmd, result.ExitCode, output)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunInitSteps(t *testing.T) {
+	var ran []int
+	step := func(n int, err error) InitStep {
+		return InitFunc(func(ctx context.Context, s *Session, containerID string) error {
+			require.Equal(t, "abc123", containerID)
+			ran = append(ran, n)
+			return err
+		})
+	}
+
+	s := &Session{}
+	require.NoError(t, s.runInitSteps(context.Background(), "abc123", []InitStep{step(1, nil), step(2, nil)}))
+	require.Equal(t, []int{1, 2}, ran)
+
+	ran = nil
+	failure := errors.New("schema already exists")
+	err := s.runInitSteps(context.Background(), "abc123", []InitStep{step(1, nil), step(2, failure), step(3, nil)})
+	require.ErrorIs(t, err, ErrInitStep)
+	require.ErrorIs(t, err, failure)
+	require.Equal(t, []int{1, 2}, ran)
+}
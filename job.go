@@ -0,0 +1,214 @@
+package udock
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// JobSpec describes a container for RunJob that runs to completion, e.g. a
+// migration, a code generator or a test suite running against other
+// containers.
+type JobSpec struct {
+	// ContainerSpec of the job.  The init containers are run before the
+	// job, the rest of what has to do with readiness, sidecars,
+	// dependencies and reuse is ignored.
+	ContainerSpec
+
+	// Timeout is how long the job gets to exit once it has been started.
+	// The default is ten minutes.  The init containers are not counted,
+	// they each get their own WaitTimeout.
+	Timeout time.Duration
+
+	// Outputs maps paths in the container to directories on the host the
+	// file or directory at the path is copied into once the job has
+	// exited, like docker cp does, e.g. "/out" ends up as "dir/out".  The
+	// outputs are copied whatever the exit code, and when the job times
+	// out, since the output of a failed job is often what explains why it
+	// failed.  Outputs the job did not create are skipped.
+	Outputs map[string]string
+}
+
+// JobResult is the outcome of a job run with RunJob.
+type JobResult struct {
+	// ExitCode of the job, or -1 if it timed out and was killed.
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+
+	// Duration is the wall time from starting the container until it
+	// exited or timed out.
+	Duration time.Duration
+}
+
+// RunJob creates and starts a container as described by spec, waits for it to
+// exit, copies its outputs and removes it.  A non-zero exit code is not an
+// error, check ExitCode.  If the job times out it is killed and RunJob fails
+// with ErrTimeout, and if copying the outputs fails RunJob fails as well, but
+// in both cases the result holds what the job got to write.
+func (s *Session) RunJob(spec JobSpec) (JobResult, error) {
+	containerSpec, err := spec.expandVars()
+	if err != nil {
+		return JobResult{}, err
+	}
+
+	for _, initContainer := range containerSpec.InitContainers {
+		err := s.runInitContainer(context.Background(), initContainer)
+		if err != nil {
+			return JobResult{}, err
+		}
+	}
+
+	containerID, err := s.CreateContainer(containerSpec.Image, containerSpec.Name, containerSpec.Ports,
+		append(slices.Clip(containerSpec.Options), WithAutoRemove(false))...)
+	if err != nil {
+		return JobResult{}, err
+	}
+	defer func() {
+		_ = s.RemoveContainer(containerID)
+	}()
+
+	timeout := spec.Timeout
+	if timeout == 0 {
+		timeout = defaultJobTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	var errs []error
+	result, err := s.startToCompletion(ctx, containerID)
+	if errors.Is(err, ErrTimeout) {
+		errs = append(errs, err)
+		result, err = s.killJob(containerID, time.Since(start))
+	}
+	if err != nil {
+		return JobResult{}, errors.Join(append(errs, err)...)
+	}
+	jobResult := JobResult{
+		ExitCode: int(result.exitCode),
+		Stdout:   result.stdout,
+		Stderr:   result.stderr,
+		Duration: result.duration,
+	}
+
+	for _, containerPath := range sortedKeys(spec.Outputs) {
+		err := s.copyFromContainer(containerID, containerPath, spec.Outputs[containerPath])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return jobResult, errors.Join(errs...)
+}
+
+// killJob kills a job that has timed out and collects what it has logged so
+// far.
+func (s *Session) killJob(containerID string, duration time.Duration) (runResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRemoveContainerTimeout)
+	defer cancel()
+
+	err := s.client.ContainerKill(ctx, containerID, "KILL")
+	if err != nil && !errdefs.IsConflict(err) {
+		return runResult{}, errors.Join(fmt.Errorf("%w: %s", ErrStoppingContainer, containerID), err)
+	}
+
+	stdout, stderr, err := s.containerLogs(ctx, containerID)
+	if err != nil {
+		return runResult{}, err
+	}
+	return runResult{
+		exitCode: -1,
+		stdout:   stdout,
+		stderr:   stderr,
+		duration: duration,
+	}, nil
+}
+
+// copyFromContainer copies the file or directory at containerPath in the
+// container into the directory dir on the host.  Paths that do not exist in
+// the container are skipped.
+func (s *Session) copyFromContainer(containerID string, containerPath string, dir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCopyTimeout)
+	defer cancel()
+
+	rc, _, err := s.client.CopyFromContainer(ctx, containerID, containerPath)
+	if errdefs.IsNotFound(err) {
+		slog.Info("job output does not exist, skipping it", "id", containerID, "path", containerPath)
+		return nil
+	}
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrCopyingFromContainer, containerPath), err)
+	}
+	defer rc.Close()
+
+	err = extractTar(rc, dir)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrCopyingFromContainer, containerPath), err)
+	}
+	return nil
+}
+
+// extractTar extracts the directories and regular files of the tar stream r
+// into dir, creating dir if need be.  Entries that would end up outside dir
+// are rejected, and links and other special files are skipped.
+func extractTar(r io.Reader, dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("refusing to extract %q outside %s", hdr.Name, dir)
+		}
+		target := filepath.Join(dir, hdr.Name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err := os.MkdirAll(target, hdr.FileInfo().Mode().Perm()|0o700)
+			if err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			err := extractFile(tr, target, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractFile writes the contents of r to the file path.
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	return errors.Join(err, f.Close())
+}
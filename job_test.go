@@ -0,0 +1,38 @@
+package udock
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "out/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "out/report.xml", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5}))
+	_, err := tw.Write([]byte("<ok/>"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "out/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+	require.NoError(t, tw.Close())
+
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	require.NoError(t, extractTar(&buf, dir))
+
+	data, err := os.ReadFile(filepath.Join(dir, "out", "report.xml"))
+	require.NoError(t, err)
+	require.Equal(t, "<ok/>", string(data))
+
+	_, err = os.Lstat(filepath.Join(dir, "out", "link"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0o644}))
+	require.NoError(t, tw.Close())
+	require.Error(t, extractTar(&buf, dir))
+}
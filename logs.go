@@ -0,0 +1,53 @@
+package udock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// containerLogs returns what the container has written to stdout and stderr
+// so far.  Containers with a TTY only have a single stream, which we return
+// as stdout.
+func (s *Session) containerLogs(ctx context.Context, containerID string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	err := s.copyLogs(ctx, containerID, false, &stdout, &stderr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// copyLogs copies the output of the container to stdout and stderr.  If
+// follow is set we keep copying until the container exits or ctx is done.
+func (s *Session) copyLogs(ctx context.Context, containerID string, follow bool, stdout io.Writer, stderr io.Writer) error {
+	info, err := s.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+	}
+
+	logs, err := s.client.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrReadingLogs, containerID), err)
+	}
+	defer logs.Close()
+
+	if info.Config != nil && info.Config.Tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return errors.Join(fmt.Errorf("%w: %s", ErrReadingLogs, containerID), err)
+	}
+	return nil
+}
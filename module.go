@@ -0,0 +1,48 @@
+package udock
+
+import (
+	"context"
+	"slices"
+)
+
+// Module is a preset for running a particular piece of software, e.g. a
+// database or a message broker, that knows how to configure it, when it is
+// ready and what to do once it is.  Modules usually also have typed
+// accessors for what tests need to know, e.g. the address of a broker, that
+// they fill in from PostStart.
+//
+// Modules are run with RunModule, or turned into a spec with ModuleSpec
+// so they can be run alongside other specs, e.g. with RunAll.  See the
+// packages under modules for examples.
+type Module interface {
+	// Spec returns the spec of the container.  The wait strategy and
+	// init steps of the module are added to it.
+	Spec() ContainerSpec
+
+	// WaitStrategy decides when the container is ready.  It may return
+	// nil, in which case the container is considered ready as soon as it
+	// is running.
+	WaitStrategy() WaitStrategy
+
+	// PostStart is called once the container is ready, after the init
+	// steps of the spec.  An error fails the run and the container is
+	// removed.
+	PostStart(ctx context.Context, s *Session, containerID string) error
+}
+
+// ModuleSpec returns the spec for running the module, with the wait strategy
+// of the module and with PostStart as the last init step.
+func ModuleSpec(m Module) ContainerSpec {
+	spec := m.Spec()
+	if strategy := m.WaitStrategy(); strategy != nil {
+		spec.WaitFor = strategy
+	}
+	spec.Init = append(slices.Clip(spec.Init), InitFunc(m.PostStart))
+	return spec
+}
+
+// RunModule runs the module as Run would, and returns the ID of its
+// container.
+func (s *Session) RunModule(m Module) (string, error) {
+	return s.Run(ModuleSpec(m))
+}
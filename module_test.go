@@ -0,0 +1,44 @@
+package udock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testModule is a module that records what is done to it.
+type testModule struct {
+	containerID string
+}
+
+func (m *testModule) Spec() ContainerSpec {
+	return ContainerSpec{
+		Image: "redis:7",
+		Name:  "cache",
+		Init:  []InitStep{InitExec("redis-cli", "ping")},
+	}
+}
+
+func (m *testModule) WaitStrategy() WaitStrategy {
+	return WaitForPort("6379")
+}
+
+func (m *testModule) PostStart(_ context.Context, _ *Session, containerID string) error {
+	m.containerID = containerID
+	return nil
+}
+
+func TestModuleSpec(t *testing.T) {
+	m := &testModule{}
+	spec := ModuleSpec(m)
+
+	require.Equal(t, "redis:7", spec.Image)
+	require.Equal(t, "cache", spec.Name)
+	require.NotNil(t, spec.WaitFor)
+	require.Len(t, spec.Init, 2)
+
+	// PostStart is the last init step
+	require.NoError(t, spec.Init[1].RunInitStep(context.Background(), nil, "abc123"))
+	require.Equal(t, "abc123", m.containerID)
+}
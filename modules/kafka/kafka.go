@@ -0,0 +1,242 @@
+// Package kafka runs a single node Apache Kafka broker in KRaft mode for
+// tests, taking care of the advertised listeners so clients both on the host
+// and in other containers can reach it.
+//
+// Kafka hands clients the addresses they should talk to, so the address a
+// broker advertises has to be the one the client can reach it at.  For
+// clients on the host that is the host port docker published the broker
+// port on, which is not known until the container has started.  We start the
+// container with an entrypoint that waits for us to tell it the host port
+// before it starts Kafka.
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/borud/udock"
+)
+
+const (
+	// DefaultImage is the image Run uses unless WithImage says otherwise.
+	DefaultImage = "apache/kafka:3.8.0"
+
+	// brokerPort is the listener for clients on the host.  It is
+	// published on a host port picked by docker.
+	brokerPort = "9092"
+
+	// internalPort is the listener for clients in other containers on
+	// the same network, and for the broker talking to itself.
+	internalPort = "19092"
+
+	// controllerPort is the KRaft controller listener.
+	controllerPort = "9093"
+
+	// clusterID is the ID of the single node cluster.  It has to be a base64
+	// encoded UUID.
+	clusterID = "MkU3OEVBNTcwNTJENDM2Qk"
+
+	// listenersFile is where we write the advertised listeners once we
+	// know the host port, and the entrypoint waits for.
+	listenersFile = "/tmp/udock-kafka-listeners"
+
+	// runScript starts Kafka in the apache/kafka image.
+	runScript = "/etc/kafka/docker/run"
+
+	// readyLog is what the broker logs when it is ready for clients.
+	readyLog = "Kafka Server started"
+
+	// defaultStartTimeout is how long we give the broker to start.
+	defaultStartTimeout = 2 * time.Minute
+)
+
+// Option is an option for New and Run.
+type Option func(*options)
+
+type options struct {
+	image            string
+	name             string
+	startTimeout     time.Duration
+	containerOptions []udock.ContainerOption
+}
+
+// WithImage makes Run use image rather than DefaultImage.  The image must be
+// configured like the apache/kafka image, through KAFKA_ environment
+// variables and with /etc/kafka/docker/run to start it.
+func WithImage(image string) Option {
+	return func(o *options) {
+		o.image = image
+	}
+}
+
+// WithName sets the name of the container, which is also the hostname of the
+// broker on the network.  By default a unique name is generated.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithStartTimeout sets how long the broker gets to start.  The default is
+// two minutes.
+func WithStartTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.startTimeout = timeout
+	}
+}
+
+// WithContainerOptions passes options on to the container, e.g.
+// udock.WithEnvVar("KAFKA_NUM_PARTITIONS", "3") for configuring the broker.
+// The entrypoint gets the advertised listeners through a file in /tmp, where
+// the broker also keeps its data, and the image writes its configuration as
+// it starts, so with udock.WithReadOnlyRootFS those paths have to be made
+// writable, e.g. udock.WithReadOnlyRootFS("/tmp", "/opt/kafka/config").
+func WithContainerOptions(opts ...udock.ContainerOption) Option {
+	return func(o *options) {
+		o.containerOptions = append(o.containerOptions, opts...)
+	}
+}
+
+// Kafka is a broker.  It is a udock.Module, so it can be run with
+// udock.RunModule or alongside other containers with udock.ModuleSpec, and
+// its accessors work once it has been started.
+type Kafka struct {
+	options
+
+	session     *udock.Session
+	containerID string
+	brokerAddr  string
+}
+
+// New returns a broker that has not been started yet.
+func New(opts ...Option) *Kafka {
+	k := &Kafka{
+		options: options{
+			image:        DefaultImage,
+			startTimeout: defaultStartTimeout,
+		},
+	}
+	for _, opt := range opts {
+		opt(&k.options)
+	}
+	if k.name == "" {
+		k.name = udock.UniqueName("kafka", "")
+	}
+	return k
+}
+
+// Run starts a broker in the session, pulling the image if we do not have
+// it, and waits until it is ready for clients.
+func Run(s *udock.Session, opts ...Option) (*Kafka, error) {
+	k := New(opts...)
+
+	err := s.PullImage(k.image)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.RunModule(k)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Spec implements udock.Module.
+func (k *Kafka) Spec() udock.ContainerSpec {
+	return udock.ContainerSpec{
+		Image: k.image,
+		Name:  k.name,
+		Options: append([]udock.ContainerOption{
+			udock.WithPublishedPorts(brokerPort),
+			udock.WithHostname(k.name),
+			udock.WithEnv(environment()),
+			udock.WithEntrypoint("/bin/sh", "-c", entrypoint()),
+		}, k.containerOptions...),
+		WaitTimeout: k.startTimeout,
+	}
+}
+
+// WaitStrategy implements udock.Module.  Kafka does not start until
+// PostStart has told it what to advertise, so there is nothing to wait for
+// before that.
+func (k *Kafka) WaitStrategy() udock.WaitStrategy {
+	return nil
+}
+
+// PostStart implements udock.Module.  It tells the entrypoint which
+// addresses to advertise, now that we know the host port, which makes it
+// start Kafka, and waits for the broker to be ready.
+func (k *Kafka) PostStart(ctx context.Context, s *udock.Session, containerID string) error {
+	k.session = s
+	k.containerID = containerID
+
+	brokerAddr, err := s.MappedAddress(containerID, brokerPort)
+	if err != nil {
+		return err
+	}
+	k.brokerAddr = brokerAddr
+
+	listeners := advertisedListeners(k.brokerAddr, k.InternalBrokerAddr())
+	err = udock.InitExec("/bin/sh", "-c", `printf '%s\n' "$1" > "$2.tmp" && mv "$2.tmp" "$2"`,
+		"sh", "export KAFKA_ADVERTISED_LISTENERS="+listeners, listenersFile).RunInitStep(ctx, s, containerID)
+	if err != nil {
+		return fmt.Errorf("writing advertised listeners to %s: %w", listenersFile, err)
+	}
+
+	return udock.WaitForLog(readyLog).WaitUntilReady(ctx, s, containerID)
+}
+
+// BrokerAddr returns the host:port address clients on the host, e.g. the
+// test, use to bootstrap.
+func (k *Kafka) BrokerAddr() string {
+	return k.brokerAddr
+}
+
+// InternalBrokerAddr returns the host:port address clients in other
+// containers on the same network use to bootstrap.
+func (k *Kafka) InternalBrokerAddr() string {
+	return net.JoinHostPort(k.name, internalPort)
+}
+
+// ContainerID returns the ID of the container of the broker.
+func (k *Kafka) ContainerID() string {
+	return k.containerID
+}
+
+// Remove removes the broker.
+func (k *Kafka) Remove() error {
+	return k.session.RemoveContainer(k.containerID)
+}
+
+// environment returns the configuration of the broker, except for the
+// advertised listeners.
+func environment() map[string]string {
+	return map[string]string{
+		"CLUSTER_ID":                                     clusterID,
+		"KAFKA_NODE_ID":                                  "1",
+		"KAFKA_PROCESS_ROLES":                            "broker,controller",
+		"KAFKA_LISTENERS":                                "PLAINTEXT://0.0.0.0:" + brokerPort + ",BROKER://0.0.0.0:" + internalPort + ",CONTROLLER://0.0.0.0:" + controllerPort,
+		"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP":           "PLAINTEXT:PLAINTEXT,BROKER:PLAINTEXT,CONTROLLER:PLAINTEXT",
+		"KAFKA_INTER_BROKER_LISTENER_NAME":               "BROKER",
+		"KAFKA_CONTROLLER_LISTENER_NAMES":                "CONTROLLER",
+		"KAFKA_CONTROLLER_QUORUM_VOTERS":                 "1@localhost:" + controllerPort,
+		"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR":         "1",
+		"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR": "1",
+		"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR":            "1",
+		"KAFKA_GROUP_INITIAL_REBALANCE_DELAY_MS":         "0",
+	}
+}
+
+// advertisedListeners returns the listeners the broker advertises.
+func advertisedListeners(brokerAddr string, internalAddr string) string {
+	return "PLAINTEXT://" + brokerAddr + ",BROKER://" + internalAddr
+}
+
+// entrypoint returns the script that waits for the advertised listeners and
+// then starts Kafka.
+func entrypoint() string {
+	return fmt.Sprintf("while [ ! -f %[1]s ]; do sleep 0.1; done; . %[1]s; exec %[2]s", listenersFile, runScript)
+}
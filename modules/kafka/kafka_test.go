@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/borud/udock"
+	"github.com/stretchr/testify/require"
+)
+
+var _ udock.Module = (*Kafka)(nil)
+
+func TestConfiguration(t *testing.T) {
+	env := environment()
+	require.Equal(t, "broker,controller", env["KAFKA_PROCESS_ROLES"])
+	require.Equal(t, "BROKER", env["KAFKA_INTER_BROKER_LISTENER_NAME"])
+	require.NotContains(t, env, "KAFKA_ADVERTISED_LISTENERS")
+
+	require.Equal(t, "PLAINTEXT://127.0.0.1:32768,BROKER://kafka-1:19092", advertisedListeners("127.0.0.1:32768", "kafka-1:19092"))
+	require.Contains(t, entrypoint(), listenersFile)
+	require.Contains(t, entrypoint(), "exec "+runScript)
+}
+
+func TestRun(t *testing.T) {
+	udock.SkipIfUnavailable(t)
+
+	session, err := udock.Create(udock.WithSessionNetwork())
+	require.NoError(t, err)
+	defer session.Close()
+
+	k, err := Run(session)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, k.Remove())
+	}()
+
+	require.NotEmpty(t, k.BrokerAddr())
+	brokers, err := metadataBrokers(k.BrokerAddr())
+	require.NoError(t, err)
+	require.Equal(t, []string{k.BrokerAddr()}, brokers)
+}
+
+// metadataBrokers sends a Metadata request (v0) to the broker at addr and
+// returns the addresses of the brokers in the response, which are the
+// addresses the brokers advertise to clients.
+func metadataBrokers(addr string) ([]string, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	const clientID = "udock"
+	var req bytes.Buffer
+	_ = binary.Write(&req, binary.BigEndian, int16(3)) // Metadata
+	_ = binary.Write(&req, binary.BigEndian, int16(0)) // version
+	_ = binary.Write(&req, binary.BigEndian, int32(1)) // correlation ID
+	_ = binary.Write(&req, binary.BigEndian, int16(len(clientID)))
+	req.WriteString(clientID)
+	_ = binary.Write(&req, binary.BigEndian, int32(0)) // all topics
+
+	err = binary.Write(conn, binary.BigEndian, int32(req.Len()))
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var size int32
+	err = binary.Read(conn, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, size)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(resp)
+	var correlationID, count int32
+	err = errors.Join(
+		binary.Read(r, binary.BigEndian, &correlationID),
+		binary.Read(r, binary.BigEndian, &count),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if correlationID != 1 {
+		return nil, fmt.Errorf("got correlation ID %d, want 1", correlationID)
+	}
+
+	var brokers []string
+	for range count {
+		var (
+			nodeID, port int32
+			hostLen      int16
+		)
+		err = errors.Join(
+			binary.Read(r, binary.BigEndian, &nodeID),
+			binary.Read(r, binary.BigEndian, &hostLen),
+		)
+		if err != nil {
+			return nil, err
+		}
+		host := make([]byte, hostLen)
+		_, err = io.ReadFull(r, host)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(r, binary.BigEndian, &port)
+		if err != nil {
+			return nil, err
+		}
+		brokers = append(brokers, net.JoinHostPort(string(host), strconv.Itoa(int(port))))
+	}
+	return brokers, nil
+}
@@ -0,0 +1,264 @@
+// Package minio runs a MinIO server for tests, so code that talks to S3 can
+// be tested offline.  Buckets can be created when the server starts, with
+// WithBuckets, or later with CreateBucket, without pulling in an S3 client.
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/borud/udock"
+)
+
+const (
+	// DefaultImage is the image Run uses unless WithImage says otherwise.
+	DefaultImage = "minio/minio:RELEASE.2024-12-18T13-15-44Z"
+
+	// DefaultAccessKey and DefaultSecretKey are the credentials of the
+	// server unless WithCredentials says otherwise.
+	DefaultAccessKey = "minioadmin"
+	DefaultSecretKey = "minioadmin"
+
+	// Region is the region the server claims to be in, which is what
+	// clients should be configured with.
+	Region = "us-east-1"
+
+	// apiPort is the port of the S3 API.
+	apiPort = "9000"
+
+	// readyPath is the readiness probe of the server.
+	readyPath = "/minio/health/ready"
+
+	// defaultStartTimeout is how long we give the server to start.
+	defaultStartTimeout = time.Minute
+
+	// requestTimeout is the timeout for the requests we make to the
+	// server.
+	requestTimeout = 10 * time.Second
+)
+
+// ErrCreatingBucket is returned when a bucket cannot be created.
+var ErrCreatingBucket = errors.New("error creating bucket")
+
+// Option is an option for New and Run.
+type Option func(*options)
+
+type options struct {
+	image            string
+	name             string
+	accessKey        string
+	secretKey        string
+	buckets          []string
+	startTimeout     time.Duration
+	containerOptions []udock.ContainerOption
+}
+
+// WithImage makes Run use image rather than DefaultImage.
+func WithImage(image string) Option {
+	return func(o *options) {
+		o.image = image
+	}
+}
+
+// WithName sets the name of the container, which is also its hostname on the
+// network.  By default a unique name is generated.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithCredentials sets the access key and secret key of the root user.  MinIO
+// wants secret keys of at least eight characters.
+func WithCredentials(accessKey string, secretKey string) Option {
+	return func(o *options) {
+		o.accessKey = accessKey
+		o.secretKey = secretKey
+	}
+}
+
+// WithBuckets makes Run create the buckets once the server is ready.
+func WithBuckets(buckets ...string) Option {
+	return func(o *options) {
+		o.buckets = append(o.buckets, buckets...)
+	}
+}
+
+// WithStartTimeout sets how long the server gets to start and have the
+// buckets created.  The default is one minute.
+func WithStartTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.startTimeout = timeout
+	}
+}
+
+// WithContainerOptions passes options on to the container, e.g.
+// udock.WithVolumeMount for keeping the data in a volume.
+func WithContainerOptions(opts ...udock.ContainerOption) Option {
+	return func(o *options) {
+		o.containerOptions = append(o.containerOptions, opts...)
+	}
+}
+
+// MinIO is a MinIO server.  It is a udock.Module, so it can be run with
+// udock.RunModule or alongside other containers with udock.ModuleSpec, and
+// its accessors work once it has been started.
+type MinIO struct {
+	options
+
+	session     *udock.Session
+	containerID string
+	endpoint    string
+}
+
+// New returns a server that has not been started yet.
+func New(opts ...Option) *MinIO {
+	m := &MinIO{
+		options: options{
+			image:        DefaultImage,
+			accessKey:    DefaultAccessKey,
+			secretKey:    DefaultSecretKey,
+			startTimeout: defaultStartTimeout,
+		},
+	}
+	for _, opt := range opts {
+		opt(&m.options)
+	}
+	if m.name == "" {
+		m.name = udock.UniqueName("minio", "")
+	}
+	return m
+}
+
+// Run starts a server in the session, pulling the image if we do not have
+// it, waits until it is ready and creates the buckets.
+func Run(s *udock.Session, opts ...Option) (*MinIO, error) {
+	m := New(opts...)
+
+	err := s.PullImage(m.image)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.RunModule(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Spec implements udock.Module.
+func (m *MinIO) Spec() udock.ContainerSpec {
+	return udock.ContainerSpec{
+		Image: m.image,
+		Name:  m.name,
+		Options: append([]udock.ContainerOption{
+			udock.WithPublishedPorts(apiPort),
+			udock.WithEnv(map[string]string{
+				"MINIO_ROOT_USER":     m.accessKey,
+				"MINIO_ROOT_PASSWORD": m.secretKey,
+			}),
+			udock.WithCmd("server", "/data"),
+		}, m.containerOptions...),
+		WaitTimeout: m.startTimeout,
+	}
+}
+
+// WaitStrategy implements udock.Module.  The server is ready once its
+// readiness probe says so.
+func (m *MinIO) WaitStrategy() udock.WaitStrategy {
+	return udock.WaitForHTTP(apiPort, readyPath)
+}
+
+// PostStart implements udock.Module.  It looks up the host address the API
+// is published on and creates the buckets.
+func (m *MinIO) PostStart(ctx context.Context, s *udock.Session, containerID string) error {
+	m.session = s
+	m.containerID = containerID
+
+	endpoint, err := s.MappedAddress(containerID, apiPort)
+	if err != nil {
+		return err
+	}
+	m.endpoint = endpoint
+
+	for _, bucket := range m.buckets {
+		err := m.createBucket(ctx, bucket)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Endpoint returns the host:port address of the S3 API for clients on the
+// host, e.g. the test.
+func (m *MinIO) Endpoint() string {
+	return m.endpoint
+}
+
+// URL returns the URL of the S3 API for clients on the host.
+func (m *MinIO) URL() string {
+	return "http://" + m.endpoint
+}
+
+// InternalEndpoint returns the host:port address of the S3 API for clients in
+// other containers on the same network.
+func (m *MinIO) InternalEndpoint() string {
+	return net.JoinHostPort(m.name, apiPort)
+}
+
+// AccessKey returns the access key of the root user.
+func (m *MinIO) AccessKey() string {
+	return m.accessKey
+}
+
+// SecretKey returns the secret key of the root user.
+func (m *MinIO) SecretKey() string {
+	return m.secretKey
+}
+
+// ContainerID returns the ID of the container of the server.
+func (m *MinIO) ContainerID() string {
+	return m.containerID
+}
+
+// Remove removes the server.
+func (m *MinIO) Remove() error {
+	return m.session.RemoveContainer(m.containerID)
+}
+
+// CreateBucket creates a bucket.  Creating a bucket that already exists is
+// not an error.
+func (m *MinIO) CreateBucket(bucket string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	return m.createBucket(ctx, bucket)
+}
+
+// createBucket does the work of CreateBucket.
+func (m *MinIO) createBucket(ctx context.Context, bucket string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, m.URL()+"/"+bucket, nil)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrCreatingBucket, bucket), err)
+	}
+	sign(req, m.accessKey, m.secretKey, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrCreatingBucket, bucket), err)
+	}
+	defer resp.Body.Close()
+
+	// MinIO answers 409 with BucketAlreadyOwnedByYou for buckets we have
+	// already created.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("%w: %s: %s", ErrCreatingBucket, bucket, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package minio
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/borud/udock"
+	"github.com/stretchr/testify/require"
+)
+
+var _ udock.Module = (*MinIO)(nil)
+
+func TestSign(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	sign := func(secretKey string) *http.Request {
+		req, err := http.NewRequest(http.MethodPut, "http://127.0.0.1:9000/bucket", nil)
+		require.NoError(t, err)
+		sign(req, "access", secretKey, now)
+		return req
+	}
+
+	req := sign("secret123")
+	require.Equal(t, "20240501T123000Z", req.Header.Get("X-Amz-Date"))
+	require.Equal(t, emptySHA256, req.Header.Get("X-Amz-Content-Sha256"))
+
+	auth := req.Header.Get("Authorization")
+	require.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/20240501/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="))
+
+	// the signature depends on the secret key, and only on that
+	require.Equal(t, auth, sign("secret123").Header.Get("Authorization"))
+	require.NotEqual(t, auth, sign("secret456").Header.Get("Authorization"))
+}
+
+func TestRun(t *testing.T) {
+	udock.SkipIfUnavailable(t)
+
+	session, err := udock.Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	m, err := Run(session, WithCredentials("udock", "udock-secret"), WithBuckets("fixtures"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Remove())
+	}()
+
+	require.NoError(t, m.CreateBucket("uploads"))
+	require.NoError(t, m.CreateBucket("uploads"))
+
+	for _, bucket := range []string{"fixtures", "uploads"} {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, m.URL()+"/"+bucket, nil)
+		require.NoError(t, err)
+		sign(req, m.AccessKey(), m.SecretKey(), time.Now())
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, bucket)
+	}
+}
@@ -0,0 +1,251 @@
+package udock
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// MountOption is an option for bind and volume mounts.
+type MountOption func(*mountOptions)
+
+// mountOptions are the settings shared by bind and volume mounts.
+type mountOptions struct {
+	readOnly bool
+	// selinuxLabel is the relabeling flag, "z" or "Z", if any.
+	selinuxLabel string
+}
+
+// MountReadOnly makes the mount read-only inside the container.
+func MountReadOnly() MountOption {
+	return func(o *mountOptions) {
+		o.readOnly = true
+	}
+}
+
+// MountSELinuxShared relabels the content of a bind mount so that it can be
+// shared by multiple containers on hosts with SELinux enforcing.  This is the
+// ":z" flag of docker run -v.
+func MountSELinuxShared() MountOption {
+	return func(o *mountOptions) {
+		o.selinuxLabel = "z"
+	}
+}
+
+// MountSELinuxPrivate relabels the content of a bind mount so that only this
+// container can use it on hosts with SELinux enforcing.  This is the ":Z"
+// flag of docker run -v.
+func MountSELinuxPrivate() MountOption {
+	return func(o *mountOptions) {
+		o.selinuxLabel = "Z"
+	}
+}
+
+// newMountOptions applies opts to a zero mountOptions.
+func newMountOptions(opts ...MountOption) mountOptions {
+	var o mountOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// bindMount is a host directory or file mounted into a container.
+type bindMount struct {
+	hostPath      string
+	containerPath string
+	mountOptions
+}
+
+// bind returns the mount in the form used in HostConfig.Binds.
+func (b bindMount) bind() string {
+	var flags []string
+	if b.readOnly {
+		flags = append(flags, "ro")
+	}
+	if b.selinuxLabel != "" {
+		flags = append(flags, b.selinuxLabel)
+	}
+
+	bind := b.hostPath + ":" + b.containerPath
+	if len(flags) > 0 {
+		bind += ":" + strings.Join(flags, ",")
+	}
+	return bind
+}
+
+// WithBindMount mounts the host directory or file hostPath at containerPath
+// in the container.  Relative host paths are relative to the current
+// directory.  If readOnly is set the container cannot modify the mount, which
+// is the same as passing MountReadOnly.
+func WithBindMount(hostPath string, containerPath string, readOnly bool, opts ...MountOption) ContainerOption {
+	return func(spec *containerSpec) error {
+		hostPath, err := spec.expand(hostPath)
+		if err != nil {
+			return err
+		}
+		containerPath, err := spec.expand(containerPath)
+		if err != nil {
+			return err
+		}
+		absPath, err := filepath.Abs(hostPath)
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: invalid host path %q", ErrInvalidOption, hostPath), err)
+		}
+		if !path.IsAbs(containerPath) {
+			return fmt.Errorf("%w: container path %q must be absolute", ErrInvalidOption, containerPath)
+		}
+
+		b := bindMount{
+			hostPath:      absPath,
+			containerPath: containerPath,
+			mountOptions:  newMountOptions(opts...),
+		}
+		b.readOnly = b.readOnly || readOnly
+
+		spec.binds = append(spec.binds, b)
+		return nil
+	}
+}
+
+// WithTmpfs mounts a RAM-backed tmpfs at containerPath.  sizeOpts are the
+// tmpfs mount options, for instance "size=256m,mode=1777", and may be empty
+// to use the docker defaults.
+func WithTmpfs(containerPath string, sizeOpts string) ContainerOption {
+	return func(spec *containerSpec) error {
+		containerPath, err := spec.expand(containerPath)
+		if err != nil {
+			return err
+		}
+		if !path.IsAbs(containerPath) {
+			return fmt.Errorf("%w: container path %q must be absolute", ErrInvalidOption, containerPath)
+		}
+
+		if spec.hostConfig.Tmpfs == nil {
+			spec.hostConfig.Tmpfs = map[string]string{}
+		}
+		spec.hostConfig.Tmpfs[containerPath] = sizeOpts
+		return nil
+	}
+}
+
+// WithVolumeMount mounts the named volume at containerPath.  The volume can
+// be one created with CreateVolume or any existing volume; if no volume by
+// that name exists docker creates it.  Volumes do not need SELinux
+// relabeling, so MountSELinuxShared and MountSELinuxPrivate are rejected.
+func WithVolumeMount(volumeName string, containerPath string, opts ...MountOption) ContainerOption {
+	return func(spec *containerSpec) error {
+		volumeName, err := spec.expand(volumeName)
+		if err != nil {
+			return err
+		}
+		containerPath, err := spec.expand(containerPath)
+		if err != nil {
+			return err
+		}
+		if volumeName == "" {
+			return fmt.Errorf("%w: empty volume name", ErrInvalidOption)
+		}
+		if !path.IsAbs(containerPath) {
+			return fmt.Errorf("%w: container path %q must be absolute", ErrInvalidOption, containerPath)
+		}
+
+		mountOpts := newMountOptions(opts...)
+		if mountOpts.selinuxLabel != "" {
+			return fmt.Errorf("%w: SELinux relabeling is only supported for bind mounts", ErrInvalidOption)
+		}
+
+		spec.hostConfig.Mounts = append(spec.hostConfig.Mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   volumeName,
+			Target:   containerPath,
+			ReadOnly: mountOpts.readOnly,
+		})
+		return nil
+	}
+}
+
+// containerFile is a file that is copied into a container when it is
+// created.
+type containerFile struct {
+	path     string
+	contents []byte
+	mode     fs.FileMode
+	uid      int
+	gid      int
+
+	// ownedByUser makes the file owned by the user the container runs as
+	// rather than root.
+	ownedByUser bool
+}
+
+// WithFile puts a file with the given contents and mode at containerPath in
+// the container when it is created, which is handy for config files,
+// certificates and init scripts.  Missing parent directories are created.
+// Files cannot be added to containers with WithReadOnlyRootFS; use
+// WithBindMount for those.
+func WithFile(containerPath string, contents []byte, mode fs.FileMode) ContainerOption {
+	return func(spec *containerSpec) error {
+		if !path.IsAbs(containerPath) || strings.HasSuffix(containerPath, "/") {
+			return fmt.Errorf("%w: container path %q must be an absolute file path", ErrInvalidOption, containerPath)
+		}
+
+		spec.files = append(spec.files, containerFile{
+			path:     path.Clean(containerPath),
+			contents: contents,
+			mode:     mode,
+		})
+		return nil
+	}
+}
+
+// copyFiles copies files into the root of the container.
+func (s *Session) copyFiles(containerID string, files []containerFile) error {
+	archive, err := filesTar(files)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrCopyingFiles, containerID), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCopyTimeout)
+	defer cancel()
+
+	err = s.client.CopyToContainer(ctx, containerID, "/", archive, container.CopyToContainerOptions{})
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrCopyingFiles, containerID), err)
+	}
+	return nil
+}
+
+// filesTar returns a tar archive of files, relative to the root.
+func filesTar(files []containerFile) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(f.path, "/"),
+			Mode:     int64(f.mode.Perm()),
+			Size:     int64(len(f.contents)),
+			Uid:      f.uid,
+			Gid:      f.gid,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tw.Write(f.contents)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &buf, tw.Close()
+}
@@ -0,0 +1,117 @@
+package udock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindMount(t *testing.T) {
+	dir := t.TempDir()
+
+	spec, err := newContainerSpec("postgres:16", nil,
+		WithBindMount(dir, "/fixtures", true),
+		WithBindMount(filepath.Join(dir, "init.sql"), "/docker-entrypoint-initdb.d/init.sql", false),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		dir + ":/fixtures:ro",
+		filepath.Join(dir, "init.sql") + ":/docker-entrypoint-initdb.d/init.sql",
+	}, spec.hostConfig.Binds)
+
+	// relative paths are relative to the current directory
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	spec, err = newContainerSpec("postgres:16", nil, WithBindMount("testdata", "/testdata", false))
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(cwd, "testdata") + ":/testdata"}, spec.hostConfig.Binds)
+
+	_, err = newContainerSpec("postgres:16", nil, WithBindMount(dir, "relative", false))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestTmpfs(t *testing.T) {
+	spec, err := newContainerSpec("postgres:16", nil,
+		WithTmpfs("/var/lib/postgresql/data", "size=256m"),
+		WithTmpfs("/tmp", ""),
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"/var/lib/postgresql/data": "size=256m",
+		"/tmp":                     "",
+	}, spec.hostConfig.Tmpfs)
+
+	_, err = newContainerSpec("postgres:16", nil, WithTmpfs("tmp", ""))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestVolumeMount(t *testing.T) {
+	spec, err := newContainerSpec("postgres:16", nil, WithVolumeMount("pgdata", "/var/lib/postgresql/data"))
+	require.NoError(t, err)
+	require.Equal(t, []mount.Mount{{
+		Type:   mount.TypeVolume,
+		Source: "pgdata",
+		Target: "/var/lib/postgresql/data",
+	}}, spec.hostConfig.Mounts)
+
+	_, err = newContainerSpec("postgres:16", nil, WithVolumeMount("", "/data"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("postgres:16", nil, WithVolumeMount("pgdata", "data"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestReadOnlyMount(t *testing.T) {
+	dir := t.TempDir()
+
+	spec, err := newContainerSpec("nginx:latest", nil,
+		WithBindMount(dir, "/etc/nginx/conf.d", false, MountReadOnly()),
+		WithVolumeMount("html", "/usr/share/nginx/html", MountReadOnly()),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{dir + ":/etc/nginx/conf.d:ro"}, spec.hostConfig.Binds)
+	require.Len(t, spec.hostConfig.Mounts, 1)
+	require.True(t, spec.hostConfig.Mounts[0].ReadOnly)
+}
+
+func TestSELinuxMount(t *testing.T) {
+	dir := t.TempDir()
+
+	spec, err := newContainerSpec("nginx:latest", nil,
+		WithBindMount(dir, "/shared", false, MountSELinuxShared()),
+		WithBindMount(dir, "/private", true, MountSELinuxPrivate()),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		dir + ":/shared:z",
+		dir + ":/private:ro,Z",
+	}, spec.hostConfig.Binds)
+
+	_, err = newContainerSpec("nginx:latest", nil, WithVolumeMount("html", "/html", MountSELinuxShared()))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestWithFile(t *testing.T) {
+	spec, err := newContainerSpec("nginx:latest", nil,
+		WithFile("/etc/nginx/conf.d/default.conf", []byte("server {}"), 0o644),
+		WithFile("/docker-entrypoint.d/init.sh", []byte("#!/bin/sh\n"), 0o755),
+	)
+	require.NoError(t, err)
+	require.Len(t, spec.files, 2)
+
+	archive, err := filesTar(spec.files)
+	require.NoError(t, err)
+	require.Equal(t, []string{"etc/nginx/conf.d/default.conf", "docker-entrypoint.d/init.sh"}, tarEntryNames(t, archive))
+
+	_, err = newContainerSpec("nginx:latest", nil, WithFile("default.conf", nil, 0o644))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("nginx:latest", nil, WithFile("/etc/nginx/conf.d/default.conf", nil, 0o644), WithReadOnlyRootFS("/tmp"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("nginx:latest", nil, WithFile("/etc/nginx/", nil, 0o644))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
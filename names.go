@@ -0,0 +1,157 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// LabelManaged is set to "true" on everything udock creates.
+	LabelManaged = "udock.managed"
+
+	// LabelSession is set to the ID of the session that created the
+	// container.
+	LabelSession = "udock.session"
+
+	// LabelProcess is set to the hostname and process ID, e.g.
+	// "ci-runner-7:4711", of the test process the session that created the
+	// container lives in.  It tells whether the session is still alive.
+	LabelProcess = "udock.process"
+
+	// LabelReaper is set on the reaper to the ID of the session it looks
+	// after.  The reaper cannot carry LabelSession or it would reap itself.
+	LabelReaper = "udock.reaper"
+
+	// maxNameLength is how long we let generated names get.  Docker has no
+	// hard limit, but hostnames derived from names must fit in 63
+	// characters.
+	maxNameLength = 63
+)
+
+// labels returns the labels that identify what the session creates.
+func (s *Session) labels() map[string]string {
+	return map[string]string{
+		LabelManaged: "true",
+		LabelSession: s.id,
+		LabelProcess: currentProcess(),
+	}
+}
+
+// currentProcess returns the value of LabelProcess for this process.
+var currentProcess = sync.OnceValue(func() string {
+	hostname, _ := os.Hostname()
+	return hostname + ":" + strconv.Itoa(os.Getpid())
+})
+
+// UniqueName returns a container name made from prefix, the name of the test
+// and a random suffix, e.g. UniqueName("pg", t.Name()) might return
+// "pg-TestFoo-subtest-3f2a9c81d0e4".  Characters docker does not allow in
+// names are replaced by dashes.  Either of prefix and testName may be empty.
+func UniqueName(prefix string, testName string) string {
+	var parts []string
+	for _, p := range []string{prefix, testName} {
+		p = sanitizeName(p)
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	suffix := randomID()
+	name := strings.Join(parts, "-")
+	if len(name) > maxNameLength-len(suffix)-1 {
+		name = strings.TrimRight(name[:maxNameLength-len(suffix)-1], "-_.")
+	}
+	if name == "" {
+		return "udock-" + suffix
+	}
+	return name + "-" + suffix
+}
+
+// sanitizeName replaces the characters that are not allowed in container
+// names with dashes and trims separators from the start of the name, since
+// names have to start with a letter or digit.
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '-'
+	}, name)
+	return strings.Trim(name, "-_.")
+}
+
+// removeStaleContainer removes the container called name if it was created
+// by udock in another session that is no longer alive, which happens when a
+// test run crashes before it gets to clean up.  The container may well still
+// be running then.  Containers of sessions that are alive, e.g. in a test
+// binary running in parallel, are left alone, and so are containers that
+// were not created by udock.  Reusable containers belong to no session, see
+// ContainerSpec.Reuse, and are removed unless they are running.  We report
+// whether the container was removed.
+func (s *Session) removeStaleContainer(name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer cancel()
+
+	info, err := s.client.ContainerInspect(ctx, name)
+	if err != nil {
+		return false, errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, name), err)
+	}
+
+	if info.Config == nil || info.Config.Labels[LabelManaged] != "true" || info.Config.Labels[LabelSession] == s.id {
+		return false, nil
+	}
+	sessionID := info.Config.Labels[LabelSession]
+	if sessionID == "" {
+		if info.State != nil && (info.State.Running || info.State.Paused || info.State.Restarting) {
+			return false, nil
+		}
+	} else {
+		// Without a process we can check, e.g. for a session on another
+		// host sharing the docker daemon, we have to assume it is alive.
+		alive, known := processAlive(info.Config.Labels[LabelProcess])
+		if alive || !known {
+			slog.Warn("container name is taken by a container of a live session", "name", name, "id", info.ID, "session", sessionID)
+			return false, nil
+		}
+	}
+
+	slog.Warn("removing stale container from earlier session", "name", name, "id", info.ID, "session", sessionID)
+	err = s.RemoveContainer(info.ID)
+	if err != nil {
+		return false, errors.Join(fmt.Errorf("%w: %s", ErrRemovingContainer, name), err)
+	}
+	return true, nil
+}
+
+// processAlive reports whether the process identified by process, a
+// LabelProcess value, is running, and whether we can know, which we can
+// only for processes on this host.
+func processAlive(process string) (alive bool, known bool) {
+	hostname, pidString, ok := strings.Cut(process, ":")
+	if !ok {
+		return false, false
+	}
+	ourHostname, err := os.Hostname()
+	if err != nil || hostname != ourHostname {
+		return false, false
+	}
+	pid, err := strconv.Atoi(pidString)
+	if err != nil || pid <= 0 {
+		return false, false
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false, true
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM), true
+}
@@ -0,0 +1,103 @@
+package udock
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniqueName(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+	name := UniqueName("pg", "TestFoo/with spaces")
+	require.Regexp(t, `^pg-TestFoo-with-spaces-[0-9a-f]{12}$`, name)
+	require.NotEqual(t, name, UniqueName("pg", "TestFoo/with spaces"))
+
+	require.Regexp(t, `^udock-[0-9a-f]{12}$`, UniqueName("", "/"))
+	require.Regexp(t, `^TestBar-[0-9a-f]{12}$`, UniqueName("", "TestBar"))
+
+	long := UniqueName("prefix", strings.Repeat("TestVeryLong/", 20))
+	require.LessOrEqual(t, len(long), maxNameLength)
+	require.Regexp(t, valid, long)
+}
+
+func TestSessionLabels(t *testing.T) {
+	s := &Session{id: "abc123"}
+
+	spec, err := newContainerSpec("alpine", nil, s.defaultContainerOptions()...)
+	require.NoError(t, err)
+	require.Equal(t, "true", spec.config.Labels[LabelManaged])
+	require.Equal(t, "abc123", spec.config.Labels[LabelSession])
+	require.Equal(t, currentProcess(), spec.config.Labels[LabelProcess])
+}
+
+// deadProcess returns the LabelProcess value of a process on this host that
+// has exited.
+func deadProcess(t *testing.T) string {
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	return hostname + ":" + strconv.Itoa(cmd.Process.Pid)
+}
+
+func TestProcessAlive(t *testing.T) {
+	alive, known := processAlive(currentProcess())
+	require.True(t, known)
+	require.True(t, alive)
+
+	alive, known = processAlive(deadProcess(t))
+	require.True(t, known)
+	require.False(t, alive)
+
+	// processes on other hosts, and containers from before we labeled them
+	// with the process, cannot be checked
+	for _, process := range []string{"", "some-other-host:1", "garbage"} {
+		_, known = processAlive(process)
+		require.False(t, known, process)
+	}
+}
+
+func TestReplaceContainerOfDeadSession(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, session.Close())
+	}()
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	// a container left running by a test process that was killed
+	name := UniqueName("stale", t.Name())
+	staleID, err := session.CreateContainer(httpEchoImage, name, nil, WithLabels(map[string]string{
+		LabelSession: "0123456789ab",
+		LabelProcess: deadProcess(t),
+	}))
+	require.NoError(t, err)
+	defer func() {
+		_ = session.RemoveContainer(staleID)
+	}()
+	require.NoError(t, session.StartContainer(staleID))
+
+	containerID, err := session.CreateContainer(httpEchoImage, name, nil)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, session.RemoveContainer(containerID))
+	}()
+	require.NotEqual(t, staleID, containerID)
+
+	_, err = session.client.ContainerInspect(context.Background(), staleID)
+	require.True(t, errdefs.IsNotFound(err), err)
+
+	// the name is now held by a container of a live session, ours
+	_, err = session.CreateContainer(httpEchoImage, name, nil)
+	require.ErrorIs(t, err, ErrCreatingContainer)
+}
@@ -0,0 +1,162 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+// NetworkOption is an option for CreateNetwork.
+type NetworkOption func(*network.CreateOptions)
+
+// WithNetworkDriver sets the network driver.  The default is "bridge".
+func WithNetworkDriver(driver string) NetworkOption {
+	return func(o *network.CreateOptions) {
+		o.Driver = driver
+	}
+}
+
+// WithNetworkLabels adds labels to the network.
+func WithNetworkLabels(labels map[string]string) NetworkOption {
+	return func(o *network.CreateOptions) {
+		if o.Labels == nil {
+			o.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			o.Labels[k] = v
+		}
+	}
+}
+
+// WithSubnet adds a subnet in CIDR notation, e.g. "172.28.0.0/16", to the
+// network.  gateway may be empty, in which case docker picks one.
+func WithSubnet(subnet string, gateway string) NetworkOption {
+	return func(o *network.CreateOptions) {
+		if o.IPAM == nil {
+			o.IPAM = &network.IPAM{}
+		}
+		o.IPAM.Config = append(o.IPAM.Config, network.IPAMConfig{
+			Subnet:  subnet,
+			Gateway: gateway,
+		})
+	}
+}
+
+// WithIPv6 enables IPv6 on the network.  subnet is an IPv6 subnet in CIDR
+// notation, e.g. "fd00:dead:beef::/48".  If subnet is empty docker allocates
+// one from its default pools, which requires IPv6 pools to be configured for
+// the daemon.
+func WithIPv6(subnet string) NetworkOption {
+	return func(o *network.CreateOptions) {
+		enable := true
+		o.EnableIPv6 = &enable
+		if subnet != "" {
+			WithSubnet(subnet, "")(o)
+		}
+	}
+}
+
+// WithInternal makes the network internal.  Containers on an internal
+// network can talk to each other but have no route to the outside world,
+// and ports they publish are not reachable from the host.  Use this to
+// verify that a service is genuinely unreachable from outside its network
+// segment.
+func WithInternal() NetworkOption {
+	return func(o *network.CreateOptions) {
+		o.Internal = true
+	}
+}
+
+// CreateNetwork creates a network that containers can be attached to so they
+// can talk to each other directly.  Returns the ID of the network.
+func (s *Session) CreateNetwork(name string, opts ...NetworkOption) (string, error) {
+	createOptions := network.CreateOptions{
+		Driver: "bridge",
+		Labels: s.labels(),
+	}
+	for _, opt := range opts {
+		opt(&createOptions)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerNetworkTimeout)
+	defer cancel()
+
+	resp, err := s.client.NetworkCreate(ctx, name, createOptions)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrCreatingNetwork, name), err)
+	}
+	if resp.Warning != "" {
+		slog.Warn("network created with warning", "name", name, "warning", resp.Warning)
+	}
+	return resp.ID, nil
+}
+
+// RemoveNetwork removes a network.
+func (s *Session) RemoveNetwork(networkID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerNetworkTimeout)
+	defer cancel()
+
+	err := s.client.NetworkRemove(ctx, networkID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrRemovingNetwork, networkID), err)
+	}
+	return nil
+}
+
+// ConnectNetwork connects a container to a network.  Other containers on the
+// network can reach the container by its name and by any of the aliases.
+func (s *Session) ConnectNetwork(containerID string, networkID string, aliases ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerNetworkTimeout)
+	defer cancel()
+
+	err := s.client.NetworkConnect(ctx, networkID, containerID, &network.EndpointSettings{
+		Aliases: aliases,
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s to %s", ErrConnectingNetwork, containerID, networkID), err)
+	}
+	return nil
+}
+
+// DisconnectNetwork disconnects a container from a network.
+func (s *Session) DisconnectNetwork(containerID string, networkID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerNetworkTimeout)
+	defer cancel()
+
+	err := s.client.NetworkDisconnect(ctx, networkID, containerID, false)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s from %s", ErrDisconnectingNetwork, containerID, networkID), err)
+	}
+	return nil
+}
+
+// ContainerIP returns the IP address of the container on the network, which
+// may be given by name or ID.  If the container only has an IPv6 address on
+// the network that is returned.
+func (s *Session) ContainerIP(containerID string, networkName string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer cancel()
+
+	inspect, err := s.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+	}
+
+	if inspect.NetworkSettings != nil {
+		for name, endpoint := range inspect.NetworkSettings.Networks {
+			if endpoint == nil || (name != networkName && endpoint.NetworkID != networkName) {
+				continue
+			}
+			if endpoint.IPAddress != "" {
+				return endpoint.IPAddress, nil
+			}
+			if endpoint.GlobalIPv6Address != "" {
+				return endpoint.GlobalIPv6Address, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s is not on %s", ErrNotOnNetwork, containerID, networkName)
+}
@@ -0,0 +1,38 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkOptions(t *testing.T) {
+	opts := network.CreateOptions{}
+	for _, opt := range []NetworkOption{
+		WithNetworkDriver("macvlan"),
+		WithNetworkLabels(map[string]string{"suite": "udock"}),
+		WithSubnet("172.28.0.0/16", "172.28.0.1"),
+		WithInternal(),
+	} {
+		opt(&opts)
+	}
+
+	require.Equal(t, "macvlan", opts.Driver)
+	require.Equal(t, map[string]string{"suite": "udock"}, opts.Labels)
+	require.Equal(t, []network.IPAMConfig{{Subnet: "172.28.0.0/16", Gateway: "172.28.0.1"}}, opts.IPAM.Config)
+	require.True(t, opts.Internal)
+}
+
+func TestNetworkIPv6(t *testing.T) {
+	opts := network.CreateOptions{}
+	WithSubnet("172.28.0.0/16", "")(&opts)
+	WithIPv6("fd00:dead:beef::/48")(&opts)
+
+	require.NotNil(t, opts.EnableIPv6)
+	require.True(t, *opts.EnableIPv6)
+	require.Equal(t, []network.IPAMConfig{
+		{Subnet: "172.28.0.0/16"},
+		{Subnet: "fd00:dead:beef::/48"},
+	}, opts.IPAM.Config)
+}
@@ -0,0 +1,202 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// CleanupReport lists what CleanupOrphans removed.
+type CleanupReport struct {
+	Containers []string
+	Networks   []string
+	Volumes    []string
+	Images     []string
+}
+
+// CleanupOption is an option for CleanupOrphans.
+type CleanupOption func(*cleanupOptions)
+
+// cleanupOptions are the settings of CleanupOrphans.
+type cleanupOptions struct {
+	reusable bool
+}
+
+// CleanupReusable makes CleanupOrphans remove reusable containers too, see
+// ContainerSpec.Reuse.  They are left alone by default since they are meant
+// to outlive the sessions that created them.
+func CleanupReusable() CleanupOption {
+	return func(o *cleanupOptions) {
+		o.reusable = true
+	}
+}
+
+// CleanupOrphans removes the containers, networks, volumes and snapshot
+// images created by udock more than olderThan ago that are still around,
+// typically because the test process that created them was killed.  This is
+// meant for janitor jobs on CI hosts.  If labelSelector is not empty, e.g.
+// "udock.session=3f2a9c81d0e4" or "suite", only resources that also match it
+// are removed.  Reusable containers are skipped unless CleanupReusable is
+// given, and so are reapers, see WithReaper, while their session still has
+// containers running.  Resources that cannot be removed, e.g. networks that
+// still have containers attached that were not created by udock, are
+// reported in the error and skipped, and so are volumes whose creation time
+// docker does not report.
+func CleanupOrphans(olderThan time.Duration, labelSelector string, opts ...CleanupOption) (CleanupReport, error) {
+	var o cleanupOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	client, err := connect()
+	if err != nil {
+		return CleanupReport{}, err
+	}
+	s := newSession(client)
+	defer client.Close()
+
+	args := filters.NewArgs(filters.Arg("label", LabelManaged+"=true"))
+	if labelSelector != "" {
+		args.Add("label", labelSelector)
+	}
+	cutoff := time.Now().Add(-olderThan)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPruneTimeout)
+	defer cancel()
+
+	var errs []error
+	report := CleanupReport{}
+
+	// Containers go first since they keep networks and volumes in use.
+	containers, err := client.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return report, errors.Join(ErrCleaningUpOrphans, err)
+	}
+	running, err := client.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true"), filters.Arg("label", LabelSession)),
+	})
+	if err != nil {
+		return report, errors.Join(ErrCleaningUpOrphans, err)
+	}
+	for _, c := range orphanContainers(containers, liveSessions(running), cutoff, o) {
+		err := s.RemoveContainer(c.ID)
+		if err != nil {
+			errs = append(errs, errors.Join(fmt.Errorf("%w: %s", ErrRemovingContainer, c.ID), err))
+			continue
+		}
+		report.Containers = append(report.Containers, c.ID)
+	}
+
+	networks, err := client.NetworkList(ctx, network.ListOptions{Filters: args})
+	if err != nil {
+		return report, errors.Join(ErrCleaningUpOrphans, err)
+	}
+	for _, n := range networks {
+		if n.Created.After(cutoff) {
+			continue
+		}
+		err := s.RemoveNetwork(n.ID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Networks = append(report.Networks, n.Name)
+	}
+
+	volumes, err := client.VolumeList(ctx, volume.ListOptions{Filters: args})
+	if err != nil {
+		return report, errors.Join(ErrCleaningUpOrphans, err)
+	}
+	for _, v := range volumes.Volumes {
+		created, err := time.Parse(time.RFC3339, v.CreatedAt)
+		if err != nil {
+			slog.Warn("skipping volume without a creation time", "volume", v.Name, "createdAt", v.CreatedAt)
+			continue
+		}
+		if created.After(cutoff) {
+			continue
+		}
+		err = s.RemoveVolume(v.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Volumes = append(report.Volumes, v.Name)
+	}
+
+	// Of the images only snapshots are removed, golden images and images
+	// built by udock are meant to outlive the sessions that made them.
+	imageArgs := args.Clone()
+	imageArgs.Add("label", LabelSnapshot+"=true")
+	images, err := client.ImageList(ctx, image.ListOptions{Filters: imageArgs})
+	if err != nil {
+		return report, errors.Join(ErrCleaningUpOrphans, err)
+	}
+	for _, img := range images {
+		if time.Unix(img.Created, 0).After(cutoff) {
+			continue
+		}
+		err := s.RemoveImage(img.ID)
+		if err != nil {
+			errs = append(errs, errors.Join(fmt.Errorf("%w: %s", ErrRemovingImage, img.ID), err))
+			continue
+		}
+		report.Images = append(report.Images, img.ID)
+	}
+
+	slog.Info("cleaned up orphans",
+		"containers", len(report.Containers),
+		"networks", len(report.Networks),
+		"volumes", len(report.Volumes),
+		"images", len(report.Images))
+
+	if len(errs) > 0 {
+		return report, errors.Join(ErrCleaningUpOrphans, errors.Join(errs...))
+	}
+	return report, nil
+}
+
+// orphanContainers returns the containers CleanupOrphans removes: those
+// created before cutoff, except reusable containers unless o says so, and
+// reapers of the live sessions.
+func orphanContainers(containers []types.Container, live map[string]bool, cutoff time.Time, o cleanupOptions) []types.Container {
+	var orphans []types.Container
+	for _, c := range containers {
+		if time.Unix(c.Created, 0).After(cutoff) {
+			continue
+		}
+		if _, reusable := c.Labels[LabelSpecHash]; reusable && !o.reusable {
+			continue
+		}
+		if sessionID, reaper := c.Labels[LabelReaper]; reaper && live[sessionID] {
+			continue
+		}
+		orphans = append(orphans, c)
+	}
+	return orphans
+}
+
+// liveSessions returns the IDs of the sessions the running containers
+// belong to.
+func liveSessions(running []types.Container) map[string]bool {
+	live := map[string]bool{}
+	for _, c := range running {
+		if c.State != "running" {
+			continue
+		}
+		sessionID := c.Labels[LabelSession]
+		if sessionID != "" {
+			live[sessionID] = true
+		}
+	}
+	return live
+}
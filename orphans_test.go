@@ -0,0 +1,46 @@
+package udock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrphanContainers(t *testing.T) {
+	cutoff := time.Now().Add(-time.Hour)
+	old := cutoff.Add(-time.Minute).Unix()
+
+	containers := []types.Container{
+		{ID: "old", Created: old, Labels: map[string]string{LabelSession: "a"}},
+		{ID: "new", Created: time.Now().Unix(), Labels: map[string]string{LabelSession: "a"}},
+		{ID: "reusable", Created: old, Labels: map[string]string{LabelSpecHash: "0123abcd"}},
+		{ID: "live-reaper", Created: old, Labels: map[string]string{LabelReaper: "b"}},
+		{ID: "dead-reaper", Created: old, Labels: map[string]string{LabelReaper: "c"}},
+	}
+	live := map[string]bool{"b": true}
+
+	ids := func(containers []types.Container) []string {
+		var ids []string
+		for _, c := range containers {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	require.Equal(t, []string{"old", "dead-reaper"}, ids(orphanContainers(containers, live, cutoff, cleanupOptions{})))
+
+	var o cleanupOptions
+	CleanupReusable()(&o)
+	require.Equal(t, []string{"old", "reusable", "dead-reaper"}, ids(orphanContainers(containers, live, cutoff, o)))
+}
+
+func TestLiveSessions(t *testing.T) {
+	live := liveSessions([]types.Container{
+		{State: "running", Labels: map[string]string{LabelSession: "a"}},
+		{State: "exited", Labels: map[string]string{LabelSession: "b"}},
+		{State: "running", Labels: map[string]string{LabelReaper: "c"}},
+	})
+	require.Equal(t, map[string]bool{"a": true}, live)
+}
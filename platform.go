@@ -0,0 +1,56 @@
+package udock
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// parsePlatform parses a platform of the form os/arch[/variant], e.g.
+// "linux/amd64" or "linux/arm64/v8".
+func parsePlatform(platform string) (*ocispec.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("%w: %q, expected os/arch[/variant]", ErrInvalidPlatform, platform)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("%w: %q, expected os/arch[/variant]", ErrInvalidPlatform, platform)
+		}
+	}
+
+	p := &ocispec.Platform{
+		OS:           strings.ToLower(parts[0]),
+		Architecture: strings.ToLower(parts[1]),
+	}
+	if len(parts) == 3 {
+		p.Variant = strings.ToLower(parts[2])
+	}
+	return p, nil
+}
+
+// formatPlatform formats a platform the way the docker API expects it in
+// query parameters.
+func formatPlatform(p *ocispec.Platform) string {
+	if p == nil {
+		return ""
+	}
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// platformMatches returns true if the image is built for platform p.  The
+// variant is only compared if p specifies one.
+func platformMatches(p *ocispec.Platform, inspect types.ImageInspect) bool {
+	if p == nil {
+		return true
+	}
+	if !strings.EqualFold(p.OS, inspect.Os) || !strings.EqualFold(p.Architecture, inspect.Architecture) {
+		return false
+	}
+	return p.Variant == "" || strings.EqualFold(p.Variant, inspect.Variant)
+}
@@ -0,0 +1,38 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := parsePlatform("linux/amd64")
+	require.NoError(t, err)
+	require.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "amd64"}, p)
+	require.Equal(t, "linux/amd64", formatPlatform(p))
+
+	p, err = parsePlatform("linux/arm64/v8")
+	require.NoError(t, err)
+	require.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, p)
+	require.Equal(t, "linux/arm64/v8", formatPlatform(p))
+
+	for _, invalid := range []string{"", "linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		_, err = parsePlatform(invalid)
+		require.ErrorIs(t, err, ErrInvalidPlatform, invalid)
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	amd64 := types.ImageInspect{Os: "linux", Architecture: "amd64"}
+	arm64 := types.ImageInspect{Os: "linux", Architecture: "arm64", Variant: "v8"}
+
+	require.True(t, platformMatches(nil, amd64))
+	require.True(t, platformMatches(&ocispec.Platform{OS: "linux", Architecture: "amd64"}, amd64))
+	require.False(t, platformMatches(&ocispec.Platform{OS: "linux", Architecture: "amd64"}, arm64))
+	require.True(t, platformMatches(&ocispec.Platform{OS: "linux", Architecture: "arm64"}, arm64))
+	require.True(t, platformMatches(&ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, arm64))
+	require.False(t, platformMatches(&ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v7"}, arm64))
+}
@@ -0,0 +1,231 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Pool keeps a number of running containers made from the same spec and
+// leases them out to tests, so that tests running in parallel do not each
+// have to wait for a container to start.  Create pools with NewPool.
+type Pool struct {
+	session *Session
+	spec    ContainerSpec
+	reset   func(containerID string) error
+
+	idle   chan string
+	closed chan struct{}
+
+	mu         sync.Mutex
+	containers map[string]bool
+	// leased holds the IDs of the containers that are leased out.
+	leased map[string]bool
+	// missing is how many containers the pool is short of because
+	// replacing them failed.  They are started again when needed.
+	missing   int
+	closeOnce sync.Once
+}
+
+// NewPool starts size containers as described by spec and returns a pool
+// that leases them out.  If reset is not nil it is called with the ID of a
+// container when it is released, to get it back to a clean state, e.g. by
+// truncating the tables of a database.  If reset fails the container is
+// replaced with a new one.  Pooled containers cannot have a name or be
+// reused, since the pool needs size distinct containers of its own.
+func (s *Session) NewPool(spec ContainerSpec, size int, reset func(containerID string) error) (*Pool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("%w: pool size must be at least 1, got %d", ErrInvalidPool, size)
+	}
+	if spec.Name != "" {
+		return nil, fmt.Errorf("%w: pooled containers cannot have a fixed name", ErrInvalidPool)
+	}
+	// A reused container would be put in the pool once for every
+	// container, and removed when the pool is closed.
+	if spec.Reuse {
+		return nil, fmt.Errorf("%w: pooled containers cannot be reused", ErrInvalidPool)
+	}
+
+	p := &Pool{
+		session:    s,
+		spec:       spec,
+		reset:      reset,
+		idle:       make(chan string, size),
+		closed:     make(chan struct{}),
+		containers: map[string]bool{},
+		leased:     map[string]bool{},
+	}
+
+	for i := 0; i < size; i++ {
+		containerID, err := p.start()
+		if err != nil {
+			return nil, errors.Join(err, p.Close())
+		}
+		p.idle <- containerID
+	}
+	return p, nil
+}
+
+// Lease returns the ID of an idle container, waiting for one to be released
+// if they are all in use.  If none is released before ctx is done, Lease
+// fails with ErrPoolExhausted.  Give the container back with Release when
+// done.
+func (p *Pool) Lease(ctx context.Context) (string, error) {
+	select {
+	case <-p.closed:
+		return "", ErrPoolClosed
+	default:
+	}
+
+	select {
+	case containerID := <-p.idle:
+		return p.lease(containerID), nil
+	default:
+	}
+
+	// make up for containers that could not be replaced before we wait
+	// for one to be released
+	containerID, err := p.refill()
+	if err != nil {
+		return "", err
+	}
+	if containerID != "" {
+		return p.lease(containerID), nil
+	}
+
+	select {
+	case containerID := <-p.idle:
+		return p.lease(containerID), nil
+	case <-p.closed:
+		return "", ErrPoolClosed
+	case <-ctx.Done():
+		return "", errors.Join(fmt.Errorf("%w: all %d containers are leased", ErrPoolExhausted, cap(p.idle)), ctx.Err())
+	}
+}
+
+// lease records that the container is leased out and returns its ID.
+func (p *Pool) lease(containerID string) string {
+	p.mu.Lock()
+	p.leased[containerID] = true
+	p.mu.Unlock()
+	return containerID
+}
+
+// Release gives a leased container back to the pool, resetting it first.
+// Releasing a container that is not leased from the pool, e.g. releasing
+// it twice, is an error.  Releasing containers after the pool has been
+// closed does nothing.
+func (p *Pool) Release(containerID string) error {
+	select {
+	case <-p.closed:
+		return nil
+	default:
+	}
+
+	p.mu.Lock()
+	leased := p.leased[containerID]
+	delete(p.leased, containerID)
+	p.mu.Unlock()
+	if !leased {
+		return fmt.Errorf("%w: %s is not leased from the pool", ErrInvalidOption, containerID)
+	}
+
+	if p.reset != nil {
+		err := p.reset(containerID)
+		if err != nil {
+			slog.Warn("resetting pooled container failed, replacing it", "id", containerID, "err", err)
+			containerID, err = p.replace(containerID)
+			if err != nil {
+				p.mu.Lock()
+				p.missing++
+				p.mu.Unlock()
+				return err
+			}
+		}
+	}
+
+	select {
+	case <-p.closed:
+		return p.remove(containerID)
+	default:
+		p.idle <- containerID
+		return nil
+	}
+}
+
+// Close removes all the containers of the pool, including the ones that
+// are leased out.
+func (p *Pool) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+
+	p.mu.Lock()
+	var ids []string
+	for containerID := range p.containers {
+		ids = append(ids, containerID)
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, containerID := range ids {
+		errs = append(errs, p.remove(containerID))
+	}
+	return errors.Join(errs...)
+}
+
+// start starts a new container for the pool.
+func (p *Pool) start() (string, error) {
+	containerID, err := p.session.Run(p.spec)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	p.containers[containerID] = true
+	p.mu.Unlock()
+	return containerID, nil
+}
+
+// refill starts a container in place of one that could not be replaced, and
+// returns its ID, or the empty string if the pool is not short of any.
+func (p *Pool) refill() (string, error) {
+	p.mu.Lock()
+	if p.missing == 0 {
+		p.mu.Unlock()
+		return "", nil
+	}
+	p.missing--
+	p.mu.Unlock()
+
+	containerID, err := p.start()
+	if err != nil {
+		p.mu.Lock()
+		p.missing++
+		p.mu.Unlock()
+		return "", err
+	}
+	return containerID, nil
+}
+
+// replace removes a container and starts a new one in its place.
+func (p *Pool) replace(containerID string) (string, error) {
+	err := p.remove(containerID)
+	if err != nil {
+		return "", err
+	}
+	return p.start()
+}
+
+// remove removes a container from the pool.
+func (p *Pool) remove(containerID string) error {
+	p.mu.Lock()
+	known := p.containers[containerID]
+	delete(p.containers, containerID)
+	p.mu.Unlock()
+
+	if !known {
+		return nil
+	}
+	return p.session.RemoveContainer(containerID)
+}
@@ -0,0 +1,65 @@
+package udock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolLease(t *testing.T) {
+	resets := 0
+	p := &Pool{
+		reset: func(containerID string) error {
+			resets++
+			return nil
+		},
+		idle:       make(chan string, 1),
+		closed:     make(chan struct{}),
+		containers: map[string]bool{"abc123": true},
+		leased:     map[string]bool{},
+	}
+	p.idle <- "abc123"
+
+	containerID, err := p.Lease(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "abc123", containerID)
+
+	// with every container leased out we give up when ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.Lease(ctx)
+	require.ErrorIs(t, err, ErrPoolExhausted)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.ErrorIs(t, p.Release("def456"), ErrInvalidOption)
+	require.NoError(t, p.Release(containerID))
+	require.Equal(t, 1, resets)
+
+	// releasing twice would overfill the pool
+	require.ErrorIs(t, p.Release(containerID), ErrInvalidOption)
+	require.Equal(t, 1, resets)
+
+	containerID, err = p.Lease(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "abc123", containerID)
+
+	close(p.closed)
+	_, err = p.Lease(context.Background())
+	require.ErrorIs(t, err, ErrPoolClosed)
+	require.NoError(t, p.Release(containerID))
+}
+
+func TestNewPoolValidation(t *testing.T) {
+	s := &Session{}
+
+	_, err := s.NewPool(ContainerSpec{Image: "postgres:16"}, 0, nil)
+	require.ErrorIs(t, err, ErrInvalidPool)
+
+	_, err = s.NewPool(ContainerSpec{Image: "postgres:16", Name: "pg"}, 2, nil)
+	require.ErrorIs(t, err, ErrInvalidPool)
+
+	_, err = s.NewPool(ContainerSpec{Image: "postgres:16", Reuse: true}, 2, nil)
+	require.ErrorIs(t, err, ErrInvalidPool)
+}
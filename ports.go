@@ -0,0 +1,318 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+// newPort parses a container port, which may have a protocol suffix, e.g.
+// "5678", "53/udp" or "9899/sctp".  Ports without a protocol are tcp.
+func newPort(port string) (nat.Port, error) {
+	proto, p := nat.SplitProtoPort(port)
+	proto = strings.ToLower(proto)
+	switch proto {
+	case "tcp", "udp", "sctp":
+	default:
+		return "", fmt.Errorf("%w: unsupported protocol %q in %q", ErrPortMap, proto, port)
+	}
+
+	natPort, err := nat.NewPort(proto, p)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %q", ErrPortMap, port), err)
+	}
+	return natPort, nil
+}
+
+// newPorts parses a container port or a range of container ports, e.g.
+// "30000-30100/udp", and returns the individual ports.
+func newPorts(ports string) ([]nat.Port, error) {
+	proto, p := nat.SplitProtoPort(ports)
+	start, end, err := nat.ParsePortRange(p)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %q", ErrPortMap, ports), err)
+	}
+
+	result := make([]nat.Port, 0, end-start+1)
+	for port := start; port <= end; port++ {
+		natPort, err := newPort(fmt.Sprintf("%d/%s", port, proto))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, natPort)
+	}
+	return result, nil
+}
+
+// freePort marks the host ports of the ports given to WithFreePorts until
+// resolveFreePorts replaces it with the free ports it picks.
+const freePort = "free"
+
+// freePortAttempts is how many ports we try before giving up on finding one
+// that is free on every host address.
+const freePortAttempts = 10
+
+// WithFreePorts publishes the container ports, e.g. WithFreePorts("5432/tcp",
+// "30000-30010/udp"), on free host ports that we pick when the container is
+// created, rather than leave it to docker when it is started.  Unlike the
+// ports docker picks, see WithPublishedPorts, they are known before the
+// container starts, e.g. for software that has to be told the address it is
+// reachable at, and they stay the same if the container is restarted.  Each
+// container port gets a port of its own that is free for its protocol on
+// each of the host addresses, see WithHostIP.  Another process may grab a
+// port between us picking it and the container starting, in which case Run
+// retries with new ports, see WithPortRetries.
+func WithFreePorts(containerPorts ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.free = append(spec.free, containerPorts...)
+		return nil
+	}
+}
+
+// hostPorts parses a host port or a range of host ports that is to be mapped
+// to n container ports.  An empty host port gives n empty host ports, which
+// makes docker pick free ports.
+func hostPorts(ports string, n int) ([]string, error) {
+	if ports == "" {
+		return make([]string, n), nil
+	}
+
+	start, end, err := nat.ParsePortRange(ports)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %q", ErrPortMap, ports), err)
+	}
+	if int(end-start+1) != n {
+		return nil, fmt.Errorf("%w: host ports %q do not match the number of container ports (%d)", ErrPortMap, ports, n)
+	}
+
+	result := make([]string, 0, n)
+	for port := start; port <= end; port++ {
+		result = append(result, fmt.Sprintf("%d", port))
+	}
+	return result, nil
+}
+
+// portBindings converts a map from host ports to container ports to the port
+// bindings and exposed ports for a container, binding to each of the host
+// addresses in hostIPs.  An empty host port makes docker pick a free one.
+// Both host and container ports may be ranges of the same size, e.g.
+// "30000-30100".
+func portBindings(ports map[string]string, hostIPs []string) (nat.PortMap, nat.PortSet, error) {
+	portmap := nat.PortMap{}
+	exposed := nat.PortSet{}
+	for hPort, cPort := range ports {
+		containerPorts, err := newPorts(cPort)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hPorts, err := hostPorts(hPort, len(containerPorts))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i, containerPort := range containerPorts {
+			for _, hostIP := range hostIPs {
+				portmap[containerPort] = append(portmap[containerPort], nat.PortBinding{
+					HostIP:   hostIP,
+					HostPort: hPorts[i],
+				})
+			}
+			exposed[containerPort] = struct{}{}
+		}
+	}
+	return portmap, exposed, nil
+}
+
+// GetMappedPort returns the host port the container port, e.g. "5432/tcp",
+// is published on.  Ports without a protocol are tcp.  The container must
+// have been started for docker to have assigned the host port.
+func (s *Session) GetMappedPort(containerID string, containerPort string) (string, error) {
+	binding, err := s.mappedBinding(containerID, containerPort)
+	if err != nil {
+		return "", err
+	}
+	return binding.HostPort, nil
+}
+
+// resolveFreePorts replaces the freePort markers in the port bindings of the
+// spec with free host ports.  A container port bound on more than one host
+// address gets the same host port on each of them, and no two container
+// ports of the same protocol get the same host port.  This is left until the
+// container is created so the spec, and its hash, stay the same from one
+// build to the next.
+func (spec *containerSpec) resolveFreePorts() error {
+	taken := map[string]bool{}
+	for _, containerPort := range sortedKeys(spec.hostConfig.PortBindings) {
+		bindings := spec.hostConfig.PortBindings[containerPort]
+		var hostIPs []string
+		for _, binding := range bindings {
+			if binding.HostPort == freePort {
+				hostIPs = append(hostIPs, binding.HostIP)
+			}
+		}
+		if len(hostIPs) == 0 {
+			continue
+		}
+
+		proto := containerPort.Proto()
+		hostPort, err := getFreePort(proto, hostIPs, func(port string) bool { return taken[proto+"/"+port] })
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: %s", ErrPortMap, containerPort), err)
+		}
+		taken[proto+"/"+hostPort] = true
+
+		for i := range bindings {
+			if bindings[i].HostPort == freePort {
+				bindings[i].HostPort = hostPort
+			}
+		}
+		if spec.freePorts == nil {
+			spec.freePorts = map[nat.Port]bool{}
+		}
+		spec.freePorts[containerPort] = true
+	}
+	return nil
+}
+
+// usesFreePorts returns true if the spec publishes ports on host ports we
+// pick, see WithFreePorts.
+func (spec *containerSpec) usesFreePorts() bool {
+	return len(spec.free) > 0
+}
+
+// getFreePort returns a host port that is free for proto on each of hostIPs
+// and that taken does not reject.  Since there is no portable way to probe
+// SCTP ports, TCP is probed for them.
+func getFreePort(proto string, hostIPs []string, taken func(string) bool) (string, error) {
+	var err error
+	for range freePortAttempts {
+		var port string
+		port, err = probePort(proto, hostIPs[0], "0")
+		if err != nil {
+			continue
+		}
+		if taken(port) {
+			err = fmt.Errorf("port %s is already used by the container", port)
+			continue
+		}
+		for _, hostIP := range hostIPs[1:] {
+			_, err = probePort(proto, hostIP, port)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return port, nil
+		}
+	}
+	return "", fmt.Errorf("failed to get a free %s port on %v: %w", proto, hostIPs, err)
+}
+
+// probePort binds port, or a port picked by the system for "0", for proto on
+// hostIP, releases it again and returns it.
+func probePort(proto string, hostIP string, port string) (string, error) {
+	switch hostIP {
+	case "0.0.0.0", "::":
+		hostIP = ""
+	}
+	address := net.JoinHostPort(hostIP, port)
+
+	if proto == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		_, bound, err := net.SplitHostPort(conn.LocalAddr().String())
+		return bound, err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	_, bound, err := net.SplitHostPort(listener.Addr().String())
+	return bound, err
+}
+
+// MappedAddress returns the host:port address on the host the container
+// port, e.g. "5432/tcp", is published on, for clients on the host to
+// connect to.  Ports published on all addresses are reached on the loopback
+// address.  The container must have been started, see GetMappedPort.
+func (s *Session) MappedAddress(containerID string, containerPort string) (string, error) {
+	binding, err := s.mappedBinding(containerID, containerPort)
+	if err != nil {
+		return "", err
+	}
+
+	hostIP := binding.HostIP
+	switch hostIP {
+	case "", "0.0.0.0":
+		hostIP = "127.0.0.1"
+	case "::":
+		hostIP = "::1"
+	}
+	return net.JoinHostPort(hostIP, binding.HostPort), nil
+}
+
+// mappedBinding returns the first binding of the container port to a host
+// port.
+func (s *Session) mappedBinding(containerID string, containerPort string) (nat.PortBinding, error) {
+	port, err := newPort(containerPort)
+	if err != nil {
+		return nat.PortBinding{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer cancel()
+
+	inspect, err := s.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nat.PortBinding{}, errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+	}
+
+	if inspect.NetworkSettings != nil {
+		for _, binding := range inspect.NetworkSettings.Ports[port] {
+			if binding.HostPort != "" {
+				return binding, nil
+			}
+		}
+	}
+	return nat.PortBinding{}, fmt.Errorf("%w: %s in %s", ErrPortNotMapped, port, containerID)
+}
+
+// bindsHostPorts returns true if the container has ports published on given
+// host ports rather than ports picked by docker.
+func (s *Session) bindsHostPorts(ctx context.Context, containerID string) bool {
+	inspect, err := s.client.ContainerInspect(ctx, containerID)
+	if err != nil || inspect.HostConfig == nil {
+		return false
+	}
+	for _, bindings := range inspect.HostConfig.PortBindings {
+		for _, binding := range bindings {
+			if binding.HostPort != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPortAllocated returns true if err is docker failing to start a
+// container because a host port cannot be bound since something else is
+// using it.  Docker reports that as a system error like any other failure to
+// start, so we go by the message as well.
+func isPortAllocated(err error) bool {
+	if !errdefs.IsSystem(err) {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "port is already allocated") || strings.Contains(msg, "address already in use")
+}
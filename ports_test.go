@@ -0,0 +1,187 @@
+package udock
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortBindings(t *testing.T) {
+	portmap, exposed, err := portBindings(map[string]string{
+		"8080": "80",
+		"5353": "53/udp",
+		"9899": "9899/SCTP",
+	}, []string{"0.0.0.0"})
+	require.NoError(t, err)
+
+	require.Equal(t, nat.PortMap{
+		"80/tcp":    {{HostIP: "0.0.0.0", HostPort: "8080"}},
+		"53/udp":    {{HostIP: "0.0.0.0", HostPort: "5353"}},
+		"9899/sctp": {{HostIP: "0.0.0.0", HostPort: "9899"}},
+	}, portmap)
+	require.Equal(t, nat.PortSet{"80/tcp": {}, "53/udp": {}, "9899/sctp": {}}, exposed)
+
+	_, _, err = portBindings(map[string]string{"8080": "80/icmp"}, []string{defaultHostIP})
+	require.ErrorIs(t, err, ErrPortMap)
+
+	_, _, err = portBindings(map[string]string{"8080": "http"}, []string{defaultHostIP})
+	require.ErrorIs(t, err, ErrPortMap)
+}
+
+func TestPortRangeBindings(t *testing.T) {
+	portmap, exposed, err := portBindings(map[string]string{
+		"30000-30002": "40000-40002/udp",
+		"":            "21100-21101",
+	}, []string{defaultHostIP})
+	require.NoError(t, err)
+
+	require.Equal(t, nat.PortMap{
+		"40000/udp": {{HostIP: defaultHostIP, HostPort: "30000"}},
+		"40001/udp": {{HostIP: defaultHostIP, HostPort: "30001"}},
+		"40002/udp": {{HostIP: defaultHostIP, HostPort: "30002"}},
+		"21100/tcp": {{HostIP: defaultHostIP}},
+		"21101/tcp": {{HostIP: defaultHostIP}},
+	}, portmap)
+	require.Len(t, exposed, 5)
+
+	// ranges must be the same size
+	_, _, err = portBindings(map[string]string{"30000-30001": "40000-40002"}, []string{defaultHostIP})
+	require.ErrorIs(t, err, ErrPortMap)
+
+	// and well formed
+	_, _, err = portBindings(map[string]string{"30000": "40002-40000"}, []string{defaultHostIP})
+	require.ErrorIs(t, err, ErrPortMap)
+}
+
+func TestFreePortBindings(t *testing.T) {
+	// the free ports are only picked when the container is created, so
+	// the spec hashes the same every time it is built
+	build := func() *containerSpec {
+		spec, err := newContainerSpec("app", nil, WithHostIP("127.0.0.1", "::1"), WithFreePorts("9092-9093", "53/udp"))
+		require.NoError(t, err)
+		return spec
+	}
+	spec := build()
+	require.True(t, spec.usesFreePorts())
+	require.Len(t, spec.hostConfig.PortBindings, 3)
+	require.Equal(t, freePort, spec.hostConfig.PortBindings["9092/tcp"][0].HostPort)
+
+	first, err := build().hash()
+	require.NoError(t, err)
+	second, err := build().hash()
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+
+	require.NoError(t, spec.resolveFreePorts())
+	resolved, err := spec.hash()
+	require.NoError(t, err)
+	require.Equal(t, first, resolved)
+
+	bindings := spec.hostConfig.PortBindings
+	for _, port := range []nat.Port{"9092/tcp", "9093/tcp", "53/udp"} {
+		require.NotEqual(t, freePort, bindings[port][0].HostPort, port)
+		require.Equal(t, bindings[port][0].HostPort, bindings[port][1].HostPort, port)
+	}
+	require.NotEqual(t, bindings["9092/tcp"][0].HostPort, bindings["9093/tcp"][0].HostPort)
+
+	// fixed host ports are part of the hash
+	fixed := func(hostPort string) string {
+		spec, err := newContainerSpec("postgres:16", map[string]string{hostPort: "5432"})
+		require.NoError(t, err)
+		hash, err := spec.hash()
+		require.NoError(t, err)
+		return hash
+	}
+	require.NotEqual(t, fixed("5432"), fixed("5433"))
+	require.Equal(t, fixed("5432"), fixed("5432"))
+
+	plain, err := newContainerSpec("postgres:16", map[string]string{"": "5432"})
+	require.NoError(t, err)
+	require.False(t, plain.usesFreePorts())
+}
+
+func TestGetFreePort(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "sctp"} {
+		port, err := getFreePort(proto, []string{"127.0.0.1"}, func(string) bool { return false })
+		require.NoError(t, err, proto)
+		require.NotEmpty(t, port, proto)
+	}
+
+	// a port that is in use on one of the addresses is not picked
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, used, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	_, err = probePort("tcp", "127.0.0.1", used)
+	require.Error(t, err)
+
+	_, err = getFreePort("tcp", []string{"127.0.0.1"}, func(string) bool { return true })
+	require.Error(t, err)
+}
+
+func TestIsPortAllocated(t *testing.T) {
+	require.True(t, isPortAllocated(errdefs.System(errors.New("Bind for 127.0.0.1:5432 failed: port is already allocated"))))
+	require.True(t, isPortAllocated(errdefs.System(errors.New("driver failed programming external connectivity: listen tcp4 0.0.0.0:5432: bind: address already in use"))))
+	require.False(t, isPortAllocated(errdefs.System(errors.New("OCI runtime create failed: invalid mount"))))
+	require.False(t, isPortAllocated(errdefs.NotFound(errors.New("no such container"))))
+	require.False(t, isPortAllocated(errors.New("port is already allocated")))
+}
+
+func TestMappedAddress(t *testing.T) {
+	// a docker daemon with a single container publishing ports on
+	// different host addresses
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.45/containers/app/json" {
+			http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: "app"},
+			NetworkSettings: &types.NetworkSettings{
+				NetworkSettingsBase: types.NetworkSettingsBase{
+					Ports: nat.PortMap{
+						"80/tcp":   {{HostIP: "0.0.0.0", HostPort: "8080"}},
+						"443/tcp":  {{HostIP: "::", HostPort: "8443"}},
+						"5432/tcp": {{HostIP: "127.0.0.2", HostPort: "15432"}},
+						"53/udp":   {{HostIP: "::1", HostPort: "5353"}},
+						"9092/tcp": nil,
+					},
+				},
+			},
+			Config: &container.Config{},
+		})
+	}))
+	defer server.Close()
+
+	c, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+	s := newSession(c)
+
+	for port, want := range map[string]string{
+		"80":       "127.0.0.1:8080",
+		"443/tcp":  "[::1]:8443",
+		"5432/tcp": "127.0.0.2:15432",
+		"53/udp":   "[::1]:5353",
+	} {
+		addr, err := s.MappedAddress("app", port)
+		require.NoError(t, err, port)
+		require.Equal(t, want, addr, port)
+	}
+
+	_, err = s.MappedAddress("app", "9092")
+	require.ErrorIs(t, err, ErrPortNotMapped)
+
+	_, err = s.MappedAddress("gone", "80")
+	require.ErrorIs(t, err, ErrInspectingContainer)
+}
@@ -0,0 +1,359 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// defaultPullParallelism is the default number of images EnsureImages pulls
+// concurrently.
+const defaultPullParallelism = 4
+
+// PullOption is an option for PullImage and EnsureImages.
+type PullOption func(*pullOptions)
+
+type pullOptions struct {
+	auth     *RegistryAuth
+	progress func(PullProgress)
+	retry    RetryPolicy
+	policy   PullPolicy
+
+	// parallelism is the maximum number of concurrent pulls done by
+	// EnsureImages.
+	parallelism int
+}
+
+// PullPolicy decides whether PullImage pulls images we already have.
+type PullPolicy struct {
+	always bool
+	maxAge time.Duration
+}
+
+var (
+	// PullIfNotPresent only pulls images we do not have.  This is the
+	// default.
+	PullIfNotPresent = PullPolicy{}
+
+	// PullAlways pulls images even if we have them, which makes sure tags
+	// like :latest are up to date.
+	PullAlways = PullPolicy{always: true}
+)
+
+// PullIfOlderThan pulls images we do not have, and images we have that were
+// pulled more than maxAge ago.  Images that PullImage has not pulled or
+// tagged, e.g. ones pulled with the docker CLI, are pulled again since we
+// cannot tell when they were pulled.  Images pinned by digest never change
+// and are never pulled again.
+func PullIfOlderThan(maxAge time.Duration) PullPolicy {
+	return PullPolicy{maxAge: maxAge}
+}
+
+// needsPull returns true if an image we have, which was pulled at pulled,
+// should be pulled again.
+func (p PullPolicy) needsPull(pulled time.Time, now time.Time) bool {
+	if p.always {
+		return true
+	}
+	if p.maxAge > 0 {
+		return now.Sub(pulled) > p.maxAge
+	}
+	return false
+}
+
+// PullProgress is a progress update for one layer of an image being pulled.
+type PullProgress struct {
+	// Image is the image being pulled.
+	Image string
+	// Layer is the ID of the layer this update is for.  It is empty for
+	// updates that concern the image as a whole.
+	Layer string
+	// Status is the status reported by docker, e.g. "Downloading",
+	// "Extracting" or "Pull complete".
+	Status string
+	// Current is the number of bytes downloaded or extracted so far.
+	Current int64
+	// Total is the size of the layer in bytes, if known.
+	Total int64
+}
+
+// WithPullAuth makes PullImage authenticate against the registry using auth.
+// This is needed for pulling from private registries.
+func WithPullAuth(auth RegistryAuth) PullOption {
+	return func(o *pullOptions) {
+		o.auth = &auth
+	}
+}
+
+// WithPullProgress makes PullImage call fn for every progress update it gets
+// from docker while pulling.  fn is called from the goroutine calling
+// PullImage.
+func WithPullProgress(fn func(PullProgress)) PullOption {
+	return func(o *pullOptions) {
+		o.progress = fn
+	}
+}
+
+// WithPullRetry makes PullImage retry failed pulls according to policy.  By
+// default a failed pull is not retried.
+func WithPullRetry(policy RetryPolicy) PullOption {
+	return func(o *pullOptions) {
+		o.retry = policy
+	}
+}
+
+// WithPullPolicy sets the policy for when PullImage pulls images we already
+// have.  The default is PullIfNotPresent.
+func WithPullPolicy(policy PullPolicy) PullOption {
+	return func(o *pullOptions) {
+		o.policy = policy
+	}
+}
+
+// WithPullParallelism sets the maximum number of images EnsureImages pulls
+// concurrently.  The default is 4.
+func WithPullParallelism(n int) PullOption {
+	return func(o *pullOptions) {
+		o.parallelism = n
+	}
+}
+
+// PullImage checks if we have an image and if we do not have the image pulls a
+// docker image.  Whether images we already have are pulled again is decided
+// by the pull policy.  Returns a nil error if ok and an error value if
+// something went wrong.
+func (s *Session) PullImage(dockerImage string, opts ...PullOption) error {
+	options := pullOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	err := s.VerifyHaveImage(dockerImage)
+	if err == nil {
+		pull, err := s.shouldRepull(dockerImage, options.policy)
+		if err != nil {
+			return err
+		}
+		if !pull {
+			slog.Info("already have image, not pulling", "dockerImage", dockerImage)
+			return s.verifyImage(dockerImage)
+		}
+		slog.Info("already have image, pulling according to pull policy", "dockerImage", dockerImage)
+	}
+
+	pullImage, err := s.mirrorImage(dockerImage)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrPullingImage, dockerImage), err)
+	}
+
+	registryAuth, err := s.resolveAuth(pullImage, options.auth)
+	if err != nil {
+		return err
+	}
+	pullOpts := image.PullOptions{
+		All:          false,
+		RegistryAuth: registryAuth,
+		Platform:     formatPlatform(s.platform),
+	}
+
+	slog.Info("pulling image", "dockerImage", dockerImage, "from", pullImage)
+	err = options.retry.retry(func() error {
+		return s.pull(dockerImage, pullImage, pullOpts, options.progress)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Tagging the image also records when it was pulled, see
+	// PullIfOlderThan.
+	err = s.tagPulled(dockerImage, pullImage)
+	if err != nil {
+		return err
+	}
+
+	err = s.verifyImage(dockerImage)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("done pulling image", "dockerImage", dockerImage)
+	return nil
+}
+
+// EnsureImages makes sure we have all the images in dockerImages, pulling
+// them concurrently as needed.  The options are applied to every pull.  This
+// is intended for warming up all the images a test suite needs once, e.g. in
+// TestMain.  If any of the pulls fail the errors are joined and returned.
+func (s *Session) EnsureImages(dockerImages []string, opts ...PullOption) error {
+	options := pullOptions{parallelism: defaultPullParallelism}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.parallelism < 1 {
+		options.parallelism = 1
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		sem  = make(chan struct{}, options.parallelism)
+		seen = map[string]bool{}
+	)
+	for _, dockerImage := range dockerImages {
+		if seen[dockerImage] {
+			continue
+		}
+		seen[dockerImage] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			err := s.PullImage(dockerImage, opts...)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", dockerImage, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// shouldRepull returns true if the pull policy says we should pull an image we
+// already have.
+func (s *Session) shouldRepull(dockerImage string, policy PullPolicy) (bool, error) {
+	if policy.always {
+		return true, nil
+	}
+	if policy.maxAge == 0 || strings.Contains(dockerImage, "@") {
+		return false, nil
+	}
+
+	// How old the image is says nothing about how long ago we pulled it,
+	// so we go by when it was tagged, which we do after every pull.
+	info, err := s.InspectImage(dockerImage)
+	if err != nil {
+		return false, err
+	}
+	if info.LastTagTime.IsZero() {
+		return true, nil
+	}
+	return policy.needsPull(info.LastTagTime, time.Now()), nil
+}
+
+// pull performs a single attempt at pulling pullImage on behalf of
+// dockerImage.
+func (s *Session) pull(dockerImage string, pullImage string, pullOpts image.PullOptions, progress func(PullProgress)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
+	defer cancel()
+
+	resp, err := s.client.ImagePull(ctx, pullImage, pullOpts)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrPullingImage, dockerImage), err)
+	}
+	defer resp.Close()
+
+	err = readJSONMessages(resp, func(msg jsonmessage.JSONMessage) {
+		if progress != nil {
+			progress(newPullProgress(dockerImage, msg))
+		}
+	})
+	if err != nil {
+		return errors.Join(ErrReadingPulledImage, err)
+	}
+	return nil
+}
+
+// tagPulled tags the image pulled as pullImage with dockerImage.  docker
+// refuses to create tags containing a digest, so images pulled by digest
+// from a mirror keep the name of the mirror, which the session uses for them
+// from then on, and are tagged with the tag of dockerImage if it has one.
+func (s *Session) tagPulled(dockerImage string, pullImage string) error {
+	if !strings.Contains(dockerImage, "@") {
+		return s.TagImage(pullImage, dockerImage)
+	}
+	if pullImage == dockerImage {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.mirrored[dockerImage] = pullImage
+	s.mu.Unlock()
+
+	named, err := reference.ParseNormalizedNamed(dockerImage)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrTaggingImage, dockerImage), err)
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		return s.TagImage(pullImage, reference.FamiliarName(named)+":"+tagged.Tag())
+	}
+	return nil
+}
+
+// localImage returns the name docker knows dockerImage by, which is the name
+// of the mirror for images this session pulled by digest from a mirror.
+func (s *Session) localImage(dockerImage string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if local, ok := s.mirrored[dockerImage]; ok {
+		return local
+	}
+	return dockerImage
+}
+
+// mirrorImage rewrites dockerImage to point to the configured mirror for its
+// registry.  If there is no mirror for the registry dockerImage is returned
+// unchanged.
+func (s *Session) mirrorImage(dockerImage string) (string, error) {
+	if len(s.mirrors) == 0 {
+		return dockerImage, nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(dockerImage)
+	if err != nil {
+		return "", err
+	}
+
+	mirror, ok := s.mirrors[reference.Domain(named)]
+	if !ok {
+		return dockerImage, nil
+	}
+
+	rewritten := mirror + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		rewritten += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		rewritten += "@" + digested.Digest().String()
+	}
+	return rewritten, nil
+}
+
+// newPullProgress converts a message from the pull stream to a PullProgress.
+func newPullProgress(dockerImage string, msg jsonmessage.JSONMessage) PullProgress {
+	progress := PullProgress{
+		Image:  dockerImage,
+		Layer:  msg.ID,
+		Status: msg.Status,
+	}
+	if msg.Progress != nil {
+		progress.Current = msg.Progress.Current
+		progress.Total = msg.Progress.Total
+	}
+	return progress
+}
@@ -0,0 +1,78 @@
+package udock
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorImage(t *testing.T) {
+	s := &Session{}
+	require.NoError(t, WithRegistryMirror("docker.io", "mirror.internal.example.com")(s))
+	require.NoError(t, WithRegistryMirror("ghcr.io", "ghcr-cache.internal.example.com:5000")(s))
+
+	for image, want := range map[string]string{
+		"postgres":                   "mirror.internal.example.com/library/postgres",
+		"postgres:16":                "mirror.internal.example.com/library/postgres:16",
+		"hashicorp/http-echo:latest": "mirror.internal.example.com/hashicorp/http-echo:latest",
+		"docker.io/library/redis:7":  "mirror.internal.example.com/library/redis:7",
+		"ghcr.io/borud/udock:v1":     "ghcr-cache.internal.example.com:5000/borud/udock:v1",
+		"quay.io/coreos/etcd:v3.5.0": "quay.io/coreos/etcd:v3.5.0",
+		"alpine@sha256:1111111111111111111111111111111111111111111111111111111111111111": "mirror.internal.example.com/library/alpine@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	} {
+		got, err := s.mirrorImage(image)
+		require.NoError(t, err)
+		require.Equal(t, want, got, image)
+	}
+
+	// without mirrors images are left alone
+	got, err := (&Session{}).mirrorImage("postgres")
+	require.NoError(t, err)
+	require.Equal(t, "postgres", got)
+}
+
+func TestMirroredDigest(t *testing.T) {
+	const (
+		image  = "alpine@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		mirror = "mirror.internal.example.com/library/" + image
+	)
+	s := &Session{mirrored: map[string]string{}}
+	require.Equal(t, image, s.localImage(image))
+
+	// pinned by digest alone there is no tag to add, so no docker client is
+	// needed
+	require.NoError(t, s.tagPulled(image, mirror))
+	require.Equal(t, mirror, s.localImage(image))
+	require.Equal(t, "alpine:3", s.localImage("alpine:3"))
+}
+
+func TestNewPullProgress(t *testing.T) {
+	msgs := `{"status":"Pulling from library/alpine","id":"latest"}
+{"status":"Downloading","id":"abc123","progressDetail":{"current":1024,"total":4096}}
+{"status":"Pull complete","id":"abc123"}
+`
+	var updates []PullProgress
+	err := readJSONMessages(strings.NewReader(msgs), func(msg jsonmessage.JSONMessage) {
+		updates = append(updates, newPullProgress("alpine", msg))
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []PullProgress{
+		{Image: "alpine", Layer: "latest", Status: "Pulling from library/alpine"},
+		{Image: "alpine", Layer: "abc123", Status: "Downloading", Current: 1024, Total: 4096},
+		{Image: "alpine", Layer: "abc123", Status: "Pull complete"},
+	}, updates)
+}
+
+func TestPullPolicy(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	require.False(t, PullIfNotPresent.needsPull(now.Add(-365*day), now))
+	require.True(t, PullAlways.needsPull(now, now))
+	require.False(t, PullIfOlderThan(7*day).needsPull(now.Add(-6*day), now))
+	require.True(t, PullIfOlderThan(7*day).needsPull(now.Add(-8*day), now))
+}
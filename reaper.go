@@ -0,0 +1,138 @@
+package udock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultReaperImage is the image of the reaper.  We use Ryuk from the
+	// Testcontainers project, which removes everything matching the label
+	// filters it is given once the last connection to it has gone away.
+	defaultReaperImage = "testcontainers/ryuk:0.11.0"
+
+	// reaperPort is the port the reaper listens on.
+	reaperPort = "8080"
+
+	// reaperDockerSocket is the docker socket the reaper is given.
+	reaperDockerSocket = "/var/run/docker.sock"
+
+	// reaperConnectTimeout is how long we wait for the reaper to accept our
+	// connection and acknowledge the label filter once it has started.
+	reaperConnectTimeout = 10 * time.Second
+)
+
+// WithReaper makes the session start a reaper container that removes all
+// containers, networks and volumes created by the session if the test
+// process dies without calling Close, e.g. because the CI job was killed.
+// The reaper notices when the process goes away and cleans up shortly
+// after.  Since the reaper also cleans up after a regular Close, it does not
+// go well with WithKeepVolumes.
+func WithReaper() SessionOption {
+	return func(s *Session) error {
+		s.reaper = true
+		return nil
+	}
+}
+
+// startReaper starts the reaper and tells it to look after the resources
+// labeled with the session ID.
+func (s *Session) startReaper() error {
+	err := s.PullImage(defaultReaperImage)
+	if err != nil {
+		return errors.Join(ErrStartingReaper, err)
+	}
+
+	// We do not use the session defaults here since the reaper must not
+	// carry the session label or it would reap itself.
+	spec, err := newContainerSpec(defaultReaperImage, nil,
+		WithPublishedPorts(reaperPort),
+		WithBindMount(reaperDockerSocket, reaperDockerSocket, false),
+		WithLabels(map[string]string{LabelManaged: "true", LabelReaper: s.id}),
+	)
+	if err != nil {
+		return errors.Join(ErrStartingReaper, err)
+	}
+
+	containerID, err := s.createContainer(spec, nil, "")
+	if err != nil {
+		return errors.Join(ErrStartingReaper, err)
+	}
+
+	err = s.StartContainer(containerID)
+	if err != nil {
+		return errors.Join(ErrStartingReaper, err, s.RemoveContainer(containerID))
+	}
+
+	port, err := s.GetMappedPort(containerID, reaperPort)
+	if err != nil {
+		return errors.Join(ErrStartingReaper, err, s.RemoveContainer(containerID))
+	}
+
+	conn, err := connectReaper(net.JoinHostPort(defaultHostIP, port), LabelSession+"="+s.id)
+	if err != nil {
+		return errors.Join(ErrStartingReaper, err, s.RemoveContainer(containerID))
+	}
+
+	s.reaperConn = conn
+	return nil
+}
+
+// connectReaper connects to the reaper at addr and registers the label
+// filter.  The reaper needs a moment to start listening, so we retry until
+// reaperConnectTimeout has passed.
+func connectReaper(addr string, label string) (net.Conn, error) {
+	deadline := time.Now().Add(reaperConnectTimeout)
+	for {
+		conn, err := registerWithReaper(addr, label, deadline)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: connecting to reaper at %s: %w", ErrTimeout, addr, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// registerWithReaper sends the label filter to the reaper at addr and waits
+// for it to acknowledge it.
+func registerWithReaper(addr string, label string, deadline time.Time) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Until(deadline))
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetDeadline(deadline)
+	if err == nil {
+		_, err = fmt.Fprintf(conn, "label=%s\n", label)
+	}
+	var ack string
+	if err == nil {
+		ack, err = bufio.NewReader(conn).ReadString('\n')
+	}
+	if err == nil && strings.TrimSpace(ack) != "ACK" {
+		err = fmt.Errorf("unexpected reply from reaper: %q", ack)
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// stopReaper closes the connection to the reaper, which makes it clean up
+// whatever the session left behind and exit.
+func (s *Session) stopReaper() {
+	if s.reaperConn != nil {
+		s.reaperConn.Close()
+		s.reaperConn = nil
+	}
+}
@@ -0,0 +1,48 @@
+package udock
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReaper accepts a single connection, reads the label filter, replies
+// with reply and hands the filter to the returned channel.
+func fakeReaper(t *testing.T, reply string) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	filters := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		filters <- line
+		_, _ = conn.Write([]byte(reply))
+	}()
+	return listener.Addr().String(), filters
+}
+
+func TestConnectReaper(t *testing.T) {
+	addr, filters := fakeReaper(t, "ACK\n")
+
+	conn, err := connectReaper(addr, LabelSession+"=abc123")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "label=udock.session=abc123\n", <-filters)
+}
+
+func TestRegisterWithReaperUnexpectedReply(t *testing.T) {
+	addr, _ := fakeReaper(t, "NOPE\n")
+
+	_, err := registerWithReaper(addr, LabelSession+"=abc123", time.Now().Add(time.Second))
+	require.Error(t, err)
+}
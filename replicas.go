@@ -0,0 +1,124 @@
+package udock
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Replica is one of the containers started by RunReplicas.
+type Replica struct {
+	session *Session
+
+	// Index of the replica, counting from 0.
+	Index int
+
+	// Name of the container, which is the name of the spec with the index
+	// counting from 1 appended, e.g. "node-1".
+	Name string
+
+	// ContainerID is the ID of the container.
+	ContainerID string
+}
+
+// RunReplicas runs n identical containers as described by spec, e.g. for
+// testing client side load balancing or clustering, and returns them in
+// order.  The containers are named after the spec, or a unique name if the
+// spec has no name, with the number of the replica appended.  Since the
+// replicas cannot share host ports, the container ports in Ports are
+// published on host ports picked by docker instead of the host ports given,
+// see Replica.Endpoint.  The init containers of the spec are run once,
+// before any of the replicas is started, and every replica gets its own
+// sidecars, named like the replicas if the sidecars have names.  The replicas
+// are started concurrently like RunAll starts specs that do not depend on
+// each other, and if any of them fails to start the others are removed
+// again.
+func (s *Session) RunReplicas(spec ContainerSpec, n int) ([]Replica, error) {
+	spec, err := spec.expandVars()
+	if err != nil {
+		return nil, err
+	}
+
+	specs, err := replicaSpecs(spec, n)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, initContainer := range spec.InitContainers {
+		err := s.runInitContainer(context.Background(), initContainer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	containers, err := s.runAll(context.Background(), specs)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]Replica, 0, n)
+	for i, replica := range specs {
+		replicas = append(replicas, Replica{
+			session:     s,
+			Index:       i,
+			Name:        replica.Name,
+			ContainerID: containers[replica.Name],
+		})
+	}
+	return replicas, nil
+}
+
+// Endpoint returns the host:port address on the host that port of the
+// replica is published on, on the host address it is bound to, see
+// WithHostIP.
+func (r Replica) Endpoint(port string) (string, error) {
+	return r.session.MappedAddress(r.ContainerID, port)
+}
+
+// Remove removes the replica.
+func (r Replica) Remove() error {
+	return r.session.RemoveContainer(r.ContainerID)
+}
+
+// replicaSpecs returns the specs of n replicas of spec.
+func replicaSpecs(spec ContainerSpec, n int) ([]ContainerSpec, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("%w: need at least one replica, got %d", ErrInvalidOption, n)
+	}
+	if spec.Reuse {
+		return nil, fmt.Errorf("%w: replicas cannot be reused", ErrInvalidOption)
+	}
+
+	name := spec.Name
+	if name == "" {
+		name = UniqueName("replica", "")
+	}
+
+	options := slices.Clip(spec.Options)
+	if len(spec.Ports) > 0 {
+		var containerPorts []string
+		for _, hostPort := range sortedKeys(spec.Ports) {
+			containerPorts = append(containerPorts, spec.Ports[hostPort])
+		}
+		options = append(options, WithPublishedPorts(containerPorts...))
+	}
+
+	specs := make([]ContainerSpec, 0, n)
+	for i := range n {
+		replica := spec
+		replica.Name = fmt.Sprintf("%s-%d", name, i+1)
+		replica.Ports = nil
+		replica.Options = slices.Clip(options)
+		replica.DependsOn = nil
+		replica.InitContainers = nil
+		replica.Sidecars = nil
+		for _, sidecar := range spec.Sidecars {
+			if sidecar.Name != "" {
+				sidecar.Name = fmt.Sprintf("%s-%d", sidecar.Name, i+1)
+			}
+			replica.Sidecars = append(replica.Sidecars, sidecar)
+		}
+		specs = append(specs, replica)
+	}
+	return specs, nil
+}
@@ -0,0 +1,55 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaSpecs(t *testing.T) {
+	specs, err := replicaSpecs(ContainerSpec{
+		Image:     "app",
+		Name:      "node",
+		Ports:     map[string]string{"8080": "80", "9090": "9000"},
+		DependsOn: []string{"db"},
+	}, 3)
+	require.NoError(t, err)
+	require.Len(t, specs, 3)
+
+	for i, spec := range specs {
+		require.Equal(t, []string{"node-1", "node-2", "node-3"}[i], spec.Name)
+		require.Nil(t, spec.Ports)
+		require.Nil(t, spec.DependsOn)
+
+		built, err := newContainerSpec(spec.Image, spec.Ports, spec.Options...)
+		require.NoError(t, err)
+		require.Len(t, built.hostConfig.PortBindings, 2)
+		for _, bindings := range built.hostConfig.PortBindings {
+			require.Empty(t, bindings[0].HostPort)
+		}
+	}
+
+	specs, err = replicaSpecs(ContainerSpec{
+		Image:          "app",
+		InitContainers: []ContainerSpec{{Image: "migrate"}},
+		Sidecars:       []ContainerSpec{{Image: "scraper", Name: "scraper"}, {Image: "tcpdump"}},
+	}, 2)
+	require.NoError(t, err)
+	for i, spec := range specs {
+		require.Empty(t, spec.InitContainers)
+		require.Len(t, spec.Sidecars, 2)
+		require.Equal(t, []string{"scraper-1", "scraper-2"}[i], spec.Sidecars[0].Name)
+		require.Empty(t, spec.Sidecars[1].Name)
+	}
+
+	specs, err = replicaSpecs(ContainerSpec{Image: "app"}, 2)
+	require.NoError(t, err)
+	require.NotEqual(t, specs[0].Name, specs[1].Name)
+	require.Regexp(t, `^replica-.*-1$`, specs[0].Name)
+
+	_, err = replicaSpecs(ContainerSpec{Image: "app"}, 0)
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = replicaSpecs(ContainerSpec{Image: "app", Reuse: true}, 2)
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,202 @@
+package udock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// WithMemoryLimit limits the memory the container can use to bytes.  If the
+// container goes over the limit it is killed by the OOM killer, unless that
+// has been disabled.
+func WithMemoryLimit(bytes int64) ContainerOption {
+	return func(spec *containerSpec) error {
+		if bytes <= 0 {
+			return fmt.Errorf("%w: memory limit must be positive, got %d", ErrInvalidOption, bytes)
+		}
+		spec.hostConfig.Memory = bytes
+		return nil
+	}
+}
+
+// WithMemorySwap limits the memory plus swap the container can use to bytes.
+// It must be at least the memory limit, or -1 for unlimited swap.  If the
+// memory limit is set and WithMemorySwap is not given, docker allows the
+// container as much swap as it has memory.
+func WithMemorySwap(bytes int64) ContainerOption {
+	return func(spec *containerSpec) error {
+		if bytes <= 0 && bytes != -1 {
+			return fmt.Errorf("%w: memory swap limit must be positive or -1, got %d", ErrInvalidOption, bytes)
+		}
+		spec.hostConfig.MemorySwap = bytes
+		return nil
+	}
+}
+
+// WithOOMKillDisable stops the OOM killer from killing the container when it
+// goes over its memory limit; instead its allocations stall until memory is
+// freed.  It requires a memory limit, since without one the container could
+// exhaust the memory of the host.
+func WithOOMKillDisable() ContainerOption {
+	return func(spec *containerSpec) error {
+		disable := true
+		spec.hostConfig.OomKillDisable = &disable
+		return nil
+	}
+}
+
+// WithOOMScoreAdj adjusts how likely the OOM killer is to pick the container
+// when the host runs out of memory, from -1000 (never) to 1000 (first).
+func WithOOMScoreAdj(score int) ContainerOption {
+	return func(spec *containerSpec) error {
+		if score < -1000 || score > 1000 {
+			return fmt.Errorf("%w: OOM score adjustment must be between -1000 and 1000, got %d", ErrInvalidOption, score)
+		}
+		spec.hostConfig.OomScoreAdj = score
+		return nil
+	}
+}
+
+// WithShmSize sets the size of /dev/shm in the container to bytes.  The
+// docker default of 64MB is too small for Postgres with parallel workers and
+// for headless browsers.
+func WithShmSize(bytes int64) ContainerOption {
+	return func(spec *containerSpec) error {
+		if bytes <= 0 {
+			return fmt.Errorf("%w: shm size must be positive, got %d", ErrInvalidOption, bytes)
+		}
+		spec.hostConfig.ShmSize = bytes
+		return nil
+	}
+}
+
+// WithStorageSize limits the size of the writable layer of the container,
+// e.g. "1G".  This is only supported by some storage drivers, such as
+// overlay2 on xfs with project quotas, and container creation fails if the
+// storage driver does not support it.
+func WithStorageSize(size string) ContainerOption {
+	return func(spec *containerSpec) error {
+		if size == "" {
+			return fmt.Errorf("%w: empty storage size", ErrInvalidOption)
+		}
+		if spec.hostConfig.StorageOpt == nil {
+			spec.hostConfig.StorageOpt = map[string]string{}
+		}
+		spec.hostConfig.StorageOpt["size"] = size
+		return nil
+	}
+}
+
+// WithCPUs limits the container to cpus CPUs worth of CPU time, e.g. 1.5,
+// just like --cpus does for docker run.
+func WithCPUs(cpus float64) ContainerOption {
+	return func(spec *containerSpec) error {
+		if cpus <= 0 {
+			return fmt.Errorf("%w: number of CPUs must be positive, got %g", ErrInvalidOption, cpus)
+		}
+		spec.hostConfig.NanoCPUs = int64(cpus * 1e9)
+		return nil
+	}
+}
+
+// WithCPUShares sets the relative weight of the container when competing for
+// CPU with other containers.  The default weight is 1024.
+func WithCPUShares(shares int64) ContainerOption {
+	return func(spec *containerSpec) error {
+		if shares <= 0 {
+			return fmt.Errorf("%w: CPU shares must be positive, got %d", ErrInvalidOption, shares)
+		}
+		spec.hostConfig.CPUShares = shares
+		return nil
+	}
+}
+
+// WithCPUQuota limits the container to quota of CPU time per period.  Periods
+// must be between 1ms and 1s.  This is a lower level alternative to WithCPUs,
+// the two cannot be combined.
+func WithCPUQuota(quota time.Duration, period time.Duration) ContainerOption {
+	return func(spec *containerSpec) error {
+		if period < time.Millisecond || period > time.Second {
+			return fmt.Errorf("%w: CPU period must be between 1ms and 1s, got %s", ErrInvalidOption, period)
+		}
+		if quota < time.Millisecond {
+			return fmt.Errorf("%w: CPU quota must be at least 1ms, got %s", ErrInvalidOption, quota)
+		}
+		spec.hostConfig.CPUQuota = quota.Microseconds()
+		spec.hostConfig.CPUPeriod = period.Microseconds()
+		return nil
+	}
+}
+
+// WithCPUSet pins the container to the given CPUs, e.g. "0-3" or "0,2".
+func WithCPUSet(cpus string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.CpusetCpus = cpus
+		return nil
+	}
+}
+
+// WithUlimit sets the soft and hard limits for the resource name, e.g.
+// WithUlimit("nofile", 65536, 65536) or WithUlimit("memlock", -1, -1) for
+// unlimited.  Setting a limit that has already been set replaces it.
+func WithUlimit(name string, soft int64, hard int64) ContainerOption {
+	return func(spec *containerSpec) error {
+		if name == "" {
+			return fmt.Errorf("%w: empty ulimit name", ErrInvalidOption)
+		}
+		if hard != -1 && (soft == -1 || soft > hard) {
+			return fmt.Errorf("%w: soft limit for %s is above the hard limit", ErrInvalidOption, name)
+		}
+
+		ulimit := &container.Ulimit{Name: name, Soft: soft, Hard: hard}
+		for i, u := range spec.hostConfig.Ulimits {
+			if u.Name == name {
+				spec.hostConfig.Ulimits[i] = ulimit
+				return nil
+			}
+		}
+		spec.hostConfig.Ulimits = append(spec.hostConfig.Ulimits, ulimit)
+		return nil
+	}
+}
+
+// WithCgroupParent places the container in the cgroup parent, e.g. a
+// dedicated systemd slice for the containers started by the test suite.
+func WithCgroupParent(parent string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.CgroupParent = parent
+		return nil
+	}
+}
+
+// WithCgroupnsMode sets the cgroup namespace mode of the container, which is
+// either "private" or "host".
+func WithCgroupnsMode(mode string) ContainerOption {
+	return func(spec *containerSpec) error {
+		cgroupnsMode := container.CgroupnsMode(mode)
+		if cgroupnsMode.IsEmpty() || !cgroupnsMode.Valid() {
+			return fmt.Errorf("%w: invalid cgroup namespace mode %q", ErrInvalidOption, mode)
+		}
+		spec.hostConfig.CgroupnsMode = cgroupnsMode
+		return nil
+	}
+}
+
+// validateResources checks that the resource limits make sense together.
+func (spec *containerSpec) validateResources() error {
+	r := spec.hostConfig.Resources
+	if r.MemorySwap != 0 && r.Memory == 0 {
+		return fmt.Errorf("%w: memory swap limit requires a memory limit", ErrInvalidOption)
+	}
+	if r.MemorySwap > 0 && r.MemorySwap < r.Memory {
+		return fmt.Errorf("%w: memory swap limit %d is less than memory limit %d", ErrInvalidOption, r.MemorySwap, r.Memory)
+	}
+	if r.OomKillDisable != nil && *r.OomKillDisable && r.Memory == 0 {
+		return fmt.Errorf("%w: disabling the OOM killer requires a memory limit", ErrInvalidOption)
+	}
+	if r.NanoCPUs != 0 && r.CPUQuota != 0 {
+		return fmt.Errorf("%w: cannot combine number of CPUs with CPU quota", ErrInvalidOption)
+	}
+	return nil
+}
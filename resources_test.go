@@ -0,0 +1,116 @@
+package udock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	spec, err := newContainerSpec("redis", nil, WithMemoryLimit(256<<20), WithMemorySwap(512<<20))
+	require.NoError(t, err)
+	require.Equal(t, int64(256<<20), spec.hostConfig.Memory)
+	require.Equal(t, int64(512<<20), spec.hostConfig.MemorySwap)
+
+	spec, err = newContainerSpec("redis", nil, WithMemoryLimit(256<<20), WithMemorySwap(-1))
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), spec.hostConfig.MemorySwap)
+
+	for _, opts := range [][]ContainerOption{
+		{WithMemoryLimit(0)},
+		{WithMemorySwap(512 << 20)},
+		{WithMemoryLimit(512 << 20), WithMemorySwap(256 << 20)},
+		{WithMemoryLimit(512 << 20), WithMemorySwap(-2)},
+	} {
+		_, err = newContainerSpec("redis", nil, opts...)
+		require.ErrorIs(t, err, ErrInvalidOption)
+	}
+}
+
+func TestCPULimits(t *testing.T) {
+	spec, err := newContainerSpec("redis", nil, WithCPUs(1.5), WithCPUShares(512), WithCPUSet("0-1"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1500000000), spec.hostConfig.NanoCPUs)
+	require.Equal(t, int64(512), spec.hostConfig.CPUShares)
+	require.Equal(t, "0-1", spec.hostConfig.CpusetCpus)
+
+	spec, err = newContainerSpec("redis", nil, WithCPUQuota(50*time.Millisecond, 100*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, int64(50000), spec.hostConfig.CPUQuota)
+	require.Equal(t, int64(100000), spec.hostConfig.CPUPeriod)
+
+	for _, opts := range [][]ContainerOption{
+		{WithCPUs(0)},
+		{WithCPUShares(-1)},
+		{WithCPUQuota(time.Millisecond, 2*time.Second)},
+		{WithCPUQuota(0, 100*time.Millisecond)},
+		{WithCPUs(1), WithCPUQuota(50*time.Millisecond, 100*time.Millisecond)},
+	} {
+		_, err = newContainerSpec("redis", nil, opts...)
+		require.ErrorIs(t, err, ErrInvalidOption)
+	}
+}
+
+func TestUlimit(t *testing.T) {
+	spec, err := newContainerSpec("elasticsearch", nil,
+		WithUlimit("nofile", 1024, 1024),
+		WithUlimit("memlock", -1, -1),
+		WithUlimit("nofile", 65536, 65536),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []*container.Ulimit{
+		{Name: "nofile", Soft: 65536, Hard: 65536},
+		{Name: "memlock", Soft: -1, Hard: -1},
+	}, spec.hostConfig.Ulimits)
+
+	_, err = newContainerSpec("elasticsearch", nil, WithUlimit("nofile", 2048, 1024))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("elasticsearch", nil, WithUlimit("", 1, 1))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestOOM(t *testing.T) {
+	spec, err := newContainerSpec("redis", nil, WithMemoryLimit(64<<20), WithOOMKillDisable(), WithOOMScoreAdj(500))
+	require.NoError(t, err)
+	require.True(t, *spec.hostConfig.OomKillDisable)
+	require.Equal(t, 500, spec.hostConfig.OomScoreAdj)
+
+	_, err = newContainerSpec("redis", nil, WithOOMKillDisable())
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("redis", nil, WithOOMScoreAdj(1001))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestShmSize(t *testing.T) {
+	spec, err := newContainerSpec("postgres:16", nil, WithShmSize(1<<30))
+	require.NoError(t, err)
+	require.Equal(t, int64(1<<30), spec.hostConfig.ShmSize)
+
+	_, err = newContainerSpec("postgres:16", nil, WithShmSize(0))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestCgroup(t *testing.T) {
+	spec, err := newContainerSpec("redis", nil, WithCgroupParent("udock.slice"), WithCgroupnsMode("private"))
+	require.NoError(t, err)
+	require.Equal(t, "udock.slice", spec.hostConfig.CgroupParent)
+	require.Equal(t, container.CgroupnsModePrivate, spec.hostConfig.CgroupnsMode)
+
+	for _, invalid := range []string{"", "shared"} {
+		_, err = newContainerSpec("redis", nil, WithCgroupnsMode(invalid))
+		require.ErrorIs(t, err, ErrInvalidOption, invalid)
+	}
+}
+
+func TestStorageSize(t *testing.T) {
+	spec, err := newContainerSpec("redis", nil, WithStorageSize("1G"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"size": "1G"}, spec.hostConfig.StorageOpt)
+
+	_, err = newContainerSpec("redis", nil, WithStorageSize(""))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,148 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// RetryPolicy describes how an operation is retried when it fails.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first.
+	// Values less than 1 are treated as 1.
+	Attempts int
+
+	// InitialBackoff is how long we wait before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the time we wait between attempts.  Zero means no
+	// cap.
+	MaxBackoff time.Duration
+
+	// Multiplier is what the backoff is multiplied by after each attempt.
+	// Values less than 1 are treated as 2.
+	Multiplier float64
+
+	// RetryOn decides whether an error is worth retrying.  If nil,
+	// IsTransientError is used.
+	RetryOn func(error) bool
+}
+
+// DefaultRetryPolicy is a retry policy suitable for pulling images in CI,
+// where rate limits and transient registry errors are common.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+// transientErrorMessages are fragments of error messages from registries and
+// the network that indicate that trying again later may help.
+var transientErrorMessages = []string{
+	"toomanyrequests",
+	"too many requests",
+	"rate limit",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"internal server error",
+	"connection reset",
+	"connection refused",
+	"i/o timeout",
+	"tls handshake timeout",
+	"unexpected eof",
+}
+
+// IsTransientError returns true if err looks like a temporary failure such
+// as a rate limit, a 5xx response from the registry or a network hiccup.
+// Errors such as unknown images or authentication failures are not
+// transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errdefs.IsNotFound(err) ||
+		errdefs.IsUnauthorized(err) ||
+		errdefs.IsForbidden(err) ||
+		errdefs.IsInvalidParameter(err) {
+		return false
+	}
+
+	if errdefs.IsUnavailable(err) || errdefs.IsDeadline(err) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range transientErrorMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// attempts returns the number of attempts the policy allows.
+func (p RetryPolicy) attempts() int {
+	if p.Attempts < 1 {
+		return 1
+	}
+	return p.Attempts
+}
+
+// backoff returns how long to wait after the given failed attempt, counting
+// from 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	backoff := float64(p.InitialBackoff)
+	if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	for i := 1; i < attempt; i++ {
+		backoff *= multiplier
+		if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(backoff)
+}
+
+// shouldRetry returns true if err should be retried under the policy.
+func (p RetryPolicy) shouldRetry(err error) bool {
+	if p.RetryOn != nil {
+		return p.RetryOn(err)
+	}
+	return IsTransientError(err)
+}
+
+// retry calls fn until it succeeds, returns an error the policy does not
+// consider worth retrying, or we run out of attempts.
+func (p RetryPolicy) retry(fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= p.attempts(); attempt++ {
+		err = fn()
+		if err == nil || !p.shouldRetry(err) || attempt == p.attempts() {
+			return err
+		}
+
+		backoff := p.backoff(attempt)
+		slog.Warn("operation failed, retrying", "attempt", attempt, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+	}
+	return err
+}
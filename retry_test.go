@@ -0,0 +1,91 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{
+		Attempts:       10,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	require.Equal(t, 100*time.Millisecond, p.backoff(1))
+	require.Equal(t, 200*time.Millisecond, p.backoff(2))
+	require.Equal(t, 400*time.Millisecond, p.backoff(3))
+	require.Equal(t, 800*time.Millisecond, p.backoff(4))
+	require.Equal(t, time.Second, p.backoff(5))
+	require.Equal(t, time.Second, p.backoff(9))
+
+	// the cap applies to the first wait too
+	p.InitialBackoff = 2 * time.Second
+	require.Equal(t, time.Second, p.backoff(1))
+	require.Equal(t, time.Second, p.backoff(2))
+
+	// zero value means a single attempt
+	require.Equal(t, 1, RetryPolicy{}.attempts())
+}
+
+func TestIsTransientError(t *testing.T) {
+	require.False(t, IsTransientError(nil))
+	require.False(t, IsTransientError(errors.New("manifest unknown")))
+	require.False(t, IsTransientError(errdefs.NotFound(errors.New("no such image"))))
+	require.False(t, IsTransientError(errdefs.Unauthorized(errors.New("toomanyrequests"))))
+
+	require.True(t, IsTransientError(errors.New("toomanyrequests: You have reached your pull rate limit")))
+	require.True(t, IsTransientError(fmt.Errorf("%w: received unexpected HTTP status: 503 Service Unavailable", ErrReadingPulledImage)))
+	require.True(t, IsTransientError(errdefs.Unavailable(errors.New("registry down"))))
+	require.True(t, IsTransientError(context.DeadlineExceeded))
+}
+
+func TestRetryPolicyRetry(t *testing.T) {
+	p := RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond}
+
+	// transient errors are retried until we succeed
+	calls := 0
+	err := p.retry(func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	// we give up after the configured number of attempts
+	calls = 0
+	err = p.retry(func() error {
+		calls++
+		return errors.New("503 Service Unavailable")
+	})
+	require.Error(t, err)
+	require.Equal(t, 3, calls)
+
+	// permanent errors are not retried
+	calls = 0
+	err = p.retry(func() error {
+		calls++
+		return errors.New("manifest unknown")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+
+	// custom classification
+	calls = 0
+	p.RetryOn = func(error) bool { return true }
+	err = p.retry(func() error {
+		calls++
+		return errors.New("manifest unknown")
+	})
+	require.Error(t, err)
+	require.Equal(t, 3, calls)
+}
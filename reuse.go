@@ -0,0 +1,160 @@
+package udock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LabelSpecHash is set on reusable containers to the hash of the spec they
+// were created from.
+const LabelSpecHash = "udock.spec-hash"
+
+// runReused adopts a running container created from the same spec by an
+// earlier run if there is a healthy one, and creates and starts a new
+// reusable container otherwise.
+func (s *Session) runReused(ctx context.Context, spec ContainerSpec) (string, error) {
+	// Sidecars belong to the session, so a reused container would either
+	// lose them with the session that started them or get a second set.
+	if len(spec.Sidecars) > 0 {
+		return "", fmt.Errorf("%w: reusable containers cannot have sidecars", ErrInvalidOption)
+	}
+	built, err := newContainerSpec(spec.Image, spec.Ports, append(s.defaultContainerOptions(), spec.Options...)...)
+	if err != nil {
+		return "", err
+	}
+	// Every session network has a name of its own, so a container on one
+	// could never be reused by another session, and would keep Close from
+	// removing the network.
+	if s.networkName != "" && built.primaryNetwork() == s.networkName {
+		return "", fmt.Errorf("%w: reusable containers cannot be on the session network, use WithNetworkMode", ErrInvalidOption)
+	}
+	hash, err := built.hash()
+	if err != nil {
+		return "", err
+	}
+
+	containerID, err := s.findReusable(hash)
+	if err != nil {
+		return "", err
+	}
+	if containerID != "" {
+		slog.Info("reusing container", "id", containerID, "image", spec.Image)
+		return containerID, nil
+	}
+
+	// Reusable containers must outlive the session, so they are not
+	// labeled with it or its process and are not removed when they stop.
+	opts := append(slices.Clip(spec.Options), WithAutoRemove(false), func(cs *containerSpec) error {
+		delete(cs.config.Labels, LabelSession)
+		delete(cs.config.Labels, LabelProcess)
+		cs.config.Labels[LabelSpecHash] = hash
+		return nil
+	})
+
+	return s.run(ctx, spec, opts...)
+}
+
+// findReusable returns the ID of a running and healthy container labeled
+// with hash, or the empty string if there is none.
+func (s *Session) findReusable(hash string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer cancel()
+
+	containers, err := s.client.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", LabelSpecHash+"="+hash),
+			filters.Arg("status", "running"),
+		),
+	})
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, hash), err)
+	}
+
+	for _, c := range containers {
+		info, err := s.client.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			continue
+		}
+		if info.State.Health == nil || info.State.Health.Status == types.Healthy {
+			return c.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// hash returns a hash of everything that goes into creating the container,
+// except the session it belongs to and the process of the session.  Free
+// host ports are picked anew every time the container is created, see
+// WithFreePorts, so they hash as the marker they replace.
+func (spec *containerSpec) hash() (string, error) {
+	hostConfig := *spec.hostConfig
+	hostConfig.PortBindings = nat.PortMap{}
+	for port, bindings := range spec.hostConfig.PortBindings {
+		for _, binding := range bindings {
+			if spec.freePorts[port] {
+				binding.HostPort = freePort
+			}
+			hostConfig.PortBindings[port] = append(hostConfig.PortBindings[port], binding)
+		}
+	}
+
+	config := *spec.config
+	config.Labels = map[string]string{}
+	for k, v := range spec.config.Labels {
+		if k != LabelSession && k != LabelProcess {
+			config.Labels[k] = v
+		}
+	}
+
+	type file struct {
+		Path   string
+		Mode   uint32
+		UID    int
+		GID    int
+		SHA256 []byte
+	}
+	var files []file
+	for _, f := range spec.files {
+		sum := sha256.Sum256(f.contents)
+		files = append(files, file{
+			Path:   f.path,
+			Mode:   uint32(f.mode),
+			UID:    f.uid,
+			GID:    f.gid,
+			SHA256: sum[:],
+		})
+	}
+
+	data, err := json.Marshal(struct {
+		Config        *container.Config
+		HostConfig    *container.HostConfig
+		NetworkConfig *network.NetworkingConfig
+		Platform      *ocispec.Platform
+		Files         []file
+	}{
+		Config:        &config,
+		HostConfig:    &hostConfig,
+		NetworkConfig: spec.networkConfig,
+		Platform:      spec.platform,
+		Files:         files,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
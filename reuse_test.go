@@ -0,0 +1,46 @@
+package udock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerSpecHash(t *testing.T) {
+	build := func(s *Session, opts ...ContainerOption) string {
+		spec, err := newContainerSpec("postgres:16", map[string]string{"5432": "5432"}, append(s.defaultContainerOptions(), opts...)...)
+		require.NoError(t, err)
+		hash, err := spec.hash()
+		require.NoError(t, err)
+		return hash
+	}
+
+	first := &Session{id: "first"}
+	second := &Session{id: "second"}
+
+	// the session does not matter
+	require.Equal(t, build(first, WithEnvVar("POSTGRES_PASSWORD", "secret")), build(second, WithEnvVar("POSTGRES_PASSWORD", "secret")))
+
+	// everything else does
+	require.NotEqual(t, build(first), build(first, WithEnvVar("POSTGRES_PASSWORD", "secret")))
+	require.NotEqual(t, build(first, WithFile("/init.sql", []byte("a"), 0o644)), build(first, WithFile("/init.sql", []byte("b"), 0o644)))
+}
+
+func TestReuseOnSessionNetwork(t *testing.T) {
+	s := &Session{id: "session", networkName: "udock-session"}
+
+	_, err := s.runReused(context.Background(), ContainerSpec{Image: "postgres:16", Reuse: true})
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestReuseWithSidecars(t *testing.T) {
+	s := &Session{id: "session"}
+
+	_, err := s.runReused(context.Background(), ContainerSpec{
+		Image:    "postgres:16",
+		Reuse:    true,
+		Sidecars: []ContainerSpec{{Image: "prom/postgres-exporter"}},
+	})
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,257 @@
+package udock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ContainerSpec describes a container for Run.
+type ContainerSpec struct {
+	// Image the container runs.
+	Image string
+
+	// Name of the container.  If empty docker generates a name.
+	Name string
+
+	// Ports maps host ports to container ports, see CreateContainer.
+	Ports map[string]string
+
+	// Options for the container.
+	Options []ContainerOption
+
+	// Vars turns on ExpandVars for the image and the options, see
+	// WithVars, with Vars taking precedence over the environment.  Use
+	// an empty map to expand variables from the environment only.  $ has
+	// to be written as $$ then, e.g. in shell commands.  InitContainers
+	// and Sidecars inherit Vars, with their own Vars taking precedence.
+	Vars map[string]string
+
+	// WaitFor decides when the container is ready.  If nil the container is
+	// considered ready as soon as it is running.
+	WaitFor WaitStrategy
+
+	// WaitTimeout is how long we give the container to become ready and
+	// run the init steps.  The default is one minute.
+	WaitTimeout time.Duration
+
+	// Init steps are run in order once the container is ready.
+	Init []InitStep
+
+	// InitContainers are run in order before the container is created,
+	// e.g. to run migrations or fix the permissions of a volume, and each
+	// must exit with code 0 before the next one, and in the end the
+	// container, is started.  They are removed once they have exited.
+	// Their WaitTimeout is how long they get to exit, and the rest of what
+	// has to do with readiness and dependencies is ignored for them.
+	InitContainers []ContainerSpec
+
+	// Sidecars are started once the container has started, before it is
+	// waited for, and share its network namespace, e.g. a metrics scraper
+	// reaching it on localhost or a tcpdump watching its traffic.  They
+	// are removed when the container is removed with RemoveContainer.  A
+	// container that docker removes when it exits, see WithAutoRemove,
+	// leaves its sidecars running without a network until they are removed
+	// with RemoveContainer or the session is cleaned up, see WithReaper and
+	// CleanupOrphans.  Sidecars cannot publish ports of their own, see
+	// WithNetworkOf, and reusable containers cannot have them.
+	Sidecars []ContainerSpec
+
+	// DependsOn names the specs that must be running and ready before this
+	// one is started by RunAll.
+	DependsOn []string
+
+	// Reuse makes Run adopt a running container created from the same spec
+	// by an earlier run, e.g. of the tests on your machine, rather than
+	// create a new one.  Reusable containers are left running when the
+	// session is closed so the next run can reuse them, and should not be
+	// removed by the tests.  This makes the edit-test loop much faster for
+	// containers that are slow to start, but means state carries over from
+	// one run to the next.  Reusable containers cannot be on the session
+	// network, see WithSessionNetwork, since every session network is new,
+	// and cannot have sidecars.
+	Reuse bool
+}
+
+// Run creates and starts a container as described by spec, waits for it to
+// become ready and runs its init steps, and returns its ID.  If any of this
+// fails the container is removed.
+func (s *Session) Run(spec ContainerSpec) (string, error) {
+	return s.runContext(context.Background(), spec)
+}
+
+// runContext does the work of Run, giving up once ctx is done.
+func (s *Session) runContext(ctx context.Context, spec ContainerSpec) (string, error) {
+	spec, err := spec.expandVars()
+	if err != nil {
+		return "", err
+	}
+	if spec.Reuse {
+		return s.runReused(ctx, spec)
+	}
+	return s.run(ctx, spec, spec.Options...)
+}
+
+// run creates and starts the container with opts rather than the options of
+// the spec, and waits for it to become ready.
+func (s *Session) run(ctx context.Context, spec ContainerSpec, opts ...ContainerOption) (string, error) {
+	for _, initContainer := range spec.InitContainers {
+		err := s.runInitContainer(ctx, initContainer)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	containerID, err := s.createAndStartContainer(ctx, spec.Image, spec.Name, spec.Ports, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.startSidecars(ctx, containerID, spec.Sidecars)
+	if err != nil {
+		return "", errors.Join(err, s.RemoveContainer(containerID))
+	}
+
+	err = s.waitAndInit(ctx, spec, containerID)
+	if err != nil {
+		return "", errors.Join(err, s.RemoveContainer(containerID))
+	}
+	return containerID, nil
+}
+
+// waitAndInit waits for the container to become ready and runs the init
+// steps of the spec, giving up once ctx is done.
+func (s *Session) waitAndInit(ctx context.Context, spec ContainerSpec, containerID string) error {
+	if spec.WaitFor == nil && len(spec.Init) == 0 {
+		return nil
+	}
+
+	timeout := spec.WaitTimeout
+	if timeout == 0 {
+		timeout = defaultWaitTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if spec.WaitFor != nil {
+		err := s.waitUntilReady(ctx, containerID, spec.WaitFor)
+		if err != nil {
+			return err
+		}
+	}
+	return s.runInitSteps(ctx, containerID, spec.Init)
+}
+
+// runInitContainer runs an init container to completion and removes it, and
+// fails unless it exits with code 0.
+func (s *Session) runInitContainer(ctx context.Context, spec ContainerSpec) error {
+	spec, err := spec.expandVars()
+	if err != nil {
+		return err
+	}
+
+	containerID, err := s.CreateContainer(spec.Image, spec.Name, spec.Ports, append(slices.Clip(spec.Options), WithAutoRemove(false))...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.RemoveContainer(containerID)
+	}()
+
+	timeout := spec.WaitTimeout
+	if timeout == 0 {
+		timeout = defaultWaitTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := s.startToCompletion(ctx, containerID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrInitContainer, spec.Image), err)
+	}
+	if result.exitCode != 0 {
+		output := bytes.TrimSpace(result.stderr)
+		if len(output) == 0 {
+			output = bytes.TrimSpace(result.stdout)
+		}
+		return fmt.Errorf("%w: %s exited with code %d: %s", ErrInitContainer, spec.Image, result.exitCode, output)
+	}
+	return nil
+}
+
+// runResult is the outcome of running a container to completion.
+type runResult struct {
+	exitCode int64
+	stdout   []byte
+	stderr   []byte
+
+	// duration is the time from starting the container until it exited.
+	duration time.Duration
+}
+
+// runToCompletion creates a container running cmd in dockerImage, starts it,
+// waits for it to exit and removes it.  The container is removed even if
+// something goes wrong.
+func (s *Session) runToCompletion(dockerImage string, cmd []string, timeout time.Duration, opts ...ContainerOption) (runResult, error) {
+	opts = append(opts, WithAutoRemove(false), WithCmd(cmd...))
+
+	containerID, err := s.CreateContainer(dockerImage, "", nil, opts...)
+	if err != nil {
+		return runResult{}, err
+	}
+	defer func() {
+		_ = s.RemoveContainer(containerID)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.startToCompletion(ctx, containerID)
+}
+
+// startToCompletion starts a container that has been created, waits for it
+// to exit and collects its output.
+func (s *Session) startToCompletion(ctx context.Context, containerID string) (runResult, error) {
+	start := time.Now()
+	err := s.client.ContainerStart(ctx, containerID, container.StartOptions{})
+	if err != nil {
+		return runResult{}, errors.Join(fmt.Errorf("%w: %s", ErrStartingContainer, containerID), err)
+	}
+
+	var (
+		exitCode int64
+		duration time.Duration
+	)
+	statusCh, errCh := s.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case status := <-statusCh:
+		if status.Error != nil {
+			return runResult{}, fmt.Errorf("%w: %s: %s", ErrWaitingForContainer, containerID, status.Error.Message)
+		}
+		exitCode = status.StatusCode
+		duration = time.Since(start)
+
+	case err := <-errCh:
+		if errors.Is(err, context.DeadlineExceeded) {
+			return runResult{}, fmt.Errorf("%w: waiting for %s to exit", ErrTimeout, containerID)
+		}
+		return runResult{}, errors.Join(fmt.Errorf("%w: %s", ErrWaitingForContainer, containerID), err)
+	}
+
+	stdout, stderr, err := s.containerLogs(ctx, containerID)
+	if err != nil {
+		return runResult{}, err
+	}
+
+	return runResult{
+		exitCode: exitCode,
+		stdout:   stdout,
+		stderr:   stderr,
+		duration: duration,
+	}, nil
+}
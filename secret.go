@@ -0,0 +1,97 @@
+package udock
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// secretFileMode is the mode of the files secrets are written to.
+	secretFileMode = 0o400
+
+	// LabelSecrets is set on containers given secrets with WithSecret.
+	// RunGolden and SnapshotContainer refuse to commit them to images.
+	LabelSecrets = "udock.secrets"
+)
+
+// Secret is a sensitive value that WithSecret gives to a container.  At least
+// one of EnvVar and File must be set.
+type Secret struct {
+	// Value of the secret.
+	Value []byte
+
+	// EnvVar is the name of the environment variable the secret is put
+	// in.  Environment variables can be seen by anyone who can run docker
+	// inspect, so prefer File if the application supports it.
+	EnvVar string
+
+	// File is the absolute path of the file in the container the secret is
+	// written to.  The file has mode 0400 and is owned by the user given with
+	// WithUser if it is numeric, and by root otherwise.
+	File string
+}
+
+// WithSecret gives a secret to the container as an environment variable, a
+// file or both.  Files are copied into the container when it is created, so
+// the secret is never written to disk on the host or baked into an image;
+// for the same reason RunGolden and SnapshotContainer reject containers with
+// secrets.  Secrets in files cannot be given to containers with WithReadOnlyRootFS;
+// use an environment variable for those.
+func WithSecret(secret Secret) ContainerOption {
+	return func(spec *containerSpec) error {
+		if secret.EnvVar == "" && secret.File == "" {
+			return fmt.Errorf("%w: secret needs an environment variable or a file", ErrInvalidOption)
+		}
+
+		// Secret values are set as they are, since passwords and tokens
+		// may well contain $, see WithVars.
+		if secret.EnvVar != "" {
+			if strings.Contains(secret.EnvVar, "=") {
+				return fmt.Errorf("%w: invalid environment variable name %q", ErrInvalidOption, secret.EnvVar)
+			}
+			spec.config.Env = setEnv(spec.config.Env, secret.EnvVar, string(secret.Value))
+		}
+
+		if secret.File != "" {
+			if !path.IsAbs(secret.File) || strings.HasSuffix(secret.File, "/") {
+				return fmt.Errorf("%w: secret path %q must be an absolute file path", ErrInvalidOption, secret.File)
+			}
+			spec.files = append(spec.files, containerFile{
+				path:        path.Clean(secret.File),
+				contents:    secret.Value,
+				mode:        secretFileMode,
+				ownedByUser: true,
+			})
+		}
+
+		if spec.config.Labels == nil {
+			spec.config.Labels = map[string]string{}
+		}
+		spec.config.Labels[LabelSecrets] = "true"
+		return nil
+	}
+}
+
+// numericUser parses a user of the form "uid" or "uid:gid".  If the user is
+// given by name we cannot know the IDs without looking inside the image, so
+// ok is false.
+func numericUser(user string) (uid int, gid int, ok bool) {
+	if user == "" {
+		return 0, 0, false
+	}
+
+	u, g, hasGroup := strings.Cut(user, ":")
+	uid, err := strconv.Atoi(u)
+	if err != nil {
+		return 0, 0, false
+	}
+	if hasGroup {
+		gid, err = strconv.Atoi(g)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	return uid, gid, true
+}
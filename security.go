@@ -0,0 +1,95 @@
+package udock
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// WithPrivileged runs the container in privileged mode, giving it all
+// capabilities and access to all host devices.  This is needed for things
+// like docker-in-docker, but should be avoided when something narrower such
+// as WithCapAdd or WithDevice does the job.
+func WithPrivileged() ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.Privileged = true
+		return nil
+	}
+}
+
+// WithCapAdd adds Linux capabilities to the container, e.g. "NET_ADMIN" for
+// containers that need to change their network configuration.
+func WithCapAdd(caps ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.CapAdd = append(spec.hostConfig.CapAdd, caps...)
+		return nil
+	}
+}
+
+// WithCapDrop drops Linux capabilities from the container.  Use "ALL" to drop
+// every capability, possibly combined with WithCapAdd to add back the few
+// that are needed.
+func WithCapDrop(caps ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.CapDrop = append(spec.hostConfig.CapDrop, caps...)
+		return nil
+	}
+}
+
+// WithDevice makes the host device hostPath, e.g. /dev/kvm or /dev/ttyUSB0,
+// available as containerPath in the container.  If containerPath is empty
+// the device has the same path as on the host.  perms is any combination of
+// r (read), w (write) and m (mknod), and defaults to "rwm" if empty.
+func WithDevice(hostPath string, containerPath string, perms string) ContainerOption {
+	return func(spec *containerSpec) error {
+		if !path.IsAbs(hostPath) {
+			return fmt.Errorf("%w: device path %q must be absolute", ErrInvalidOption, hostPath)
+		}
+		if containerPath == "" {
+			containerPath = hostPath
+		}
+		if perms == "" {
+			perms = "rwm"
+		}
+		if strings.Trim(perms, "rwm") != "" {
+			return fmt.Errorf("%w: invalid device permissions %q", ErrInvalidOption, perms)
+		}
+
+		spec.hostConfig.Devices = append(spec.hostConfig.Devices, container.DeviceMapping{
+			PathOnHost:        hostPath,
+			PathInContainer:   containerPath,
+			CgroupPermissions: perms,
+		})
+		return nil
+	}
+}
+
+// WithReadOnlyRootFS makes the root filesystem of the container read-only,
+// like it would be in a hardened deployment.  A tmpfs is mounted at each of
+// writablePaths, e.g. "/tmp" or "/var/run", for the paths the application
+// still needs to write to.  It cannot be combined with WithFile or with
+// secrets in files, see WithSecret.
+func WithReadOnlyRootFS(writablePaths ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.ReadonlyRootfs = true
+		for _, p := range writablePaths {
+			err := WithTmpfs(p, "")(spec)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithRuntime runs the container under the OCI runtime registered with the
+// docker daemon as runtime, e.g. "runsc" for gVisor or "kata-runtime" for
+// Kata Containers.
+func WithRuntime(runtime string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.Runtime = runtime
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivileged(t *testing.T) {
+	spec, err := newContainerSpec("docker:dind", nil)
+	require.NoError(t, err)
+	require.False(t, spec.hostConfig.Privileged)
+
+	spec, err = newContainerSpec("docker:dind", nil, WithPrivileged())
+	require.NoError(t, err)
+	require.True(t, spec.hostConfig.Privileged)
+}
+
+func TestCapabilities(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil, WithCapDrop("ALL"), WithCapAdd("NET_ADMIN"), WithCapAdd("NET_RAW"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"NET_ADMIN", "NET_RAW"}, []string(spec.hostConfig.CapAdd))
+	require.Equal(t, []string{"ALL"}, []string(spec.hostConfig.CapDrop))
+}
+
+func TestDevice(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil,
+		WithDevice("/dev/kvm", "", ""),
+		WithDevice("/dev/ttyUSB0", "/dev/ttyS0", "rw"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []container.DeviceMapping{
+		{PathOnHost: "/dev/kvm", PathInContainer: "/dev/kvm", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/ttyS0", CgroupPermissions: "rw"},
+	}, spec.hostConfig.Devices)
+
+	_, err = newContainerSpec("alpine", nil, WithDevice("dev/kvm", "", ""))
+	require.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = newContainerSpec("alpine", nil, WithDevice("/dev/kvm", "", "rx"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestReadOnlyRootFS(t *testing.T) {
+	spec, err := newContainerSpec("nginx", nil, WithReadOnlyRootFS("/tmp", "/var/cache/nginx"))
+	require.NoError(t, err)
+	require.True(t, spec.hostConfig.ReadonlyRootfs)
+	require.Equal(t, map[string]string{"/tmp": "", "/var/cache/nginx": ""}, spec.hostConfig.Tmpfs)
+
+	_, err = newContainerSpec("nginx", nil, WithReadOnlyRootFS("tmp"))
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestRuntime(t *testing.T) {
+	spec, err := newContainerSpec("alpine", nil, WithRuntime("runsc"))
+	require.NoError(t, err)
+	require.Equal(t, "runsc", spec.hostConfig.Runtime)
+}
@@ -0,0 +1,189 @@
+package udock
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// defaultVolumeHelperImage is the image of the scratch containers we use
+	// for getting data into and out of volumes.
+	defaultVolumeHelperImage = "busybox:latest"
+
+	// volumeHelperMountPoint is where the volume is mounted in the scratch
+	// container.
+	volumeHelperMountPoint = "/volume"
+)
+
+// SeedVolumeFromDir creates a volume and copies the contents of the local
+// directory dir into it, so the volume can be mounted by a container as a
+// pre-loaded data directory.  It returns the name of the volume.
+func (s *Session) SeedVolumeFromDir(name string, dir string, opts ...VolumeOption) (string, error) {
+	return s.SeedVolumeFromFS(name, os.DirFS(dir), opts...)
+}
+
+// SeedVolumeFromFS creates a volume and copies the contents of fsys into it.
+// This works with embed.FS, which makes it easy to ship fixtures with the
+// tests.  It returns the name of the volume.
+func (s *Session) SeedVolumeFromFS(name string, fsys fs.FS, opts ...VolumeOption) (string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTarFS(pw, fsys))
+	}()
+	defer pr.Close()
+
+	return s.SeedVolumeFromTar(name, pr, opts...)
+}
+
+// SeedVolumeFromTar creates a volume and extracts the tar stream r into it.
+// It returns the name of the volume.  If seeding fails the volume is
+// removed.
+func (s *Session) SeedVolumeFromTar(name string, r io.Reader, opts ...VolumeOption) (string, error) {
+	volumeName, err := s.CreateVolume(name, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.copyToVolume(volumeName, r)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrSeedingVolume, volumeName), err, s.RemoveVolume(volumeName))
+	}
+	return volumeName, nil
+}
+
+// BackupVolume writes the contents of the volume to w as a tar archive, for
+// instance to capture the state of a database after the fixtures have been
+// loaded.  The volume should not be written to while it is being backed up.
+func (s *Session) BackupVolume(name string, w io.Writer) error {
+	containerID, err := s.createVolumeHelper(name)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrBackingUpVolume, name), err)
+	}
+	defer func() {
+		_ = s.RemoveContainer(containerID)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCopyTimeout)
+	defer cancel()
+
+	// The trailing "/." gives us the contents of the mount point rather
+	// than the mount point itself.
+	rc, _, err := s.client.CopyFromContainer(ctx, containerID, volumeHelperMountPoint+"/.")
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrBackingUpVolume, name), err)
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrBackingUpVolume, name), err)
+	}
+	return nil
+}
+
+// RestoreVolume extracts a tar archive made by BackupVolume into the volume.
+// Files in the volume that are not in the archive are left alone, so restore
+// into a new or empty volume to get back exactly what was backed up.  If no
+// volume by that name exists docker creates it.
+func (s *Session) RestoreVolume(name string, r io.Reader) error {
+	err := s.copyToVolume(name, r)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrRestoringVolume, name), err)
+	}
+	return nil
+}
+
+// copyToVolume extracts the tar stream r into the volume by way of a scratch
+// container that has the volume mounted.  The container is never started,
+// docker lets us copy into created containers.
+func (s *Session) copyToVolume(volumeName string, r io.Reader) error {
+	containerID, err := s.createVolumeHelper(volumeName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.RemoveContainer(containerID)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCopyTimeout)
+	defer cancel()
+
+	return s.client.CopyToContainer(ctx, containerID, volumeHelperMountPoint, r, container.CopyToContainerOptions{})
+}
+
+// createVolumeHelper creates, but does not start, a scratch container with
+// the volume mounted at volumeHelperMountPoint.
+func (s *Session) createVolumeHelper(volumeName string) (string, error) {
+	err := s.PullImage(defaultVolumeHelperImage)
+	if err != nil {
+		return "", err
+	}
+
+	return s.CreateContainer(defaultVolumeHelperImage, "", nil,
+		WithAutoRemove(false),
+		WithVolumeMount(volumeName, volumeHelperMountPoint),
+		WithCmd("true"),
+	)
+}
+
+// writeTarFS writes a tar archive of fsys to w.  Symlinks are followed since
+// fs.FS has no way of reading them.
+func writeTarFS(w io.Writer, fsys fs.FS) error {
+	tw := tar.NewWriter(w)
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
@@ -0,0 +1,20 @@
+package udock
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTarFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"init.sql":           {Data: []byte("create table foo (id int);")},
+		"conf/postgres.conf": {Data: []byte("fsync = off")},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeTarFS(&buf, fsys))
+	require.Equal(t, []string{"conf/", "conf/postgres.conf", "init.sql"}, tarEntryNames(t, &buf))
+}
@@ -0,0 +1,41 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// startSidecars runs the sidecars in the network namespace of the container
+// containerID and records them so they are removed along with it.
+func (s *Session) startSidecars(ctx context.Context, containerID string, sidecars []ContainerSpec) error {
+	for _, sidecar := range sidecars {
+		sidecar.Options = append(slices.Clip(sidecar.Options), WithNetworkOf(containerID))
+		sidecar.Reuse = false
+
+		sidecarID, err := s.runContext(ctx, sidecar)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.sidecars[containerID] = append(s.sidecars[containerID], sidecarID)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// removeSidecars removes the sidecars of the container containerID, if it has
+// any.
+func (s *Session) removeSidecars(containerID string) error {
+	s.mu.Lock()
+	sidecars := s.sidecars[containerID]
+	delete(s.sidecars, containerID)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, sidecarID := range sidecars {
+		errs = append(errs, s.RemoveContainer(sidecarID))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,141 @@
+package udock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	// snapshotRepository is the repository snapshot images are tagged in.
+	snapshotRepository = "udock-snapshot"
+
+	// LabelSnapshot marks the images made by SnapshotContainer.
+	LabelSnapshot = "udock.snapshot"
+)
+
+// Snapshot is the captured state of a container, made with
+// SnapshotContainer, that any number of new containers can be created from
+// with RestoreSnapshot.  This lets a database be set up and loaded with
+// fixtures once, and each test get a fresh copy of it in a fraction of the
+// time.
+type Snapshot struct {
+	// Image is the image the container was committed to.  It has the
+	// filesystem and configuration (cmd, env, exposed ports etc) of the
+	// container.
+	Image string
+
+	// Volumes maps the paths the container had volumes mounted at to tar
+	// archives of the volumes.  Volume contents are not part of committed
+	// images, which is where databases usually keep their data.
+	Volumes map[string][]byte
+}
+
+// SnapshotContainer captures the state of a container, that is its filesystem
+// and the contents of the volumes mounted in it.  The container is paused
+// while the snapshot is taken so we see a consistent state, which makes the
+// snapshot about as good as an abrupt power loss from the point of view of a
+// database.  Stop the container first if that is not good enough.  Volume
+// contents are held in memory.  Containers given secrets with WithSecret
+// cannot be snapshotted, since the secrets would end up in the image.  Remove
+// the snapshot with RemoveSnapshot when it is no longer needed;
+// CleanupOrphans removes snapshots that are not.
+func (s *Session) SnapshotContainer(containerID string) (*Snapshot, error) {
+	inspectCtx, inspectCancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer inspectCancel()
+
+	info, err := s.client.ContainerInspect(inspectCtx, containerID)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+	}
+	if info.Config != nil && info.Config.Labels[LabelSecrets] != "" {
+		return nil, fmt.Errorf("%w: %s has secrets", ErrCreatingSnapshot, containerID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCommitTimeout)
+	defer cancel()
+
+	running := info.State != nil && info.State.Running && !info.State.Paused
+	if running {
+		err = s.client.ContainerPause(ctx, containerID)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("%w: %s", ErrCreatingSnapshot, containerID), err)
+		}
+		defer func() {
+			unpauseCtx, unpauseCancel := context.WithTimeout(context.Background(), dockerPauseTimeout)
+			defer unpauseCancel()
+			_ = s.client.ContainerUnpause(unpauseCtx, containerID)
+		}()
+	}
+
+	labels := s.labels()
+	labels[LabelSnapshot] = "true"
+	commit, err := s.client.ContainerCommit(ctx, containerID, container.CommitOptions{
+		Reference: snapshotRepository + ":" + randomID(),
+		Config:    &container.Config{Labels: labels},
+		Pause:     false,
+	})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrCreatingSnapshot, containerID), err)
+	}
+
+	// The image was made from a container we were allowed to run, so
+	// there is no point in having the verifier look at it.
+	s.mu.Lock()
+	s.verified[commit.ID] = true
+	s.mu.Unlock()
+
+	snapshot := &Snapshot{
+		Image:   commit.ID,
+		Volumes: map[string][]byte{},
+	}
+	for _, m := range info.Mounts {
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+
+		var buf bytes.Buffer
+		err := s.BackupVolume(m.Name, &buf)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("%w: %s", ErrCreatingSnapshot, containerID), err, s.RemoveSnapshot(snapshot))
+		}
+		snapshot.Volumes[m.Destination] = buf.Bytes()
+	}
+
+	return snapshot, nil
+}
+
+// RestoreSnapshot creates a container from the snapshot, with a fresh copy of
+// each of the volumes mounted where they were in the original container.
+// The arguments are as for CreateContainer, and like CreateContainer the
+// container has to be started with StartContainer.
+func (s *Session) RestoreSnapshot(snapshot *Snapshot, containerName string, ports map[string]string, opts ...ContainerOption) (string, error) {
+	var volumeOpts []ContainerOption
+	for containerPath, archive := range snapshot.Volumes {
+		name, err := s.SeedVolumeFromTar("", bytes.NewReader(archive))
+		if err != nil {
+			return "", errors.Join(ErrRestoringSnapshot, err)
+		}
+		volumeOpts = append(volumeOpts, WithVolumeMount(name, containerPath))
+	}
+
+	containerID, err := s.CreateContainer(snapshot.Image, containerName, ports, append(volumeOpts, opts...)...)
+	if err != nil {
+		return "", errors.Join(ErrRestoringSnapshot, err)
+	}
+	return containerID, nil
+}
+
+// RemoveSnapshot removes the image of the snapshot.  It cannot be removed
+// while there are containers created from it.
+func (s *Session) RemoveSnapshot(snapshot *Snapshot) error {
+	err := s.RemoveImage(snapshot.Image)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrRemovingImage, snapshot.Image), err)
+	}
+	return nil
+}
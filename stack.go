@@ -0,0 +1,431 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+)
+
+// StackSpec describes a group of containers, and the networks and volumes
+// they use, that are brought up and down together by a Stack.
+type StackSpec struct {
+	// Name of the stack.  It is used as the prefix of the names of the
+	// networks and volumes the stack creates.
+	Name string
+
+	// Networks the stack creates, by the names the containers refer to
+	// them by.
+	Networks []string
+
+	// Volumes the stack creates, by the names the containers refer to them
+	// by.
+	Volumes []string
+
+	// Containers of the stack.  They are run as by RunAll, so every
+	// container must have a name that is unique among the containers, and
+	// that is also the name it is looked up by.  Docker knows the containers
+	// by unique names made from the name of the stack and their own, so
+	// several stacks from the same spec can be up at the same time.
+	Containers []StackContainer
+}
+
+// StackContainer is a container of a stack.
+type StackContainer struct {
+	ContainerSpec
+
+	// Networks names the networks of the stack the container is attached
+	// to.  Other containers on the same network reach it by its name in the
+	// spec, which is an alias for it on each of them.
+	// Attaching a container to more than one network when it is created
+	// needs docker 25 or later.
+	Networks []string
+
+	// Mounts maps the names of volumes of the stack to the paths they are
+	// mounted at in the container.
+	Mounts map[string]string
+}
+
+// Stack is a group of containers, networks and volumes that are brought up
+// and down together.
+type Stack struct {
+	session *Session
+	spec    StackSpec
+
+	mu sync.Mutex
+	// networks, volumes and containers map the names of the spec to the
+	// names or IDs docker knows them by while they exist.
+	networks   map[string]string
+	volumes    map[string]string
+	containers map[string]string
+}
+
+// NewStack returns a stack for the spec.  Nothing is created until Up is
+// called.
+func (s *Session) NewStack(spec StackSpec) *Stack {
+	return &Stack{
+		session:    s,
+		spec:       spec,
+		networks:   map[string]string{},
+		volumes:    map[string]string{},
+		containers: map[string]string{},
+	}
+}
+
+// Up creates the networks and volumes of the stack and runs its containers.
+// Either all of it comes up or none of it does: if anything fails, or ctx is
+// done, before the stack is up, whatever was created is removed again.
+func (st *Stack) Up(ctx context.Context) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if len(st.networks)+len(st.volumes)+len(st.containers) > 0 {
+		return fmt.Errorf("%w: %s is already up", ErrInvalidStack, st.spec.Name)
+	}
+	err := st.spec.validate()
+	if err != nil {
+		return err
+	}
+
+	err = st.up(ctx)
+	if err != nil {
+		return errors.Join(err, st.down(context.Background()))
+	}
+	return nil
+}
+
+// up does the work of Up, leaving it to the caller to clean up.
+func (st *Stack) up(ctx context.Context) error {
+	for _, name := range st.spec.Networks {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		networkName := UniqueName(st.spec.Name+"-"+name, "")
+		_, err = st.session.CreateNetwork(networkName)
+		if err != nil {
+			return err
+		}
+		st.networks[name] = networkName
+	}
+
+	for _, name := range st.spec.Volumes {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		volumeName, err := st.session.CreateVolume(UniqueName(st.spec.Name+"-"+name, ""))
+		if err != nil {
+			return err
+		}
+		st.volumes[name] = volumeName
+	}
+
+	specs, names := st.containerSpecs()
+	containers, err := st.session.runAll(ctx, specs)
+	if err != nil {
+		return err
+	}
+	for containerName, containerID := range containers {
+		st.containers[names[containerName]] = containerID
+	}
+	return nil
+}
+
+// containerSpecs returns the specs of the containers of the stack, attached
+// to its networks and with its volumes mounted, under unique names, and the
+// names of the spec by the unique names.
+func (st *Stack) containerSpecs() ([]ContainerSpec, map[string]string) {
+	unique := map[string]string{}
+	names := map[string]string{}
+	for _, c := range st.spec.Containers {
+		containerName := UniqueName(st.spec.Name+"-"+c.Name, "")
+		unique[c.Name] = containerName
+		names[containerName] = c.Name
+	}
+
+	specs := make([]ContainerSpec, 0, len(st.spec.Containers))
+	for _, c := range st.spec.Containers {
+		spec := c.ContainerSpec
+		spec.Name = unique[c.Name]
+		spec.DependsOn = nil
+		for _, dep := range c.DependsOn {
+			spec.DependsOn = append(spec.DependsOn, unique[dep])
+		}
+		spec.Options = slices.Clip(spec.Options)
+		if len(c.Networks) > 0 {
+			var networks []string
+			for _, name := range c.Networks {
+				networks = append(networks, st.networks[name])
+			}
+			spec.Options = append(spec.Options, withNetworks(networks, c.Name))
+		}
+		for _, name := range sortedKeys(c.Mounts) {
+			spec.Options = append(spec.Options, WithVolumeMount(st.volumes[name], c.Mounts[name]))
+		}
+		specs = append(specs, spec)
+	}
+	return specs, names
+}
+
+// Down removes the containers, networks and volumes of the stack.  Whatever
+// cannot be removed is kept track of, so Down can be called again.
+// Containers that are already gone, e.g. because they exited and docker
+// removed them, are not an error.  Down stops once ctx is done.
+func (st *Stack) Down(ctx context.Context) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.down(ctx)
+}
+
+// down does the work of Down.  The containers go first since networks and
+// volumes cannot be removed while they are in use.
+func (st *Stack) down(ctx context.Context) error {
+	var errs []error
+	remove := func(resources map[string]string, removeFunc func(string) error) {
+		for _, name := range sortedKeys(resources) {
+			if ctx.Err() != nil {
+				return
+			}
+			err := removeFunc(resources[name])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delete(resources, name)
+		}
+	}
+
+	remove(st.containers, func(containerID string) error {
+		err := st.session.RemoveContainer(containerID)
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	remove(st.networks, st.session.RemoveNetwork)
+	remove(st.volumes, st.session.RemoveVolume)
+
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+	return errors.Join(errs...)
+}
+
+// ReadinessError is returned by WaitReady when containers of a stack did not
+// become ready.
+type ReadinessError struct {
+	// Stack is the name of the stack.
+	Stack string
+
+	// Failed maps the names of the containers that did not become ready to
+	// why they did not.
+	Failed map[string]error
+}
+
+// Error implements error.
+func (e *ReadinessError) Error() string {
+	var reasons []string
+	for _, name := range sortedKeys(e.Failed) {
+		reasons = append(reasons, fmt.Sprintf("%s: %v", name, e.Failed[name]))
+	}
+	return fmt.Sprintf("stack %s is not ready: %s", e.Stack, strings.Join(reasons, "; "))
+}
+
+// Unwrap returns ErrNotReady and why the containers did not become ready,
+// so errors.Is and errors.As see through a ReadinessError.
+func (e *ReadinessError) Unwrap() []error {
+	errs := []error{ErrNotReady}
+	for _, name := range sortedKeys(e.Failed) {
+		errs = append(errs, e.Failed[name])
+	}
+	return errs
+}
+
+// WaitReady waits until the wait strategy of every container of the stack
+// passes, or for containers without one, until they are running.  It stops
+// as soon as a container fails for good, e.g. because it exited, or when ctx
+// is done, and returns a *ReadinessError naming the containers that did not
+// become ready and why.  Containers that are still being waited for when
+// another one fails are not named.
+func (st *Stack) WaitReady(ctx context.Context) error {
+	st.mu.Lock()
+	containers := maps.Clone(st.containers)
+	st.mu.Unlock()
+	if len(containers) == 0 {
+		return fmt.Errorf("%w: %s is not up", ErrInvalidStack, st.spec.Name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		failed    = map[string]error{}
+		cancelled bool
+	)
+	for _, c := range st.spec.Containers {
+		strategy := c.WaitFor
+		if strategy == nil {
+			strategy = waitForRunning
+		}
+
+		wg.Add(1)
+		go func(name string, containerID string) {
+			defer wg.Done()
+
+			err := st.session.waitUntilReady(ctx, containerID, strategy)
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if cancelled {
+				return
+			}
+			failed[name] = err
+			if ctx.Err() == nil {
+				cancelled = true
+				cancel()
+			}
+		}(c.Name, containers[c.Name])
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return &ReadinessError{Stack: st.spec.Name, Failed: failed}
+	}
+	return nil
+}
+
+// waitForRunning is ready once the container is running.
+var waitForRunning = WaitFunc(func(ctx context.Context, s *Session, containerID string) error {
+	return s.poll(ctx, containerID, func() (bool, error) {
+		info, err := s.client.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return false, errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+		}
+		return info.State != nil && info.State.Running, nil
+	})
+})
+
+// Container returns the ID of the container with the name.
+func (st *Stack) Container(name string) (string, error) {
+	return st.lookup(st.containers, "container", name)
+}
+
+// Network returns the name docker knows the network with the name by, e.g.
+// for WithNetworkMode.
+func (st *Stack) Network(name string) (string, error) {
+	return st.lookup(st.networks, "network", name)
+}
+
+// Volume returns the name docker knows the volume with the name by, e.g. for
+// WithVolumeMount.
+func (st *Stack) Volume(name string) (string, error) {
+	return st.lookup(st.volumes, "volume", name)
+}
+
+// Endpoint returns the host:port address on the host that port of the
+// container with the name is published on, on the host address it is bound
+// to, see WithHostIP.
+func (st *Stack) Endpoint(name string, port string) (string, error) {
+	containerID, err := st.Container(name)
+	if err != nil {
+		return "", err
+	}
+	return st.session.MappedAddress(containerID, port)
+}
+
+// lookup returns what name maps to in resources while the stack is up.
+func (st *Stack) lookup(resources map[string]string, kind string, name string) (string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	value, ok := resources[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s: no %s %s", ErrNotInStack, st.spec.Name, kind, name)
+	}
+	return value, nil
+}
+
+// validate checks that everything the spec refers to is declared in it.
+// Dependencies between the containers are checked like RunAll does.
+func (spec StackSpec) validate() error {
+	if spec.Name == "" {
+		return fmt.Errorf("%w: stack has no name", ErrInvalidStack)
+	}
+
+	declared := func(kind string, names []string) (map[string]bool, error) {
+		set := map[string]bool{}
+		for _, name := range names {
+			if name == "" || set[name] {
+				return nil, fmt.Errorf("%w: %s: %s names must be unique and not empty, got %q", ErrInvalidStack, spec.Name, kind, name)
+			}
+			set[name] = true
+		}
+		return set, nil
+	}
+	networks, err := declared("network", spec.Networks)
+	if err != nil {
+		return err
+	}
+	volumes, err := declared("volume", spec.Volumes)
+	if err != nil {
+		return err
+	}
+
+	specs := make([]ContainerSpec, 0, len(spec.Containers))
+	for _, c := range spec.Containers {
+		for _, name := range c.Networks {
+			if !networks[name] {
+				return fmt.Errorf("%w: %s: %s is attached to unknown network %s", ErrInvalidStack, spec.Name, c.Name, name)
+			}
+		}
+		for name := range c.Mounts {
+			if !volumes[name] {
+				return fmt.Errorf("%w: %s: %s mounts unknown volume %s", ErrInvalidStack, spec.Name, c.Name, name)
+			}
+		}
+		specs = append(specs, c.ContainerSpec)
+	}
+	_, err = startOrder(specs)
+	return err
+}
+
+// withNetworks attaches the container to the networks when it is created,
+// with the first as its primary network, and gives it the aliases on each of
+// them.
+func withNetworks(networks []string, aliases ...string) ContainerOption {
+	return func(spec *containerSpec) error {
+		spec.hostConfig.NetworkMode = container.NetworkMode(networks[0])
+		for _, name := range networks {
+			if spec.networkConfig.EndpointsConfig[name] == nil {
+				spec.networkConfig.EndpointsConfig[name] = &network.EndpointSettings{}
+			}
+			endpoint := spec.networkConfig.EndpointsConfig[name]
+			endpoint.Aliases = append(endpoint.Aliases, aliases...)
+		}
+		return nil
+	}
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package udock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackSpecValidate(t *testing.T) {
+	spec := StackSpec{
+		Name:     "shop",
+		Networks: []string{"backend"},
+		Volumes:  []string{"data"},
+		Containers: []StackContainer{
+			{
+				ContainerSpec: ContainerSpec{Name: "db"},
+				Networks:      []string{"backend"},
+				Mounts:        map[string]string{"data": "/var/lib/data"},
+			},
+			{
+				ContainerSpec: ContainerSpec{Name: "app", DependsOn: []string{"db"}},
+				Networks:      []string{"backend"},
+			},
+		},
+	}
+	require.NoError(t, spec.validate())
+
+	bad := spec
+	bad.Name = ""
+	require.ErrorIs(t, bad.validate(), ErrInvalidStack)
+
+	bad = spec
+	bad.Networks = []string{"backend", "backend"}
+	require.ErrorIs(t, bad.validate(), ErrInvalidStack)
+
+	bad = spec
+	bad.Containers = []StackContainer{{ContainerSpec: ContainerSpec{Name: "db"}, Networks: []string{"frontend"}}}
+	require.ErrorIs(t, bad.validate(), ErrInvalidStack)
+
+	bad = spec
+	bad.Containers = []StackContainer{{ContainerSpec: ContainerSpec{Name: "db"}, Mounts: map[string]string{"logs": "/logs"}}}
+	require.ErrorIs(t, bad.validate(), ErrInvalidStack)
+
+	bad = spec
+	bad.Containers = []StackContainer{{ContainerSpec: ContainerSpec{Name: "app", DependsOn: []string{"db"}}}}
+	require.ErrorIs(t, bad.validate(), ErrDependency)
+}
+
+func TestWithNetworks(t *testing.T) {
+	spec, err := newContainerSpec("app", nil, withNetworks([]string{"frontend", "backend"}, "app"), WithNetworkAliases("api"))
+	require.NoError(t, err)
+	require.Equal(t, "frontend", string(spec.hostConfig.NetworkMode))
+	require.Len(t, spec.networkConfig.EndpointsConfig, 2)
+	require.Equal(t, []string{"app", "api"}, spec.networkConfig.EndpointsConfig["frontend"].Aliases)
+	require.Equal(t, []string{"app"}, spec.networkConfig.EndpointsConfig["backend"].Aliases)
+}
+
+func TestStackContainerSpecs(t *testing.T) {
+	stack := (&Session{}).NewStack(StackSpec{
+		Name:     "shop",
+		Networks: []string{"internal"},
+		Containers: []StackContainer{
+			{ContainerSpec: ContainerSpec{Name: "db"}, Networks: []string{"internal"}},
+			{ContainerSpec: ContainerSpec{Name: "app", DependsOn: []string{"db"}}, Networks: []string{"internal"}},
+		},
+	})
+	stack.networks["internal"] = "shop-internal-1234"
+
+	specs, names := stack.containerSpecs()
+	require.Len(t, specs, 2)
+	require.Equal(t, "db", names[specs[0].Name])
+	require.Equal(t, "app", names[specs[1].Name])
+	require.NotEqual(t, "db", specs[0].Name)
+	require.True(t, strings.HasPrefix(specs[0].Name, "shop-db-"))
+	require.Equal(t, []string{specs[0].Name}, specs[1].DependsOn)
+	require.Equal(t, []string{"db"}, stack.spec.Containers[1].DependsOn)
+
+	// the name of the spec is an alias on the networks of the stack
+	spec, err := newContainerSpec("db", nil, specs[0].Options...)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db"}, spec.networkConfig.EndpointsConfig["shop-internal-1234"].Aliases)
+
+	// a second stack from the same spec gets other names
+	again, _ := stack.containerSpecs()
+	require.NotEqual(t, specs[0].Name, again[0].Name)
+}
+
+func TestReadinessError(t *testing.T) {
+	var err error = &ReadinessError{
+		Stack: "shop",
+		Failed: map[string]error{
+			"db":  fmt.Errorf("%w: db exited with code 1", ErrContainerExited),
+			"app": ErrTimeout,
+		},
+	}
+	require.ErrorIs(t, err, ErrNotReady)
+	require.ErrorIs(t, err, ErrContainerExited)
+	require.EqualError(t, err, "stack shop is not ready: app: operation timed out; db: container exited: db exited with code 1")
+
+	var readinessErr *ReadinessError
+	require.ErrorAs(t, fmt.Errorf("wrapped: %w", err), &readinessErr)
+	require.Len(t, readinessErr.Failed, 2)
+
+	stack := (&Session{}).NewStack(StackSpec{Name: "shop"})
+	require.ErrorIs(t, stack.WaitReady(context.Background()), ErrInvalidStack)
+}
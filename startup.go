@@ -0,0 +1,160 @@
+package udock
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// StartupTiming is how long it took from asking for a container until it was
+// created, running and ready, that is until it had passed the wait strategy
+// and init steps of its spec.
+type StartupTiming struct {
+	Created time.Duration
+	Running time.Duration
+	Ready   time.Duration
+}
+
+// StartupStats summarizes the time a startup phase took across runs.
+type StartupStats struct {
+	Min    time.Duration
+	Median time.Duration
+	Mean   time.Duration
+	Max    time.Duration
+}
+
+// StartupReport holds the startup timings measured by MeasureStartup.
+type StartupReport struct {
+	Image string
+	Runs  []StartupTiming
+}
+
+// MeasureStartup creates, starts and waits for a container as described by
+// spec runs times, timing each phase, and removes the container after each
+// run.  Pull the image first or the first run includes the pull.  Reuse is
+// ignored since reused containers do not start.
+func (s *Session) MeasureStartup(spec ContainerSpec, runs int) (StartupReport, error) {
+	if runs < 1 {
+		return StartupReport{}, fmt.Errorf("%w: need at least one run, got %d", ErrInvalidOption, runs)
+	}
+
+	spec, err := spec.expandVars()
+	if err != nil {
+		return StartupReport{}, err
+	}
+
+	report := StartupReport{Image: spec.Image}
+	for range runs {
+		timing, err := s.measureStartup(spec)
+		if err != nil {
+			return report, err
+		}
+		report.Runs = append(report.Runs, timing)
+	}
+	return report, nil
+}
+
+// measureStartup times a single run.
+func (s *Session) measureStartup(spec ContainerSpec) (StartupTiming, error) {
+	var timing StartupTiming
+	start := time.Now()
+
+	containerID, err := s.CreateContainer(spec.Image, spec.Name, spec.Ports, spec.Options...)
+	if err != nil {
+		return timing, err
+	}
+	defer func() {
+		_ = s.RemoveContainer(containerID)
+	}()
+	timing.Created = time.Since(start)
+
+	err = s.StartContainer(containerID)
+	if err != nil {
+		return timing, err
+	}
+	timing.Running = time.Since(start)
+
+	err = s.waitAndInit(context.Background(), spec, containerID)
+	if err != nil {
+		return timing, err
+	}
+	timing.Ready = time.Since(start)
+
+	return timing, nil
+}
+
+// Created summarizes the time to created across runs.
+func (r StartupReport) Created() StartupStats {
+	return r.stats(func(t StartupTiming) time.Duration { return t.Created })
+}
+
+// Running summarizes the time to running across runs.
+func (r StartupReport) Running() StartupStats {
+	return r.stats(func(t StartupTiming) time.Duration { return t.Running })
+}
+
+// Ready summarizes the time to ready across runs.
+func (r StartupReport) Ready() StartupStats {
+	return r.stats(func(t StartupTiming) time.Duration { return t.Ready })
+}
+
+// stats summarizes the phase picked by phase.
+func (r StartupReport) stats(phase func(StartupTiming) time.Duration) StartupStats {
+	if len(r.Runs) == 0 {
+		return StartupStats{}
+	}
+
+	durations := make([]time.Duration, 0, len(r.Runs))
+	var total time.Duration
+	for _, run := range r.Runs {
+		durations = append(durations, phase(run))
+		total += phase(run)
+	}
+	slices.Sort(durations)
+
+	median := durations[len(durations)/2]
+	if len(durations)%2 == 0 {
+		median = (durations[len(durations)/2-1] + median) / 2
+	}
+
+	return StartupStats{
+		Min:    durations[0],
+		Median: median,
+		Mean:   total / time.Duration(len(durations)),
+		Max:    durations[len(durations)-1],
+	}
+}
+
+// String formats the report as a small table.
+func (r StartupReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "startup of %s over %d runs\n", r.Image, len(r.Runs))
+	fmt.Fprintf(&b, "%-8s %10s %10s %10s %10s\n", "", "min", "median", "mean", "max")
+	for _, phase := range []struct {
+		name  string
+		stats StartupStats
+	}{
+		{"created", r.Created()},
+		{"running", r.Running()},
+		{"ready", r.Ready()},
+	} {
+		fmt.Fprintf(&b, "%-8s %10s %10s %10s %10s\n", phase.name,
+			phase.stats.Min.Round(time.Millisecond),
+			phase.stats.Median.Round(time.Millisecond),
+			phase.stats.Mean.Round(time.Millisecond),
+			phase.stats.Max.Round(time.Millisecond))
+	}
+	return b.String()
+}
+
+// ReportMetrics reports the median time to each phase as custom metrics of
+// the benchmark, so they show up in go test -bench output and can be tracked
+// with tools like benchstat.
+func (r StartupReport) ReportMetrics(b *testing.B) {
+	b.ReportMetric(float64(r.Created().Median.Milliseconds()), "ms-to-created")
+	b.ReportMetric(float64(r.Running().Median.Milliseconds()), "ms-to-running")
+	b.ReportMetric(float64(r.Ready().Median.Milliseconds()), "ms-to-ready")
+}
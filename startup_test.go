@@ -0,0 +1,30 @@
+package udock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartupReport(t *testing.T) {
+	ms := time.Millisecond
+	report := StartupReport{
+		Image: "postgres:16",
+		Runs: []StartupTiming{
+			{Created: 10 * ms, Running: 100 * ms, Ready: 1000 * ms},
+			{Created: 30 * ms, Running: 300 * ms, Ready: 3000 * ms},
+			{Created: 20 * ms, Running: 200 * ms, Ready: 2000 * ms},
+			{Created: 40 * ms, Running: 400 * ms, Ready: 6000 * ms},
+		},
+	}
+
+	require.Equal(t, StartupStats{Min: 10 * ms, Median: 25 * ms, Mean: 25 * ms, Max: 40 * ms}, report.Created())
+	require.Equal(t, StartupStats{Min: 1000 * ms, Median: 2500 * ms, Mean: 3000 * ms, Max: 6000 * ms}, report.Ready())
+
+	report.Runs = report.Runs[:3]
+	require.Equal(t, 200*ms, report.Running().Median)
+
+	require.Contains(t, report.String(), "startup of postgres:16 over 3 runs")
+	require.Equal(t, StartupStats{}, StartupReport{}.Ready())
+}
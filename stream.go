@@ -0,0 +1,37 @@
+package udock
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// readJSONMessages decodes the stream of JSON messages returned by the build,
+// pull and push endpoints and calls fn for each message.  fn may be nil.  If
+// the daemon reports an error in the stream that error is returned.
+func readJSONMessages(r io.Reader, fn func(msg jsonmessage.JSONMessage)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.ErrorMessage != "" {
+			return errors.New(msg.ErrorMessage)
+		}
+
+		if fn != nil {
+			fn(msg)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package udock
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadJSONMessages(t *testing.T) {
+	stream := `{"status":"Pulling fs layer","id":"a"}
+{"status":"Downloading","id":"a","progressDetail":{"current":10,"total":100}}
+{"status":"Pull complete","id":"a"}
+`
+	var msgs []jsonmessage.JSONMessage
+	err := readJSONMessages(strings.NewReader(stream), func(msg jsonmessage.JSONMessage) {
+		msgs = append(msgs, msg)
+	})
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+	require.Equal(t, int64(100), msgs[1].Progress.Total)
+
+	// errors in the stream are returned
+	stream = `{"status":"Pushing","id":"a"}
+{"errorDetail":{"message":"denied: requested access to the resource is denied"},"error":"denied: requested access to the resource is denied"}
+`
+	err = readJSONMessages(strings.NewReader(stream), nil)
+	require.ErrorContains(t, err, "denied")
+
+	// as are errors from old daemons that only set the error string
+	err = readJSONMessages(strings.NewReader(`{"error":"boom"}`), nil)
+	require.ErrorContains(t, err, "boom")
+
+	// garbage is an error
+	err = readJSONMessages(strings.NewReader(`{"status":`), nil)
+	require.Error(t, err)
+}
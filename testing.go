@@ -0,0 +1,244 @@
+package udock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// dockerAvailable probes for docker once and remembers the outcome, so
+// suites with many tests only pay for the probe once.
+var dockerAvailable = sync.OnceValue(func() error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	return c.Close()
+})
+
+// SkipIfUnavailable skips the test if docker is not available, e.g. because
+// the docker daemon is not running.  Call it first thing in tests that need
+// docker.
+func SkipIfUnavailable(t testing.TB) {
+	t.Helper()
+	err := dockerAvailable()
+	if err != nil {
+		t.Skipf("docker not available, if you want these tests to run please make sure docker is running: %v", err)
+	}
+}
+
+// suite holds the session and containers Main brings up for the tests.
+var suite struct {
+	session    *Session
+	containers map[string]string
+}
+
+// Main is for TestMain.  It brings up the containers described by specs,
+// runs the tests and tears the containers down again, and then exits.  The
+// tests get at the containers through SharedContainer and SharedEndpoint,
+// which look them up by the name in the spec or, if that is empty, the
+// image.  The actual container names are made unique so test binaries for
+// several packages can run at the same time.
+//
+//	func TestMain(m *testing.M) {
+//		udock.Main(m, udock.ContainerSpec{
+//			Name:    "pg",
+//			Image:   "postgres:16",
+//			Ports:   map[string]string{"": "5432"},
+//			WaitFor: udock.WaitForPort("5432"),
+//		})
+//	}
+//
+// If docker is not available the tests are run anyway, and those that use
+// the shared containers are skipped.
+func Main(m *testing.M, specs ...ContainerSpec) {
+	os.Exit(runMain(m, specs))
+}
+
+// runMain does the work of Main and returns the exit code.
+func runMain(m *testing.M, specs []ContainerSpec) int {
+	if dockerAvailable() != nil {
+		return m.Run()
+	}
+
+	err := startSuite(specs)
+	defer stopSuite()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "udock: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// startSuite creates the session for Main and brings up the containers.
+func startSuite(specs []ContainerSpec) error {
+	s, err := Create()
+	if err != nil {
+		return err
+	}
+	suite.session = s
+	suite.containers = map[string]string{}
+
+	for _, spec := range specs {
+		key := spec.Name
+		if key == "" {
+			key = spec.Image
+		}
+		if _, exists := suite.containers[key]; exists {
+			return fmt.Errorf("%w: more than one shared container named %q", ErrInvalidOption, key)
+		}
+
+		spec.Name = UniqueName(spec.Name, "")
+		containerID, err := s.Run(spec)
+		if err != nil {
+			return err
+		}
+		suite.containers[key] = containerID
+	}
+	return nil
+}
+
+// stopSuite removes the containers Main brought up and closes the session.
+func stopSuite() {
+	if suite.session == nil {
+		return
+	}
+
+	var errs []error
+	for _, containerID := range suite.containers {
+		errs = append(errs, suite.session.RemoveContainer(containerID))
+	}
+	errs = append(errs, suite.session.Close())
+
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.Error("tearing down shared containers", "err", err)
+	}
+}
+
+// SharedSession returns the session of the containers Main brought up.  The
+// test is skipped if docker is not available.
+func SharedSession(t testing.TB) *Session {
+	t.Helper()
+	SkipIfUnavailable(t)
+
+	if suite.session == nil {
+		t.Fatal("udock: there is no shared session, call udock.Main from TestMain")
+	}
+	return suite.session
+}
+
+// SharedContainer returns the ID of the container Main brought up for the
+// spec with the given name.  The test is skipped if docker is not available.
+func SharedContainer(t testing.TB, name string) string {
+	t.Helper()
+	SharedSession(t)
+
+	containerID, ok := suite.containers[name]
+	if !ok {
+		t.Fatalf("udock: there is no shared container named %q", name)
+	}
+	return containerID
+}
+
+// SharedEndpoint returns the host:port address the container port, e.g.
+// "5432", of the shared container with the given name is reachable at.  The
+// test is skipped if docker is not available.
+func SharedEndpoint(t testing.TB, name string, containerPort string) string {
+	t.Helper()
+	s := SharedSession(t)
+
+	addr, err := s.MappedAddress(SharedContainer(t, name), containerPort)
+	if err != nil {
+		t.Fatalf("udock: %v", err)
+	}
+	return addr
+}
+
+// DumpOnFailure makes the session Dump its containers to a directory named
+// after the test under dir if the test fails, e.g. to a directory CI keeps
+// as an artifact.  Cleanup functions run in reverse order, so call this
+// after registering the cleanup that removes the containers or there will be
+// nothing left to dump.
+func (s *Session) DumpOnFailure(t testing.TB, dir string) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+
+		dumpDir := filepath.Join(dir, sanitizeName(t.Name()))
+		err := s.Dump(dumpDir)
+		if err != nil {
+			t.Logf("udock: dumping diagnostics to %s: %v", dumpDir, err)
+			return
+		}
+		t.Logf("udock: dumped diagnostics to %s", dumpDir)
+	})
+}
+
+// LogToTest streams the stdout and stderr of the container into the log of
+// the test, each line prefixed with the name of the container, so the output
+// of the dependencies of a test is right there when it fails.  Streaming stops
+// when the test and its subtests have completed, or when the container exits.
+func (s *Session) LogToTest(t testing.TB, containerID string) {
+	t.Helper()
+
+	name := containerID
+	info, err := s.client.ContainerInspect(context.Background(), containerID)
+	if err == nil {
+		name = strings.TrimPrefix(info.Name, "/")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	go func() {
+		defer close(done)
+		stdout := &testLogWriter{t: t, prefix: name + " stdout: "}
+		stderr := &testLogWriter{t: t, prefix: name + " stderr: "}
+		err := s.copyLogs(ctx, containerID, true, stdout, stderr)
+		stdout.flush()
+		stderr.flush()
+		if err != nil && ctx.Err() == nil {
+			t.Logf("%s: %v", name, err)
+		}
+	}()
+}
+
+// testLogWriter logs what is written to it to a test, one line at a time.
+type testLogWriter struct {
+	t      testing.TB
+	prefix string
+	buf    []byte
+}
+
+func (w *testLogWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.t.Log(w.prefix + strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush logs whatever is left of the last line.
+func (w *testLogWriter) flush() {
+	if len(w.buf) > 0 {
+		w.t.Log(w.prefix + string(w.buf))
+		w.buf = nil
+	}
+}
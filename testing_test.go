@@ -0,0 +1,71 @@
+package udock
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTB records what is logged to it, and what it is failed with.
+// Since Fatal and Fatalf stop the goroutine they are called from, use
+// fatalOf to call functions that may fail the test.
+type recordingTB struct {
+	testing.TB
+	lines []string
+	fatal string
+}
+
+func (r *recordingTB) Log(args ...any) {
+	r.lines = append(r.lines, fmt.Sprint(args...))
+}
+
+func (r *recordingTB) Fatal(args ...any) {
+	r.fatal = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+func (r *recordingTB) Fatalf(format string, args ...any) {
+	r.fatal = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// fatalOf calls f with a recordingTB and returns what it was failed with, if
+// anything.
+func fatalOf(t *testing.T, f func(tb testing.TB)) string {
+	tb := &recordingTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(tb)
+	}()
+	<-done
+	return tb.fatal
+}
+
+func TestSharedOutsideMain(t *testing.T) {
+	SkipIfUnavailable(t)
+	require.Nil(t, suite.session)
+
+	require.Contains(t, fatalOf(t, func(tb testing.TB) { SharedSession(tb) }), "call udock.Main from TestMain")
+	require.Contains(t, fatalOf(t, func(tb testing.TB) { SharedContainer(tb, "pg") }), "call udock.Main from TestMain")
+	require.Contains(t, fatalOf(t, func(tb testing.TB) { SharedEndpoint(tb, "pg", "5432") }), "call udock.Main from TestMain")
+}
+
+func TestTestLogWriter(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	w := &testLogWriter{t: tb, prefix: "pg stdout: "}
+
+	_, err := w.Write([]byte("database system is "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("ready\r\nlistening on 5432\npartial"))
+	require.NoError(t, err)
+	w.flush()
+
+	require.Equal(t, []string{
+		"pg stdout: database system is ready",
+		"pg stdout: listening on 5432",
+		"pg stdout: partial",
+	}, tb.lines)
+}
@@ -3,18 +3,23 @@ package udock
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
-	"github.com/docker/go-connections/nat"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 const (
@@ -38,8 +43,98 @@ const (
 	// stopContainerTimeout is the timeout for stopping a container.
 	dockerRemoveContainerTimeout = 10 * time.Second
 
+	// defaultStopTimeout is how long docker waits for a container to stop
+	// after sending it the stop signal, unless the container has a stop
+	// timeout of its own.
+	defaultStopTimeout = 10 * time.Second
+
+	// dockerDumpTimeout is the timeout for dumping the diagnostics of the
+	// containers of a session.
+	dockerDumpTimeout = 2 * time.Minute
+
+	// defaultStartParallelism is the default number of containers RunAll
+	// starts concurrently.
+	defaultStartParallelism = 4
+
+	// defaultPortRetries is how many times Run and CreateAndStartContainer
+	// retry with new host ports when a free port is taken before the
+	// container starts.
+	defaultPortRetries = 3
+
+	// dockerStopContainerMargin is added to the stop timeout of a container
+	// to get the timeout for stopping it.
+	dockerStopContainerMargin = 10 * time.Second
+
 	// dockerRemoveImageTimeout is the timeout for removing image.
 	dockerRemoveImageTimeout = 10 * time.Second
+
+	// dockerBuildTimeout is the timeout for building an image.  Builds may
+	// have to download base images and run arbitrary commands so this is
+	// generous.
+	dockerBuildTimeout = 5 * time.Minute
+
+	// dockerTagImageTimeout is the timeout for tagging an image.
+	dockerTagImageTimeout = 10 * time.Second
+
+	// dockerPushTimeout is the timeout for pushing an image to a registry.
+	dockerPushTimeout = 5 * time.Minute
+
+	// dockerSaveImageTimeout is the timeout for saving images to a tar
+	// archive.
+	dockerSaveImageTimeout = 5 * time.Minute
+
+	// dockerLoadImageTimeout is the timeout for loading and importing images
+	// from tar archives.
+	dockerLoadImageTimeout = 5 * time.Minute
+
+	// dockerPruneTimeout is the timeout for pruning unused resources.
+	dockerPruneTimeout = 2 * time.Minute
+
+	// verifyImageTimeout is the timeout for verifying the signature of an
+	// image.
+	verifyImageTimeout = 2 * time.Minute
+
+	// dockerNetworkTimeout is the timeout for creating, removing and
+	// connecting to networks.
+	dockerNetworkTimeout = 10 * time.Second
+
+	// networkToolTimeout is the timeout for running the sidecar that shapes
+	// the network of a container.
+	networkToolTimeout = time.Minute
+
+	// dockerVolumeTimeout is the timeout for creating and removing volumes.
+	dockerVolumeTimeout = 10 * time.Second
+
+	// dockerInspectTimeout is the timeout for inspecting images and
+	// containers.
+	dockerInspectTimeout = 10 * time.Second
+
+	// dockerCopyTimeout is the timeout for copying data into and out of
+	// volumes.
+	dockerCopyTimeout = 5 * time.Minute
+
+	// dockerCommitTimeout is the timeout for committing a container to an
+	// image.
+	dockerCommitTimeout = 2 * time.Minute
+
+	// dockerPauseTimeout is the timeout for pausing and unpausing
+	// containers.
+	dockerPauseTimeout = 10 * time.Second
+
+	// dockerExecTimeout is the timeout for running commands in containers.
+	dockerExecTimeout = 5 * time.Minute
+
+	// defaultWaitTimeout is how long Run waits for a container to become
+	// ready and run its init steps unless the spec says otherwise.
+	defaultWaitTimeout = time.Minute
+
+	// defaultJobTimeout is how long RunJob lets a job run unless the spec
+	// says otherwise.
+	defaultJobTimeout = 10 * time.Minute
+
+	// waitPollInterval is how often wait strategies check whether a
+	// container has become ready.
+	waitPollInterval = 100 * time.Millisecond
 )
 
 // package errors
@@ -52,31 +147,329 @@ var (
 	ErrPullingImage         = errors.New("error pulling image")
 	ErrCreatingContainer    = errors.New("error creating container")
 	ErrStartingContainer    = errors.New("error starting container")
+	ErrStoppingContainer    = errors.New("error stopping container")
+	ErrRemovingContainer    = errors.New("error removing container")
+	ErrStartingReaper       = errors.New("error starting reaper")
+	ErrCleaningUpOrphans    = errors.New("error cleaning up orphans")
+	ErrRemovingImage        = errors.New("error removing image")
+	ErrCreatingSnapshot     = errors.New("error creating snapshot")
+	ErrRestoringSnapshot    = errors.New("error restoring snapshot")
+	ErrPoolClosed           = errors.New("pool is closed")
+	ErrPoolExhausted        = errors.New("no pooled container is available")
+	ErrInvalidPool          = errors.New("invalid pool")
+	ErrExecuting            = errors.New("error executing command in container")
+	ErrContainerExited      = errors.New("container exited")
+	ErrNotReady             = errors.New("container did not become ready")
+	ErrInitStep             = errors.New("init step failed")
+	ErrInitContainer        = errors.New("init container failed")
+	ErrCreatingGoldenImage  = errors.New("error creating golden image")
+	ErrPortAllocated        = errors.New("host port is already allocated")
+	ErrDumpingDiagnostics   = errors.New("error dumping diagnostics")
+	ErrDependency           = errors.New("invalid container dependency")
+	ErrInvalidStack         = errors.New("invalid stack")
+	ErrNotInStack           = errors.New("not part of the stack")
 	ErrTimeout              = errors.New("operation timed out")
 	ErrPortMap              = errors.New("portmap error")
+	ErrBuildContext         = errors.New("error creating build context")
+	ErrBuildingImage        = errors.New("error building image")
+	ErrTaggingImage         = errors.New("error tagging image")
+	ErrPushingImage         = errors.New("error pushing image")
+	ErrRegistryAuth         = errors.New("error encoding registry credentials")
+	ErrDockerConfig         = errors.New("error reading docker config")
+	ErrCredentialHelper     = errors.New("error running credential helper")
+	ErrInspectingImage      = errors.New("error inspecting image")
+	ErrInvalidDigest        = errors.New("invalid digest")
+	ErrDigestMismatch       = errors.New("image digest does not match")
+	ErrInvalidPlatform      = errors.New("invalid platform")
+	ErrSavingImage          = errors.New("error saving image")
+	ErrLoadingImage         = errors.New("error loading image")
+	ErrPruningImages        = errors.New("error pruning images")
+	ErrImportingImage       = errors.New("error importing image")
+	ErrVerifyingImage       = errors.New("error verifying image")
+	ErrCreatingNetwork      = errors.New("error creating network")
+	ErrRemovingNetwork      = errors.New("error removing network")
+	ErrConnectingNetwork    = errors.New("error connecting container to network")
+	ErrDisconnectingNetwork = errors.New("error disconnecting container from network")
+	ErrInspectingContainer  = errors.New("error inspecting container")
+	ErrPortNotMapped        = errors.New("port is not mapped")
+	ErrInvalidOption        = errors.New("invalid option")
+	ErrNotOnNetwork         = errors.New("container has no address on network")
+	ErrWaitingForContainer  = errors.New("error waiting for container")
+	ErrReadingLogs          = errors.New("error reading container logs")
+	ErrShapingNetwork       = errors.New("error shaping network")
+	ErrCreatingVolume       = errors.New("error creating volume")
+	ErrRemovingVolume       = errors.New("error removing volume")
+	ErrPruningVolumes       = errors.New("error pruning volumes")
+	ErrSeedingVolume        = errors.New("error seeding volume")
+	ErrBackingUpVolume      = errors.New("error backing up volume")
+	ErrRestoringVolume      = errors.New("error restoring volume")
+	ErrCopyingFiles         = errors.New("error copying files into container")
+	ErrCopyingFromContainer = errors.New("error copying files from container")
+	ErrReadingEnvFile       = errors.New("error reading env file")
+	ErrExpandingVars        = errors.New("error expanding variables")
 )
 
 type Session struct {
 	client *client.Client
+
+	// useDockerConfig makes the session look up registry credentials in the
+	// docker config file when none are given explicitly.
+	useDockerConfig bool
+
+	// id identifies the session in the labels of the containers it creates.
+	id string
+
+	// created is when the session was created.
+	created time.Time
+
+	// mirrors maps registry domains to the mirrors we pull from instead.
+	mirrors map[string]string
+
+	// platform is the platform images are pulled, looked up and run for.
+	// If nil the docker daemon's default platform is used.
+	platform *ocispec.Platform
+
+	// verifier verifies images before they are used.
+	verifier ImageVerifier
+
+	// sessionNetwork makes the session create a network of its own that
+	// all its containers are attached to.  The options are used for creating
+	// the network.
+	sessionNetwork        bool
+	sessionNetworkOptions []NetworkOption
+
+	// proxyEnv holds the proxy environment variables passed on to every
+	// container.
+	proxyEnv []string
+
+	// startParallelism is the maximum number of containers RunAll starts
+	// concurrently.
+	startParallelism int
+
+	// portRetries is how many times Run and CreateAndStartContainer retry
+	// with new host ports when a free port is taken before the container
+	// starts.
+	portRetries int
+
+	// keepVolumes stops Close from removing the volumes created by the
+	// session.
+	keepVolumes bool
+
+	// reaper makes the session start a reaper and reaperConn is our
+	// connection to it.  The reaper cleans up after the session when the
+	// connection goes away.
+	reaper     bool
+	reaperConn net.Conn
+
+	// networkName and networkID identify the session network, if any.
+	networkName string
+	networkID   string
+
+	mu sync.Mutex
+	// verified holds the IDs of the images the verifier has accepted.
+	verified map[string]bool
+	// volumes holds the names of the volumes created by the session.
+	volumes []string
+	// sidecars maps the IDs of containers to the IDs of their sidecars.
+	sidecars map[string][]string
+	// shaped maps the IDs of containers whose network ShapeNetwork
+	// degraded to the interfaces it shaped and the images it ran tc from.
+	shaped map[string]map[string]string
+	// mirrored maps images pulled by digest from a mirror to the names
+	// docker knows them by, see localImage.
+	mirrored map[string]string
 }
 
-func Create() (*Session, error) {
-	client, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return nil, errors.Join(ErrCreatingDockerClient, err)
+// SessionOption is an option for Create.
+type SessionOption func(*Session) error
+
+// WithDockerConfigAuth makes the session resolve registry credentials from the
+// docker config file ($DOCKER_CONFIG/config.json or ~/.docker/config.json),
+// including any credential helpers configured there, whenever we talk to a
+// registry and no credentials were given explicitly.
+func WithDockerConfigAuth() SessionOption {
+	return func(s *Session) error {
+		s.useDockerConfig = true
+		return nil
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), dockerConnectTimeout)
-	defer cancel()
+// WithRegistryMirror makes the session pull images hosted on registry from
+// mirror instead, e.g. WithRegistryMirror("docker.io",
+// "mirror.internal.example.com").  Images pulled from the mirror are tagged
+// with their original name so the rest of the API does not need to know about
+// the mirror.
+func WithRegistryMirror(registry string, mirror string) SessionOption {
+	return func(s *Session) error {
+		if s.mirrors == nil {
+			s.mirrors = map[string]string{}
+		}
+		s.mirrors[registry] = mirror
+		return nil
+	}
+}
+
+// WithPlatform makes the session pull, look up and run images for platform,
+// which is of the form os/arch[/variant], e.g. "linux/amd64".  This is useful
+// for forcing amd64 images on arm64 hosts.
+func WithPlatform(platform string) SessionOption {
+	return func(s *Session) error {
+		p, err := parsePlatform(platform)
+		if err != nil {
+			return err
+		}
+		s.platform = p
+		return nil
+	}
+}
 
-	_, err = client.Ping(ctx)
+// WithImageVerifier makes the session verify images, e.g. by checking their
+// signatures with CosignVerifier, after pulling them and before creating
+// containers from them.  Containers are not created from images that fail
+// verification.
+func WithImageVerifier(verifier ImageVerifier) SessionOption {
+	return func(s *Session) error {
+		s.verifier = verifier
+		return nil
+	}
+}
+
+// WithSessionNetwork makes the session create a bridge network of its own
+// when it is created, attach every container it creates to that network and
+// remove the network when the session is closed.  This gives each test suite
+// a clean network where containers can reach each other by name.  The
+// options are used when creating the network.
+func WithSessionNetwork(opts ...NetworkOption) SessionOption {
+	return func(s *Session) error {
+		s.sessionNetwork = true
+		s.sessionNetworkOptions = opts
+		return nil
+	}
+}
+
+// proxyEnvVars are the environment variables that configure proxies.  Both
+// upper and lower case variants are in common use.
+var proxyEnvVars = []string{
+	"HTTP_PROXY", "http_proxy",
+	"HTTPS_PROXY", "https_proxy",
+	"NO_PROXY", "no_proxy",
+	"FTP_PROXY", "ftp_proxy",
+	"ALL_PROXY", "all_proxy",
+}
+
+// WithProxyEnv passes the proxy environment variables (HTTP_PROXY,
+// HTTPS_PROXY, NO_PROXY etc) of the current process on to every container
+// the session creates, which is what containers need to reach anything from
+// behind a corporate proxy.  The variables are read when the session is
+// created.
+func WithProxyEnv() SessionOption {
+	return func(s *Session) error {
+		s.proxyEnv = nil
+		for _, name := range proxyEnvVars {
+			if value, ok := os.LookupEnv(name); ok {
+				s.proxyEnv = append(s.proxyEnv, name+"="+value)
+			}
+		}
+		return nil
+	}
+}
+
+// WithKeepVolumes stops Close from removing the volumes created by the
+// session, which is handy when you want to look at what a failed test left
+// behind.
+func WithKeepVolumes() SessionOption {
+	return func(s *Session) error {
+		s.keepVolumes = true
+		return nil
+	}
+}
+
+// WithPortRetries sets how many times Run and CreateAndStartContainer remove
+// the container and try again with new host ports when a free port we
+// picked, see WithFreePorts, is taken by someone else before the container
+// starts.  The default is 3, and 0 turns retrying off.
+func WithPortRetries(retries int) SessionOption {
+	return func(s *Session) error {
+		if retries < 0 {
+			return fmt.Errorf("%w: negative number of port retries %d", ErrInvalidOption, retries)
+		}
+		s.portRetries = retries
+		return nil
+	}
+}
+
+// WithStartParallelism sets the maximum number of containers RunAll starts
+// concurrently.  The default is 4, and 1 starts them one at a time.
+func WithStartParallelism(n int) SessionOption {
+	return func(s *Session) error {
+		if n < 1 {
+			return fmt.Errorf("%w: start parallelism must be at least 1, got %d", ErrInvalidOption, n)
+		}
+		s.startParallelism = n
+		return nil
+	}
+}
+
+// Create a new session.
+func Create(opts ...SessionOption) (*Session, error) {
+	client, err := connect()
 	if err != nil {
-		return nil, errors.Join(ErrConnectingToDocker, err)
+		return nil, err
 	}
 
-	return &Session{
-		client: client,
-	}, nil
+	session := newSession(client)
+	for _, opt := range opts {
+		err := opt(session)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if session.reaper {
+		err := session.startReaper()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if session.sessionNetwork {
+		name := "udock-" + randomID()
+		id, err := session.CreateNetwork(name, session.sessionNetworkOptions...)
+		if err != nil {
+			session.stopReaper()
+			client.Close()
+			return nil, err
+		}
+		session.networkName = name
+		session.networkID = id
+	}
+
+	return session, nil
+}
+
+// Network returns the name of the session network, or an empty string if the
+// session was not created with WithSessionNetwork.
+func (s *Session) Network() string {
+	return s.networkName
+}
+
+// defaultContainerOptions returns the options that apply to every container
+// the session creates.  They are applied before the options given by the
+// caller so the caller can override them.
+func (s *Session) defaultContainerOptions() []ContainerOption {
+	opts := []ContainerOption{WithLabels(s.labels())}
+	if s.networkName != "" {
+		opts = append(opts, WithNetworkMode(s.networkName))
+	}
+	if len(s.proxyEnv) > 0 {
+		opts = append(opts, func(spec *containerSpec) error {
+			spec.config.Env = append(spec.config.Env, s.proxyEnv...)
+			return nil
+		})
+	}
+	return opts
 }
 
 // VerifyHaveImage returns a nil error if we have the image and an error if the
@@ -86,6 +479,22 @@ func (s *Session) VerifyHaveImage(dockerImage string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dockerImageVerifyTimeout)
 	defer cancel()
 
+	// the reference filter does not match on digests, so images referenced
+	// by digest are looked up directly.
+	if strings.Contains(dockerImage, "@") {
+		inspect, _, err := s.client.ImageInspectWithRaw(ctx, s.localImage(dockerImage))
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("%w: %s", ErrImageNotPresent, dockerImage)
+		}
+		if err != nil {
+			return errors.Join(ErrListingImages, err)
+		}
+		if !platformMatches(s.platform, inspect) {
+			return fmt.Errorf("%w: %s for %s", ErrImageNotPresent, dockerImage, formatPlatform(s.platform))
+		}
+		return nil
+	}
+
 	filterArgs := filters.NewArgs()
 	filterArgs.Add("reference", dockerImage)
 
@@ -95,82 +504,147 @@ func (s *Session) VerifyHaveImage(dockerImage string) error {
 	}
 
 	// if the length is nonzero it means we have the image already.
-	if len(images) > 0 {
+	if len(images) > 0 && s.platform == nil {
 		return nil
 	}
 
+	// if we want a particular platform we have to check that at least one
+	// of the images is for that platform.
+	for _, img := range images {
+		inspect, _, err := s.client.ImageInspectWithRaw(ctx, img.ID)
+		if err != nil {
+			return errors.Join(ErrListingImages, err)
+		}
+		if platformMatches(s.platform, inspect) {
+			return nil
+		}
+	}
+
+	if s.platform != nil {
+		return fmt.Errorf("%w: %s for %s", ErrImageNotPresent, dockerImage, formatPlatform(s.platform))
+	}
 	return fmt.Errorf("%w: %s", ErrImageNotPresent, dockerImage)
 }
 
-// PullImage checks if we have an image and if we do not have the image pulls a
-// docker image.  Returns a nil error if ok and an error value if something
-// went wrong.
-func (s *Session) PullImage(dockerImage string) error {
-	err := s.VerifyHaveImage(dockerImage)
-	if err == nil {
-		slog.Info("already have image, not pulling", "dockerImage", dockerImage)
-		return nil
-	}
+// CreateContainer creates a container.  ports maps host ports to container
+// ports.  Container ports are tcp unless they have a protocol suffix, e.g.
+// "53/udp" or "9899/sctp".  Published ports are bound to 127.0.0.1 unless
+// WithHostIP is given.  If containerName is taken by a container udock
+// created in an earlier session that is no longer alive, typically one whose
+// test process crashed, that container is removed even if it is still
+// running; see UniqueName for avoiding name collisions altogether.  If the operation succeeds we return a
+// containerID and error is nil.  If an error occurs, the container ID is
+// empty and the error is set.
+//
+// Host ports given in ports have to be free when the container is started,
+// and nothing can be done about it if they have been taken by then.  Use
+// WithFreePorts and CreateAndStartContainer to have taken ports replaced.
+func (s *Session) CreateContainer(dockerImage string, containerName string, ports map[string]string, opts ...ContainerOption) (string, error) {
+	containerID, _, err := s.newContainer(dockerImage, containerName, ports, opts...)
+	return containerID, err
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
-	defer cancel()
+// newContainer does the work of CreateContainer, and also returns true if
+// the container publishes ports on free host ports we picked, see
+// WithFreePorts.
+func (s *Session) newContainer(dockerImage string, containerName string, ports map[string]string, opts ...ContainerOption) (string, bool, error) {
+	err := s.verifyImage(dockerImage)
+	if err != nil {
+		return "", false, err
+	}
 
-	slog.Info("did not have image, pulling", "dockerImage", dockerImage)
-	image, err := s.client.ImagePull(ctx, dockerImage, image.PullOptions{All: false})
+	spec, err := newContainerSpec(dockerImage, ports, append(s.defaultContainerOptions(), opts...)...)
+	if err != nil {
+		return "", false, err
+	}
+	spec.config.Image = s.localImage(dockerImage)
+	err = spec.resolveFreePorts()
 	if err != nil {
-		return errors.Join(fmt.Errorf("%w: %s", ErrPullingImage, dockerImage), err)
+		return "", false, err
+	}
+
+	platform := s.platform
+	if spec.platform != nil {
+		platform = spec.platform
 	}
 
-	_, err = io.Copy(io.Discard, image)
+	containerID, err := s.createContainer(spec, platform, containerName)
+	if errdefs.IsConflict(err) && containerName != "" {
+		// The name may be held by a container left behind by a previous
+		// run that crashed before it could clean up.
+		removed, removeErr := s.removeStaleContainer(containerName)
+		if removeErr != nil {
+			return "", false, errors.Join(ErrCreatingContainer, err, removeErr)
+		}
+		if removed {
+			containerID, err = s.createContainer(spec, platform, containerName)
+		}
+	}
 	if err != nil {
-		return errors.Join(ErrReadingPulledImage, err)
+		return "", false, errors.Join(ErrCreatingContainer, err)
 	}
-	slog.Info("done pulling image", "dockerImage", dockerImage)
-	return nil
-}
 
-// CreateContainer creates a container.  If the operation succeeds we return a
-// containerID and error is nil.  If an error occurs, the container ID is empty
-// and the error is set.
-func (s *Session) CreateContainer(dockerImage string, containerName string, ports map[string]string) (string, error) {
-	containerConfig := &container.Config{
-		Image: dockerImage,
-		Tty:   false,
+	if len(spec.files) > 0 {
+		err = s.copyFiles(containerID, spec.files)
+		if err != nil {
+			return "", false, errors.Join(err, s.RemoveContainer(containerID))
+		}
 	}
 
-	portmap := nat.PortMap{}
-	for hPort, cPort := range ports {
-		containerPort, err := nat.NewPort("tcp", cPort)
+	return containerID, spec.usesFreePorts(), nil
+}
+
+// CreateAndStartContainer creates and starts a container like
+// CreateContainer and StartContainer do.  If a free host port we picked for
+// the container, see WithFreePorts, is taken by someone else before the
+// container starts, the container is removed and created again with new
+// host ports, as many times as WithPortRetries allows.
+func (s *Session) CreateAndStartContainer(dockerImage string, containerName string, ports map[string]string, opts ...ContainerOption) (string, error) {
+	return s.createAndStartContainer(context.Background(), dockerImage, containerName, ports, opts...)
+}
+
+// createAndStartContainer does the work of CreateAndStartContainer, giving up
+// once ctx is done.
+func (s *Session) createAndStartContainer(ctx context.Context, dockerImage string, containerName string, ports map[string]string, opts ...ContainerOption) (string, error) {
+	for attempt := 0; ; attempt++ {
+		err := ctx.Err()
 		if err != nil {
-			return "", errors.Join(ErrPortMap, err)
+			return "", err
 		}
 
-		portmap[containerPort] = []nat.PortBinding{{
-			HostIP:   "0.0.0.0",
-			HostPort: hPort,
-		}}
-	}
+		containerID, freePorts, err := s.newContainer(dockerImage, containerName, ports, opts...)
+		if err != nil {
+			return "", err
+		}
 
-	containerHostConfig := &container.HostConfig{
-		PortBindings: portmap,
-		AutoRemove:   true,
+		err = s.StartContainer(containerID)
+		if err == nil {
+			return containerID, nil
+		}
+		removeErr := s.RemoveContainer(containerID)
+		if removeErr != nil || !errors.Is(err, ErrPortAllocated) || !freePorts || attempt >= s.portRetries {
+			return "", errors.Join(err, removeErr)
+		}
+		slog.Warn("host port was taken, retrying with new ports", "image", dockerImage, "attempt", attempt+1)
 	}
+}
 
+// createContainer creates a container from spec.
+func (s *Session) createContainer(spec *containerSpec, platform *ocispec.Platform, containerName string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dockerCreateContainerTimeout)
 	defer cancel()
 
 	container, err := s.client.ContainerCreate(
 		ctx,
-		containerConfig,
-		containerHostConfig,
-		nil, // network config
-		nil, // platform
+		spec.config,
+		spec.hostConfig,
+		spec.networkConfig,
+		platform,
 		containerName,
 	)
 	if err != nil {
-		return "", errors.Join(ErrCreatingContainer, err)
+		return "", err
 	}
-
 	return container.ID, nil
 }
 
@@ -181,6 +655,9 @@ func (s *Session) StartContainer(containerID string) error {
 
 	// fire up the container
 	err := s.client.ContainerStart(ctx, containerID, container.StartOptions{})
+	if err != nil && isPortAllocated(err) && s.bindsHostPorts(ctx, containerID) {
+		return errors.Join(fmt.Errorf("%w: %s", ErrStartingContainer, containerID), ErrPortAllocated, err)
+	}
 	if err != nil {
 		return errors.Join(fmt.Errorf("%w: %s", ErrStartingContainer, containerID), err)
 	}
@@ -206,16 +683,55 @@ func (s *Session) StartContainer(containerID string) error {
 	}
 }
 
+// StopContainer stops a running container gracefully by sending it its stop
+// signal, SIGTERM unless set with WithStopSignal, and waiting for it to exit.
+// If it has not exited within its stop timeout, 10 seconds unless set with
+// WithStopTimeout, it is killed.
+func (s *Session) StopContainer(containerID string) error {
+	inspectCtx, inspectCancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer inspectCancel()
+
+	info, err := s.client.ContainerInspect(inspectCtx, containerID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+	}
+
+	stopTimeout := defaultStopTimeout
+	if info.Config != nil && info.Config.StopTimeout != nil {
+		stopTimeout = time.Duration(*info.Config.StopTimeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout+dockerStopContainerMargin)
+	defer cancel()
+
+	err = s.client.ContainerStop(ctx, containerID, container.StopOptions{})
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrStoppingContainer, containerID), err)
+	}
+	return nil
+}
+
 // RemoveContainer removes a container and forces removal of volumes.  If the
-// container is running it is shut down first.
+// container is running it is killed first; use StopContainer before removing
+// it to give it a chance to shut down cleanly.  The sidecars of the container
+// are removed along with it.
 func (s *Session) RemoveContainer(containerID string) error {
+	sidecarErr := s.removeSidecars(containerID)
+	s.mu.Lock()
+	delete(s.shaped, containerID)
+	s.mu.Unlock()
+
 	ctx, cancel := context.WithTimeout(context.Background(), dockerRemoveContainerTimeout)
 	defer cancel()
 
-	return s.client.ContainerRemove(ctx, containerID, container.RemoveOptions{
+	err := s.client.ContainerRemove(ctx, containerID, container.RemoveOptions{
 		RemoveVolumes: true,
 		Force:         true,
 	})
+	if sidecarErr != nil {
+		return errors.Join(sidecarErr, err)
+	}
+	return err
 }
 
 // RemoveImage removes a docker image.
@@ -227,19 +743,68 @@ func (s *Session) RemoveImage(dockerImage string) error {
 	return err
 }
 
-// Close session.
+// Close session.  The volumes created by the session are removed unless the
+// session was created with WithKeepVolumes.  If the session has a session
+// network it is removed.  Volumes and networks that are still in use by
+// containers cannot be removed.  If the session has a reaper, it removes
+// whatever is left once the session is closed.
 func (s *Session) Close() error {
-	return s.client.Close()
+	var errs []error
+	if !s.keepVolumes {
+		for _, name := range s.Volumes() {
+			errs = append(errs, s.RemoveVolume(name))
+		}
+	}
+	if s.networkID != "" {
+		errs = append(errs, s.RemoveNetwork(s.networkID))
+	}
+	s.mu.Lock()
+	clear(s.sidecars)
+	clear(s.shaped)
+	s.mu.Unlock()
+	s.stopReaper()
+	errs = append(errs, s.client.Close())
+	return errors.Join(errs...)
+}
+
+// newSession returns a session using client, with the defaults of Create.
+func newSession(client *client.Client) *Session {
+	return &Session{
+		client:      client,
+		id:          randomID(),
+		created:     time.Now(),
+		portRetries: defaultPortRetries,
+		verified:    map[string]bool{},
+		sidecars:    map[string][]string{},
+		shaped:      map[string]map[string]string{},
+		mirrored:    map[string]string{},
+
+		startParallelism: defaultStartParallelism,
+	}
 }
 
-func getFreePort() (int, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+// connect creates a docker client configured from the environment and makes
+// sure the docker daemon answers.
+func connect() (*client.Client, error) {
+	c, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, errors.Join(ErrCreatingDockerClient, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerConnectTimeout)
+	defer cancel()
+
+	_, err = c.Ping(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get a free port: %w", err)
+		c.Close()
+		return nil, errors.Join(ErrConnectingToDocker, err)
 	}
-	defer listener.Close()
+	return c, nil
+}
 
-	// Extract the port from the listener address
-	addr := listener.Addr().(*net.TCPAddr)
-	return addr.Port, nil
+// randomID returns a short random hex string for naming things.
+func randomID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
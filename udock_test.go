@@ -1,11 +1,15 @@
 package udock
 
 import (
-	"errors"
+	"archive/tar"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -19,11 +23,10 @@ const (
 )
 
 func TestClient(t *testing.T) {
+	SkipIfUnavailable(t)
+
 	// Client creation and deferring closing client
 	session, err := Create()
-	if errors.Is(err, ErrConnectingToDocker) {
-		t.Skip("docker not available, if you want these tests to run please make sure docker is running")
-	}
 	require.NoError(t, err)
 	require.NotNil(t, session)
 	defer func() {
@@ -44,9 +47,8 @@ func TestClient(t *testing.T) {
 	require.NoError(t, err)
 
 	// allocate a random free port number for the external port
-	freePort, err := getFreePort()
+	httpExternalport, err := getFreePort("tcp", []string{defaultHostIP}, func(string) bool { return false })
 	require.NoError(t, err)
-	httpExternalport := fmt.Sprintf("%d", freePort)
 
 	// create the container
 	containerID, err := session.CreateContainer(
@@ -78,3 +80,629 @@ func TestClient(t *testing.T) {
 	require.Equal(t, 200, resp.StatusCode)
 	require.Equal(t, "hello-world\n", string(body))
 }
+
+func TestCreateAndStartContainer(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create(WithPortRetries(2))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, session.Close())
+	}()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	// a container holding a host port picked by docker
+	blockerID, err := session.CreateAndStartContainer(httpEchoImage, "", nil, WithPublishedPorts(httpInternalPort))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, session.RemoveContainer(blockerID))
+	}()
+	takenPort, err := session.GetMappedPort(blockerID, httpInternalPort)
+	require.NoError(t, err)
+
+	// a host port given by the caller is not replaced when it is taken,
+	// and the container is removed
+	_, err = session.CreateAndStartContainer(httpEchoImage, "", map[string]string{takenPort: httpInternalPort})
+	require.ErrorIs(t, err, ErrPortAllocated)
+
+	// free ports are picked around the taken port
+	containerID, err := session.CreateAndStartContainer(httpEchoImage, "", nil, WithFreePorts(httpInternalPort))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, session.RemoveContainer(containerID))
+	}()
+
+	hostPort, err := session.GetMappedPort(containerID, httpInternalPort)
+	require.NoError(t, err)
+	require.NotEqual(t, takenPort, hostPort)
+
+	resp, err := http.Get("http://localhost:" + hostPort + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+}
+
+func TestAutoAssignedPort(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, session.Close())
+	}()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	// let docker pick the host port
+	containerID, err := session.CreateContainer(
+		httpEchoImage,
+		fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		nil,
+		WithPublishedPorts(httpInternalPort),
+	)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, session.RemoveContainer(containerID))
+	}()
+
+	require.NoError(t, session.StartContainer(containerID))
+
+	hostPort, err := session.GetMappedPort(containerID, httpInternalPort+"/tcp")
+	require.NoError(t, err)
+	require.NotEmpty(t, hostPort)
+
+	_, err = session.GetMappedPort(containerID, "1234/udp")
+	require.ErrorIs(t, err, ErrPortNotMapped)
+
+	resp, err := http.Get("http://localhost:" + hostPort + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+}
+
+func TestSessionNetwork(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create(WithSessionNetwork())
+	require.NoError(t, err)
+	require.NotEmpty(t, session.Network())
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	containerID, err := session.CreateContainer(httpEchoImage, fmt.Sprintf("test-%d", time.Now().UnixNano()), nil)
+	require.NoError(t, err)
+	require.NoError(t, session.StartContainer(containerID))
+
+	ip, err := session.ContainerIP(containerID, session.Network())
+	require.NoError(t, err)
+	require.NotEmpty(t, ip)
+
+	// the network can only be removed once the container is gone
+	require.NoError(t, session.RemoveContainer(containerID))
+	require.NoError(t, session.Close())
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create(WithKeepVolumes())
+	require.NoError(t, err)
+	defer session.Close()
+
+	name, err := session.CreateVolume("")
+	require.NoError(t, err)
+
+	report, err := CleanupOrphans(0, LabelSession+"="+session.id)
+	require.NoError(t, err)
+	require.Equal(t, []string{name}, report.Volumes)
+	require.Empty(t, report.Containers)
+}
+
+func TestRunWaitAndInit(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	initialized := false
+	containerID, err := session.Run(ContainerSpec{
+		Image:   httpEchoImage,
+		Options: []ContainerOption{WithPublishedPorts(httpInternalPort)},
+		WaitFor: WaitForAll(WaitForLog("server is listening"), WaitForHTTP(httpInternalPort, "/")),
+		Init: []InitStep{
+			InitHTTP(http.MethodGet, httpInternalPort, "/", "", nil),
+			InitFunc(func(ctx context.Context, s *Session, containerID string) error {
+				initialized = true
+				return nil
+			}),
+		},
+	})
+	require.NoError(t, err)
+	defer session.RemoveContainer(containerID)
+	require.True(t, initialized)
+}
+
+func TestDump(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	name := UniqueName("echo", t.Name())
+	containerID, err := session.Run(ContainerSpec{
+		Image:   httpEchoImage,
+		Name:    name,
+		WaitFor: WaitForLog("server is listening"),
+	})
+	require.NoError(t, err)
+	defer session.RemoveContainer(containerID)
+
+	dir := t.TempDir()
+	require.NoError(t, session.Dump(dir))
+
+	for _, file := range []string{"inspect.json", "stdout.log", "stderr.log", "stats.json", "events.json"} {
+		require.FileExists(t, filepath.Join(dir, name, file))
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create(WithSessionNetwork())
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	backend := UniqueName("backend", t.Name())
+	frontend := UniqueName("frontend", t.Name())
+	other := UniqueName("other", t.Name())
+
+	containers, err := session.RunAll(
+		ContainerSpec{Image: httpEchoImage, Name: frontend, DependsOn: []string{backend}},
+		ContainerSpec{Image: httpEchoImage, Name: backend, WaitFor: WaitForLog("server is listening")},
+		ContainerSpec{Image: httpEchoImage, Name: other},
+	)
+	require.NoError(t, err)
+	require.Len(t, containers, 3)
+	for _, containerID := range containers {
+		require.NoError(t, session.RemoveContainer(containerID))
+	}
+
+	_, err = session.RunAll(
+		ContainerSpec{Image: "some/madeup:image", Name: backend},
+		ContainerSpec{Image: httpEchoImage, Name: frontend, DependsOn: []string{backend}},
+	)
+	require.ErrorIs(t, err, ErrDependency)
+}
+
+func TestStack(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	backend := "backend"
+	frontend := "frontend"
+
+	spec := StackSpec{
+		Name:     "stack",
+		Networks: []string{"internal"},
+		Volumes:  []string{"data"},
+		Containers: []StackContainer{
+			{
+				ContainerSpec: ContainerSpec{Image: httpEchoImage, Name: backend},
+				Networks:      []string{"internal"},
+				Mounts:        map[string]string{"data": "/data"},
+			},
+			{
+				ContainerSpec: ContainerSpec{
+					Image:     httpEchoImage,
+					Name:      frontend,
+					Options:   []ContainerOption{WithPublishedPorts(httpInternalPort)},
+					DependsOn: []string{backend},
+				},
+				Networks: []string{"internal"},
+			},
+		},
+	}
+	stack := session.NewStack(spec)
+	require.NoError(t, stack.Up(context.Background()))
+	require.Error(t, stack.Up(context.Background()))
+
+	// a second stack from the same spec does not collide with the first
+	twin := session.NewStack(spec)
+	require.NoError(t, twin.Up(context.Background()))
+	twinBackendID, err := twin.Container(backend)
+	require.NoError(t, err)
+	info, err := session.client.ContainerInspect(context.Background(), twinBackendID)
+	require.NoError(t, err)
+	require.NotEqual(t, "/"+backend, info.Name)
+	require.NoError(t, twin.Down(context.Background()))
+
+	_, err = stack.Container(backend)
+	require.NoError(t, err)
+	_, err = stack.Endpoint(frontend, httpInternalPort)
+	require.NoError(t, err)
+	volumeName, err := stack.Volume("data")
+	require.NoError(t, err)
+	require.Contains(t, session.Volumes(), volumeName)
+	require.NoError(t, stack.WaitReady(context.Background()))
+
+	// a container that is gone fails the readiness check by name
+	backendID, err := stack.Container(backend)
+	require.NoError(t, err)
+	require.NoError(t, session.RemoveContainer(backendID))
+	err = stack.WaitReady(context.Background())
+	var readinessErr *ReadinessError
+	require.ErrorAs(t, err, &readinessErr)
+	require.Contains(t, readinessErr.Failed, backend)
+
+	require.NoError(t, stack.Down(context.Background()))
+	_, err = stack.Container(backend)
+	require.ErrorIs(t, err, ErrNotInStack)
+	require.NotContains(t, session.Volumes(), volumeName)
+
+	// a container that cannot be started takes the rest of the stack down
+	// with it
+	broken := session.NewStack(StackSpec{
+		Name:    "broken",
+		Volumes: []string{"data"},
+		Containers: []StackContainer{
+			{ContainerSpec: ContainerSpec{Image: "some/madeup:image", Name: backend}, Mounts: map[string]string{"data": "/data"}},
+		},
+	})
+	require.Error(t, broken.Up(context.Background()))
+	_, err = broken.Volume("data")
+	require.ErrorIs(t, err, ErrNotInStack)
+	require.Empty(t, session.Volumes())
+}
+
+func TestInitContainers(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+	require.NoError(t, session.PullImage(defaultVolumeHelperImage))
+
+	volumeName, err := session.CreateVolume("")
+	require.NoError(t, err)
+
+	containerID, err := session.Run(ContainerSpec{
+		Image: httpEchoImage,
+		InitContainers: []ContainerSpec{
+			{
+				Image: defaultVolumeHelperImage,
+				Options: []ContainerOption{
+					WithVolumeMount(volumeName, "/data"),
+					WithCmd("sh", "-c", "echo ready > /data/state && chown 1000:1000 /data/state"),
+				},
+			},
+		},
+		Options: []ContainerOption{WithVolumeMount(volumeName, "/data")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, session.RemoveContainer(containerID))
+
+	_, err = session.Run(ContainerSpec{
+		Image: httpEchoImage,
+		InitContainers: []ContainerSpec{
+			{Image: defaultVolumeHelperImage, Options: []ContainerOption{WithCmd("sh", "-c", "echo migration failed >&2; exit 3")}},
+		},
+	})
+	require.ErrorIs(t, err, ErrInitContainer)
+	require.ErrorContains(t, err, "migration failed")
+}
+
+func TestSidecars(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+	require.NoError(t, session.PullImage(defaultVolumeHelperImage))
+
+	// the sidecar reaches the container on localhost since they share
+	// the network namespace
+	sidecarName := UniqueName("sidecar", t.Name())
+	containerID, err := session.Run(ContainerSpec{
+		Image:   httpEchoImage,
+		Options: []ContainerOption{WithCmd("-text", "hello sidecar")},
+		Sidecars: []ContainerSpec{
+			{
+				Image: defaultVolumeHelperImage,
+				Name:  sidecarName,
+				Options: []ContainerOption{
+					WithCmd("sh", "-c", "until wget -qO- http://localhost:"+httpInternalPort+"/; do sleep 0.1; done; sleep 3600"),
+				},
+				WaitFor: WaitForLog("hello sidecar"),
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, session.RemoveContainer(containerID))
+	_, err = session.client.ContainerInspect(context.Background(), sidecarName)
+	require.Error(t, err)
+}
+
+func TestRunReplicas(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(httpEchoImage))
+
+	replicas, err := session.RunReplicas(ContainerSpec{
+		Image:   httpEchoImage,
+		Name:    UniqueName("echo", t.Name()),
+		Ports:   map[string]string{"8080": httpInternalPort},
+		WaitFor: WaitForHTTP(httpInternalPort, "/"),
+	}, 3)
+	require.NoError(t, err)
+	require.Len(t, replicas, 3)
+
+	endpoints := map[string]bool{}
+	for _, replica := range replicas {
+		endpoint, err := replica.Endpoint(httpInternalPort)
+		require.NoError(t, err)
+		endpoints[endpoint] = true
+		require.NoError(t, replica.Remove())
+	}
+	require.Len(t, endpoints, 3)
+}
+
+func TestRunJob(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(defaultVolumeHelperImage))
+
+	dir := t.TempDir()
+	result, err := session.RunJob(JobSpec{
+		ContainerSpec: ContainerSpec{
+			Image: defaultVolumeHelperImage,
+			Options: []ContainerOption{
+				WithCmd("sh", "-c", "mkdir -p /out && echo pass > /out/result && echo done && echo warning >&2 && exit 2"),
+			},
+		},
+		Outputs: map[string]string{"/out": dir},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.ExitCode)
+	require.Equal(t, "done\n", string(result.Stdout))
+	require.Equal(t, "warning\n", string(result.Stderr))
+	require.Positive(t, result.Duration)
+
+	data, err := os.ReadFile(filepath.Join(dir, "out", "result"))
+	require.NoError(t, err)
+	require.Equal(t, "pass\n", string(data))
+
+	// a job that fails before writing its outputs still reports how it
+	// failed
+	result, err = session.RunJob(JobSpec{
+		ContainerSpec: ContainerSpec{
+			Image:   defaultVolumeHelperImage,
+			Options: []ContainerOption{WithCmd("sh", "-c", "echo no config >&2; exit 1")},
+		},
+		Outputs: map[string]string{"/out": t.TempDir()},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.ExitCode)
+	require.Equal(t, "no config\n", string(result.Stderr))
+
+	// and a job that hangs is killed with what it logged kept
+	result, err = session.RunJob(JobSpec{
+		ContainerSpec: ContainerSpec{
+			Image:   defaultVolumeHelperImage,
+			Options: []ContainerOption{WithCmd("sh", "-c", "echo started; sleep 3600")},
+		},
+		Timeout: 2 * time.Second,
+	})
+	require.ErrorIs(t, err, ErrTimeout)
+	require.Equal(t, -1, result.ExitCode)
+	require.Equal(t, "started\n", string(result.Stdout))
+}
+
+func TestSnapshot(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(defaultVolumeHelperImage))
+
+	volumeName, err := session.CreateVolume("")
+	require.NoError(t, err)
+
+	containerID, err := session.Run(ContainerSpec{
+		Image: defaultVolumeHelperImage,
+		Options: []ContainerOption{
+			WithVolumeMount(volumeName, "/data"),
+			WithCmd("sh", "-c", "echo volume > /data/state && echo rootfs > /state && echo written && sleep 3600"),
+		},
+		WaitFor: WaitForLog("written"),
+	})
+	require.NoError(t, err)
+
+	snapshot, err := session.SnapshotContainer(containerID)
+	require.NoError(t, err)
+	require.Len(t, snapshot.Volumes, 1)
+
+	// changes made after the snapshot must not show up in the restored
+	// container
+	result, err := session.Exec(containerID, "sh", "-c", "echo changed > /data/state")
+	require.NoError(t, err)
+	require.Equal(t, 0, result.ExitCode)
+
+	restoredID, err := session.RestoreSnapshot(snapshot, "", nil, WithCmd("sleep", "3600"))
+	require.NoError(t, err)
+	require.NoError(t, session.StartContainer(restoredID))
+
+	result, err = session.Exec(restoredID, "cat", "/data/state", "/state")
+	require.NoError(t, err)
+	require.Equal(t, 0, result.ExitCode, string(result.Stderr))
+	require.Equal(t, "volume\nrootfs\n", string(result.Stdout))
+
+	report, err := CleanupOrphans(0, LabelSession+"="+session.id)
+	require.NoError(t, err)
+	require.Equal(t, []string{snapshot.Image}, report.Images)
+}
+
+func TestSharedContainers(t *testing.T) {
+	SkipIfUnavailable(t)
+	defer func() {
+		stopSuite()
+		suite.session = nil
+		suite.containers = nil
+	}()
+
+	err := startSuite([]ContainerSpec{
+		{
+			Name:    "echo",
+			Image:   httpEchoImage,
+			Ports:   map[string]string{"": httpInternalPort},
+			Options: []ContainerOption{WithCmd("-text", "shared")},
+			WaitFor: WaitForHTTP(httpInternalPort, "/"),
+		},
+	})
+	require.NoError(t, err)
+
+	require.Same(t, suite.session, SharedSession(t))
+	containerID := SharedContainer(t, "echo")
+	require.Equal(t, suite.containers["echo"], containerID)
+
+	resp, err := http.Get("http://" + SharedEndpoint(t, "echo", httpInternalPort) + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "shared\n", string(body))
+
+	require.Contains(t, fatalOf(t, func(tb testing.TB) { SharedContainer(tb, "pg") }), `no shared container named "pg"`)
+}
+
+func TestSharedContainersUniqueNames(t *testing.T) {
+	SkipIfUnavailable(t)
+	defer func() {
+		stopSuite()
+		suite.session = nil
+		suite.containers = nil
+	}()
+
+	err := startSuite([]ContainerSpec{
+		{Image: httpEchoImage, Options: []ContainerOption{WithCmd("-text", "first")}},
+		{Image: httpEchoImage, Options: []ContainerOption{WithCmd("-text", "second")}},
+	})
+	require.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestEnsureImages(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	images := []string{httpEchoImage, defaultVolumeHelperImage}
+	require.NoError(t, session.EnsureImages(images, WithPullParallelism(2)))
+	for _, image := range images {
+		require.NoError(t, session.VerifyHaveImage(image))
+	}
+
+	// the images we have are not pulled again, and the error of the one
+	// that cannot be pulled is returned
+	err = session.EnsureImages(append(images, "localhost:1/udock/does-not-exist:latest"))
+	require.ErrorIs(t, err, ErrPullingImage)
+	require.ErrorContains(t, err, "does-not-exist")
+}
+
+func TestImportImage(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	var rootfs bytes.Buffer
+	tw := tar.NewWriter(&rootfs)
+	contents := []byte("imported\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "etc/motd", Mode: 0o644, Size: int64(len(contents))}))
+	_, err = tw.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	name := "udock-import:" + randomID()
+	require.NoError(t, session.ImportImage(&rootfs, name, `CMD ["/bin/sh"]`, "ENV GREETING=hello"))
+	defer func() {
+		require.NoError(t, session.RemoveImage(name))
+	}()
+
+	info, err := session.InspectImage(name)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/bin/sh"}, info.Cmd)
+	require.Equal(t, "hello", info.Env["GREETING"])
+}
+
+func TestSaveAndLoadImage(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(defaultVolumeHelperImage))
+	name := "udock-save:" + randomID()
+	require.NoError(t, session.TagImage(defaultVolumeHelperImage, name))
+
+	var archive bytes.Buffer
+	require.NoError(t, session.SaveImage([]string{name}, &archive))
+	require.NoError(t, session.RemoveImage(name))
+	require.ErrorIs(t, session.VerifyHaveImage(name), ErrImageNotPresent)
+
+	require.NoError(t, session.LoadImage(&archive))
+	defer func() {
+		require.NoError(t, session.RemoveImage(name))
+	}()
+	require.NoError(t, session.VerifyHaveImage(name))
+}
+
+func TestImageHistory(t *testing.T) {
+	SkipIfUnavailable(t)
+
+	session, err := Create()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.PullImage(defaultVolumeHelperImage))
+
+	history, err := session.ImageHistory(defaultVolumeHelperImage)
+	require.NoError(t, err)
+	require.NotEmpty(t, history)
+	require.NotEmpty(t, history[len(history)-1].CreatedBy)
+	require.False(t, history[0].Created.IsZero())
+
+	_, err = session.ImageHistory("udock-does-not-exist:" + randomID())
+	require.ErrorIs(t, err, ErrImageNotPresent)
+}
@@ -0,0 +1,148 @@
+package udock
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+)
+
+// variable matches the variables ExpandVars replaces: $$, $VAR, ${VAR},
+// ${VAR:-default}, ${VAR-default}, ${VAR:?error} and ${VAR?error}.
+var variable = regexp.MustCompile(`\$(?:(\$)|([A-Za-z_][A-Za-z0-9_]*)|\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?])([^}]*))?\})`)
+
+// ExpandVars replaces the variables in s with their values, looking them up
+// in vars first and then in the environment of the process.  Like in the
+// shell and in compose files, $VAR and ${VAR} are replaced by the value of
+// VAR or by nothing if it is not set, ${VAR:-default} and ${VAR-default} by
+// default if VAR is empty or not set respectively, and ${VAR:?error} and
+// ${VAR?error} fail with error if VAR is empty or not set respectively.  $$
+// is a literal $.
+func ExpandVars(s string, vars map[string]string) (string, error) {
+	return ExpandVarsFunc(s, func(name string) (string, bool) {
+		value, ok := vars[name]
+		if !ok {
+			value, ok = os.LookupEnv(name)
+		}
+		return value, ok
+	})
+}
+
+// ExpandVarsFunc replaces the variables in s like ExpandVars does, with the
+// values lookup returns.
+func ExpandVarsFunc(s string, lookup func(string) (string, bool)) (string, error) {
+	var errs []error
+	result := variable.ReplaceAllStringFunc(s, func(match string) string {
+		m := variable.FindStringSubmatch(match)
+		if m[1] != "" {
+			return "$"
+		}
+
+		name, op, arg := m[2], m[4], m[5]
+		if name == "" {
+			name = m[3]
+		}
+		value, ok := lookup(name)
+
+		switch op {
+		case ":-":
+			if value == "" {
+				return arg
+			}
+		case "-":
+			if !ok {
+				return arg
+			}
+		case ":?":
+			if value == "" {
+				errs = append(errs, fmt.Errorf("%w: %s is required: %s", ErrExpandingVars, name, arg))
+			}
+		case "?":
+			if !ok {
+				errs = append(errs, fmt.Errorf("%w: %s is required: %s", ErrExpandingVars, name, arg))
+			}
+		}
+		return value
+	})
+	return result, errors.Join(errs...)
+}
+
+// WithVars turns on ExpandVars for the options that follow it, so one set of
+// options can serve e.g. local development and CI.  The variables are
+// expanded in environment values (WithEnv and WithEnvVar), commands
+// (WithCmd and WithEntrypoint) and mounts (WithBindMount, WithVolumeMount and
+// WithTmpfs).  Run does this for specs with Vars set, and expands the image
+// as well.
+func WithVars(vars map[string]string) ContainerOption {
+	return func(spec *containerSpec) error {
+		if vars == nil {
+			vars = map[string]string{}
+		}
+		spec.vars = vars
+		return nil
+	}
+}
+
+// expand expands the variables in s if WithVars has been given.
+func (spec *containerSpec) expand(s string) (string, error) {
+	if spec.vars == nil {
+		return s, nil
+	}
+	return ExpandVars(s, spec.vars)
+}
+
+// expandAll expands the variables in each of ss if WithVars has been given.
+func (spec *containerSpec) expandAll(ss []string) ([]string, error) {
+	if spec.vars == nil {
+		return ss, nil
+	}
+
+	expanded := make([]string, 0, len(ss))
+	for _, s := range ss {
+		e, err := ExpandVars(s, spec.vars)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// expandVars returns the spec with the variables of the spec expanded in the
+// image and WithVars added in front of the options.  The init containers and
+// sidecars of the spec inherit its variables, with their own taking
+// precedence, and are expanded when they are run.  Specs without Vars are
+// returned as they are.
+func (spec ContainerSpec) expandVars() (ContainerSpec, error) {
+	if spec.Vars == nil {
+		return spec, nil
+	}
+
+	image, err := ExpandVars(spec.Image, spec.Vars)
+	if err != nil {
+		return spec, err
+	}
+	spec.Image = image
+	spec.Options = append([]ContainerOption{WithVars(spec.Vars)}, spec.Options...)
+	spec.InitContainers = inheritVars(spec.InitContainers, spec.Vars)
+	spec.Sidecars = inheritVars(spec.Sidecars, spec.Vars)
+	spec.Vars = nil
+	return spec, nil
+}
+
+// inheritVars returns copies of specs with vars added to their Vars.
+func inheritVars(specs []ContainerSpec, vars map[string]string) []ContainerSpec {
+	if len(specs) == 0 {
+		return specs
+	}
+
+	inherited := make([]ContainerSpec, 0, len(specs))
+	for _, spec := range specs {
+		merged := maps.Clone(vars)
+		maps.Copy(merged, spec.Vars)
+		spec.Vars = merged
+		inherited = append(inherited, spec)
+	}
+	return inherited
+}
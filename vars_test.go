@@ -0,0 +1,109 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandVars(t *testing.T) {
+	t.Setenv("UDOCK_TEST_REGISTRY", "registry.example.com")
+	vars := map[string]string{"TAG": "v2", "EMPTY": "", "UDOCK_TEST_REGISTRY": "localhost:5000"}
+
+	for in, want := range map[string]string{
+		"${UDOCK_TEST_REGISTRY}/app:$TAG": "localhost:5000/app:v2",
+		"${EMPTY:-default}":               "default",
+		"${EMPTY-default}":                "",
+		"${UNSET-default}":                "default",
+		"$$TAG costs $$5":                 "$TAG costs $5",
+		"${UNSET}/path":                   "/path",
+		"no variables at all":             "no variables at all",
+	} {
+		got, err := ExpandVars(in, vars)
+		require.NoError(t, err)
+		require.Equal(t, want, got, in)
+	}
+
+	got, err := ExpandVars("${UDOCK_TEST_REGISTRY}/app", nil)
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/app", got)
+
+	_, err = ExpandVars("${EMPTY:?must be set}", vars)
+	require.ErrorIs(t, err, ErrExpandingVars)
+}
+
+func TestWithVars(t *testing.T) {
+	vars := map[string]string{"LEVEL": "debug", "DATA": "/srv/data", "VOLUME": "pgdata"}
+
+	spec, err := newContainerSpec("app", nil,
+		WithVars(vars),
+		WithEnvVar("LOG_LEVEL", "${LEVEL}"),
+		WithCmd("serve", "--data=${DATA}", "--price=$$5"),
+		WithEntrypoint("/bin/${ENTRYPOINT:-app}"),
+		WithBindMount("${DATA}", "${DATA}", true),
+		WithVolumeMount("$VOLUME", "/var/lib/${VOLUME}"),
+		WithTmpfs("${DATA}/tmp", ""),
+	)
+	require.NoError(t, err)
+	require.Contains(t, spec.config.Env, "LOG_LEVEL=debug")
+	require.Equal(t, []string{"serve", "--data=/srv/data", "--price=$5"}, []string(spec.config.Cmd))
+	require.Equal(t, []string{"/bin/app"}, []string(spec.config.Entrypoint))
+	require.Equal(t, []string{"/srv/data:/srv/data:ro"}, spec.hostConfig.Binds)
+	require.Equal(t, "pgdata", spec.hostConfig.Mounts[0].Source)
+	require.Equal(t, "/var/lib/pgdata", spec.hostConfig.Mounts[0].Target)
+	require.Contains(t, spec.hostConfig.Tmpfs, "/srv/data/tmp")
+
+	// without WithVars nothing is expanded
+	spec, err = newContainerSpec("app", nil, WithCmd("echo", "$HOME"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"echo", "$HOME"}, []string(spec.config.Cmd))
+
+	_, err = newContainerSpec("app", nil, WithVars(nil), WithEnvVar("DSN", "${DSN:?set DSN}"))
+	require.ErrorIs(t, err, ErrExpandingVars)
+}
+
+func TestContainerSpecExpandVars(t *testing.T) {
+	spec, err := ContainerSpec{
+		Image:   "${REGISTRY:-docker.io}/app:${TAG}",
+		Vars:    map[string]string{"TAG": "v2"},
+		Options: []ContainerOption{WithCmd("${TAG}")},
+	}.expandVars()
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/app:v2", spec.Image)
+	require.Nil(t, spec.Vars)
+	require.Len(t, spec.Options, 2)
+
+	built, err := newContainerSpec(spec.Image, nil, spec.Options...)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2"}, []string(built.config.Cmd))
+
+	plain := ContainerSpec{Image: "${TAG}"}
+	spec, err = plain.expandVars()
+	require.NoError(t, err)
+	require.Equal(t, "${TAG}", spec.Image)
+
+	parent := ContainerSpec{
+		Image:          "app",
+		Vars:           map[string]string{"TAG": "v2", "LEVEL": "debug"},
+		InitContainers: []ContainerSpec{{Image: "migrate:${TAG}"}},
+		Sidecars:       []ContainerSpec{{Image: "scraper:${TAG}", Vars: map[string]string{"TAG": "v3"}}},
+	}
+	spec, err = parent.expandVars()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"TAG": "v2", "LEVEL": "debug"}, spec.InitContainers[0].Vars)
+	require.Equal(t, map[string]string{"TAG": "v3", "LEVEL": "debug"}, spec.Sidecars[0].Vars)
+	require.Equal(t, map[string]string{"TAG": "v3"}, parent.Sidecars[0].Vars)
+}
+
+func TestExpandVarsFunc(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		return map[string]string{"SET": "value"}[name], name == "SET"
+	}
+
+	got, err := ExpandVarsFunc("${SET} ${UNSET:-default} $${SET}", lookup)
+	require.NoError(t, err)
+	require.Equal(t, "value default ${SET}", got)
+
+	_, err = ExpandVarsFunc("${UNSET?must be set}", lookup)
+	require.ErrorIs(t, err, ErrExpandingVars)
+}
@@ -0,0 +1,166 @@
+package udock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/errdefs"
+)
+
+// ImageVerifier verifies an image before the session uses it.  ref is a
+// digest-pinned reference of the form repository@sha256:..., so the verifier
+// checks exactly the content we have locally.
+type ImageVerifier interface {
+	VerifyImage(ref string) error
+}
+
+// ImageVerifierFunc adapts a function to an ImageVerifier.
+type ImageVerifierFunc func(ref string) error
+
+// VerifyImage calls f(ref).
+func (f ImageVerifierFunc) VerifyImage(ref string) error {
+	return f(ref)
+}
+
+// CosignVerifier verifies image signatures with the cosign command line tool,
+// which must be in PATH.  Set PublicKey to verify against a key, or
+// CertificateIdentity and CertificateOIDCIssuer for keyless verification.
+type CosignVerifier struct {
+	// PublicKey is the path or KMS URI of the public key to verify against.
+	PublicKey string
+
+	// CertificateIdentity is the identity expected in the signing
+	// certificate for keyless verification, e.g. an email address or a
+	// workflow URL.
+	CertificateIdentity string
+
+	// CertificateOIDCIssuer is the OIDC issuer expected in the signing
+	// certificate for keyless verification.
+	CertificateOIDCIssuer string
+
+	// Command is the cosign binary to run.  Defaults to "cosign".
+	Command string
+}
+
+// VerifyImage runs cosign verify for ref.
+func (c CosignVerifier) VerifyImage(ref string) error {
+	args, err := c.args(ref)
+	if err != nil {
+		return err
+	}
+
+	command := c.Command
+	if command == "" {
+		command = "cosign"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), verifyImageTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s: %s", ErrVerifyingImage, ref, strings.TrimSpace(output.String())), err)
+	}
+	return nil
+}
+
+// args returns the arguments for cosign verify.
+func (c CosignVerifier) args(ref string) ([]string, error) {
+	args := []string{"verify"}
+	switch {
+	case c.PublicKey != "":
+		args = append(args, "--key", c.PublicKey)
+
+	case c.CertificateIdentity != "" && c.CertificateOIDCIssuer != "":
+		args = append(args,
+			"--certificate-identity", c.CertificateIdentity,
+			"--certificate-oidc-issuer", c.CertificateOIDCIssuer,
+		)
+
+	default:
+		return nil, fmt.Errorf("%w: cosign needs either a public key or a certificate identity and OIDC issuer", ErrVerifyingImage)
+	}
+	return append(args, ref), nil
+}
+
+// verifyImage runs the session's image verifier, if any, on dockerImage.
+// Images are only verified once per session.
+func (s *Session) verifyImage(dockerImage string) error {
+	if s.verifier == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInspectTimeout)
+	defer cancel()
+
+	inspect, _, err := s.client.ImageInspectWithRaw(ctx, s.localImage(dockerImage))
+	if errdefs.IsNotFound(err) {
+		return fmt.Errorf("%w: %s", ErrImageNotPresent, dockerImage)
+	}
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrInspectingImage, dockerImage), err)
+	}
+
+	s.mu.Lock()
+	verified := s.verified[inspect.ID]
+	s.mu.Unlock()
+	if verified {
+		return nil
+	}
+
+	ref, err := pinnedReference(dockerImage, inspect.RepoDigests)
+	if err != nil {
+		return err
+	}
+
+	err = s.verifier.VerifyImage(ref)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrVerifyingImage, dockerImage), err)
+	}
+
+	s.mu.Lock()
+	s.verified[inspect.ID] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// pinnedReference picks the repository digest matching the repository of
+// dockerImage.  Images that were not pulled from a registry have no
+// repository digests and cannot be verified.
+func pinnedReference(dockerImage string, repoDigests []string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(dockerImage)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrVerifyingImage, dockerImage), err)
+	}
+
+	var fallback string
+	for _, repoDigest := range repoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if digested.Name() == named.Name() {
+			return digested.String(), nil
+		}
+		if fallback == "" {
+			fallback = digested.String()
+		}
+	}
+
+	// images pulled through a mirror only have the digest of the mirror
+	// repository, which refers to the same content.
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("%w: %s has no repository digest", ErrVerifyingImage, dockerImage)
+}
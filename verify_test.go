@@ -0,0 +1,50 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+func TestCosignVerifierArgs(t *testing.T) {
+	ref := "docker.io/library/alpine@" + testDigest
+
+	args, err := CosignVerifier{PublicKey: "cosign.pub"}.args(ref)
+	require.NoError(t, err)
+	require.Equal(t, []string{"verify", "--key", "cosign.pub", ref}, args)
+
+	args, err = CosignVerifier{
+		CertificateIdentity:   "https://github.com/borud/udock/.github/workflows/release.yml@refs/heads/main",
+		CertificateOIDCIssuer: "https://token.actions.githubusercontent.com",
+	}.args(ref)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"verify",
+		"--certificate-identity", "https://github.com/borud/udock/.github/workflows/release.yml@refs/heads/main",
+		"--certificate-oidc-issuer", "https://token.actions.githubusercontent.com",
+		ref,
+	}, args)
+
+	_, err = CosignVerifier{}.args(ref)
+	require.ErrorIs(t, err, ErrVerifyingImage)
+}
+
+func TestPinnedReference(t *testing.T) {
+	ref, err := pinnedReference("alpine:3.20", []string{
+		"mirror.example.com/library/alpine@" + testDigest,
+		"alpine@" + testDigest,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/library/alpine@"+testDigest, ref)
+
+	// fall back to the mirror digest
+	ref, err = pinnedReference("alpine:3.20", []string{"mirror.example.com/library/alpine@" + testDigest})
+	require.NoError(t, err)
+	require.Equal(t, "mirror.example.com/library/alpine@"+testDigest, ref)
+
+	// locally built images cannot be verified
+	_, err = pinnedReference("myimage:dev", nil)
+	require.ErrorIs(t, err, ErrVerifyingImage)
+}
@@ -0,0 +1,192 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// VolumeOption is an option for CreateVolume.
+type VolumeOption func(*volume.CreateOptions)
+
+// WithVolumeDriver sets the volume driver.  The default is "local".
+func WithVolumeDriver(driver string) VolumeOption {
+	return func(o *volume.CreateOptions) {
+		o.Driver = driver
+	}
+}
+
+// WithVolumeDriverOpt sets the driver option key to value, just like --opt
+// does for docker volume create.  For the local driver this can be used for
+// things like WithVolumeDriverOpt("o", "size=1g") or for mounting NFS shares.
+func WithVolumeDriverOpt(key string, value string) VolumeOption {
+	return func(o *volume.CreateOptions) {
+		if o.DriverOpts == nil {
+			o.DriverOpts = map[string]string{}
+		}
+		o.DriverOpts[key] = value
+	}
+}
+
+// WithVolumeDriverOpts sets multiple driver options.
+func WithVolumeDriverOpts(opts map[string]string) VolumeOption {
+	return func(o *volume.CreateOptions) {
+		for k, v := range opts {
+			WithVolumeDriverOpt(k, v)(o)
+		}
+	}
+}
+
+// WithVolumeLabels adds labels to the volume.
+func WithVolumeLabels(labels map[string]string) VolumeOption {
+	return func(o *volume.CreateOptions) {
+		if o.Labels == nil {
+			o.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			o.Labels[k] = v
+		}
+	}
+}
+
+// CreateVolume creates a named volume and returns its name.  If name is empty
+// docker generates a name.  The session keeps track of the volumes it has
+// created, see Volumes, and removes them when it is closed.
+func (s *Session) CreateVolume(name string, opts ...VolumeOption) (string, error) {
+	createOptions := volume.CreateOptions{
+		Name:   name,
+		Driver: "local",
+		Labels: s.labels(),
+	}
+	for _, opt := range opts {
+		opt(&createOptions)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerVolumeTimeout)
+	defer cancel()
+
+	vol, err := s.client.VolumeCreate(ctx, createOptions)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%w: %s", ErrCreatingVolume, name), err)
+	}
+
+	s.mu.Lock()
+	s.volumes = append(s.volumes, vol.Name)
+	s.mu.Unlock()
+
+	return vol.Name, nil
+}
+
+// RemoveVolume removes a volume.  Volumes that are in use by a container
+// cannot be removed.
+func (s *Session) RemoveVolume(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerVolumeTimeout)
+	defer cancel()
+
+	err := s.client.VolumeRemove(ctx, name, false)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w: %s", ErrRemovingVolume, name), err)
+	}
+
+	s.mu.Lock()
+	s.volumes = slices.DeleteFunc(s.volumes, func(v string) bool { return v == name })
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Volumes returns the names of the volumes created by the session that have
+// not been removed.
+func (s *Session) Volumes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.volumes)
+}
+
+// PruneVolumesOptions are the options for PruneVolumes.
+type PruneVolumesOptions struct {
+	// All removes all unused volumes rather than just anonymous ones.
+	All bool
+
+	// Labels only removes volumes with these labels.  Labels are given as
+	// "key" or "key=value".
+	Labels []string
+
+	// ExcludeLabels only removes volumes without these labels.  Labels are
+	// given as "key" or "key=value".
+	ExcludeLabels []string
+}
+
+// PruneVolumes removes unused volumes, for instance the anonymous volumes left
+// behind by containers from failed test runs, and reports what was removed
+// and how much disk space was reclaimed.
+func (s *Session) PruneVolumes(opts PruneVolumesOptions) (PruneReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPruneTimeout)
+	defer cancel()
+
+	report, err := s.client.VolumesPrune(ctx, opts.filters())
+	if err != nil {
+		return PruneReport{}, errors.Join(ErrPruningVolumes, err)
+	}
+
+	s.mu.Lock()
+	s.volumes = slices.DeleteFunc(s.volumes, func(v string) bool {
+		return slices.Contains(report.VolumesDeleted, v)
+	})
+	s.mu.Unlock()
+
+	deleted := report.VolumesDeleted
+	if deleted == nil {
+		deleted = []string{}
+	}
+
+	return PruneReport{
+		Deleted:        deleted,
+		SpaceReclaimed: report.SpaceReclaimed,
+	}, nil
+}
+
+// filters returns the prune filters for the options.
+func (o PruneVolumesOptions) filters() filters.Args {
+	args := filters.NewArgs()
+	if o.All {
+		args.Add("all", "true")
+	}
+	for _, label := range o.Labels {
+		args.Add("label", label)
+	}
+	for _, label := range o.ExcludeLabels {
+		args.Add("label!", label)
+	}
+	return args
+}
+
+// SharedVolume is a volume for sharing files between containers, e.g. an
+// application writing files that a second container reads.  Create it with
+// NewSharedVolume and mount it in each container with Mount.
+type SharedVolume struct {
+	// Name of the volume.
+	Name string
+}
+
+// NewSharedVolume creates a volume with a unique name for sharing between the
+// containers of the session.  Like other volumes created by the session it is
+// removed when the session is closed.
+func (s *Session) NewSharedVolume(opts ...VolumeOption) (*SharedVolume, error) {
+	name, err := s.CreateVolume("udock-"+randomID(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedVolume{Name: name}, nil
+}
+
+// Mount returns a ContainerOption that mounts the shared volume at
+// containerPath.  Pass MountReadOnly for the containers that only read from
+// it.
+func (v *SharedVolume) Mount(containerPath string, opts ...MountOption) ContainerOption {
+	return WithVolumeMount(v.Name, containerPath, opts...)
+}
@@ -0,0 +1,57 @@
+package udock
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVolumeOptions(t *testing.T) {
+	opts := volume.CreateOptions{}
+	for _, opt := range []VolumeOption{
+		WithVolumeDriver("nfs"),
+		WithVolumeLabels(map[string]string{"suite": "udock"}),
+		WithVolumeDriverOpt("type", "nfs"),
+		WithVolumeDriverOpts(map[string]string{"o": "addr=10.0.0.1,rw", "device": ":/export"}),
+	} {
+		opt(&opts)
+	}
+
+	require.Equal(t, "nfs", opts.Driver)
+	require.Equal(t, map[string]string{"suite": "udock"}, opts.Labels)
+	require.Equal(t, map[string]string{
+		"type":   "nfs",
+		"o":      "addr=10.0.0.1,rw",
+		"device": ":/export",
+	}, opts.DriverOpts)
+}
+
+func TestPruneVolumesFilters(t *testing.T) {
+	args := PruneVolumesOptions{}.filters()
+	require.Equal(t, 0, args.Len())
+
+	args = PruneVolumesOptions{
+		All:           true,
+		Labels:        []string{"udock"},
+		ExcludeLabels: []string{"keep"},
+	}.filters()
+
+	require.Equal(t, []string{"true"}, args.Get("all"))
+	require.Equal(t, []string{"udock"}, args.Get("label"))
+	require.Equal(t, []string{"keep"}, args.Get("label!"))
+}
+
+func TestSharedVolumeMount(t *testing.T) {
+	shared := &SharedVolume{Name: "udock-shared"}
+
+	writer, err := newContainerSpec("alpine:latest", nil, shared.Mount("/out"))
+	require.NoError(t, err)
+	reader, err := newContainerSpec("alpine:latest", nil, shared.Mount("/in", MountReadOnly()))
+	require.NoError(t, err)
+
+	require.Equal(t, "udock-shared", writer.hostConfig.Mounts[0].Source)
+	require.False(t, writer.hostConfig.Mounts[0].ReadOnly)
+	require.Equal(t, "udock-shared", reader.hostConfig.Mounts[0].Source)
+	require.True(t, reader.hostConfig.Mounts[0].ReadOnly)
+}
@@ -0,0 +1,216 @@
+package udock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// WaitStrategy decides when a container is ready to be used, e.g. when it
+// accepts connections on a port or has logged that it is ready.
+type WaitStrategy interface {
+	// WaitUntilReady returns nil once the container is ready and an error if
+	// it will not become ready, e.g. because it exited, or ctx is done.
+	WaitUntilReady(ctx context.Context, s *Session, containerID string) error
+}
+
+// WaitFunc adapts a function to the WaitStrategy interface.  The function is
+// called until it returns nil, a permanent error or ctx is done; see
+// poll.
+type WaitFunc func(ctx context.Context, s *Session, containerID string) error
+
+// WaitUntilReady calls f.
+func (f WaitFunc) WaitUntilReady(ctx context.Context, s *Session, containerID string) error {
+	return f(ctx, s, containerID)
+}
+
+// WaitForPort waits until the host port mapped to containerPort accepts TCP
+// connections.  Note that some software, and docker's userland proxy, accept
+// connections before the service behind them is ready.
+func WaitForPort(containerPort string) WaitStrategy {
+	return WaitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		addr, err := s.MappedAddress(containerID, containerPort)
+		if err != nil {
+			return err
+		}
+
+		return s.poll(ctx, containerID, func() (bool, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return false, nil
+			}
+			conn.Close()
+			return true, nil
+		})
+	})
+}
+
+// WaitForHTTP waits until a GET request for path on the host port mapped to
+// containerPort gets a 2xx or 3xx response.
+func WaitForHTTP(containerPort string, path string) WaitStrategy {
+	return WaitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		addr, err := s.MappedAddress(containerID, containerPort)
+		if err != nil {
+			return err
+		}
+		url := "http://" + addr + path
+
+		return s.poll(ctx, containerID, func() (bool, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return false, err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return false, nil
+			}
+			resp.Body.Close()
+			return resp.StatusCode >= 200 && resp.StatusCode < 400, nil
+		})
+	})
+}
+
+// WaitForLog waits until the output of the container matches the regular
+// expression pattern.  Both stdout and stderr are searched.
+func WaitForLog(pattern string) WaitStrategy {
+	return WaitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: invalid log pattern %q", ErrInvalidOption, pattern), err)
+		}
+
+		return s.poll(ctx, containerID, func() (bool, error) {
+			stdout, stderr, err := s.containerLogs(ctx, containerID)
+			if err != nil {
+				return false, err
+			}
+			return re.Match(stdout) || re.Match(stderr), nil
+		})
+	})
+}
+
+// WaitForHealthy waits until the docker health check of the container
+// reports that it is healthy.  The image, or WithHealthCheck, must define a
+// health check.
+func WaitForHealthy() WaitStrategy {
+	return WaitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		return s.poll(ctx, containerID, func() (bool, error) {
+			info, err := s.client.ContainerInspect(ctx, containerID)
+			if err != nil {
+				return false, errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+			}
+			if info.State.Health == nil {
+				return false, fmt.Errorf("%w: %s has no health check", ErrNotReady, containerID)
+			}
+			if info.State.Health.Status == types.Unhealthy {
+				return false, fmt.Errorf("%w: %s is unhealthy", ErrNotReady, containerID)
+			}
+			return info.State.Health.Status == types.Healthy, nil
+		})
+	})
+}
+
+// WaitForExit waits until the container has exited with code 0, for
+// containers that do a job, e.g. run migrations, rather than provide a
+// service.  The container must not be auto removed, see WithAutoRemove.
+func WaitForExit() WaitStrategy {
+	return WaitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+
+		for {
+			info, err := s.client.ContainerInspect(ctx, containerID)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+			}
+			if info.State != nil && (info.State.Status == "exited" || info.State.Status == "dead") {
+				if info.State.ExitCode != 0 {
+					return fmt.Errorf("%w: %s exited with code %d", ErrContainerExited, containerID, info.State.ExitCode)
+				}
+				return nil
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+// WaitForAll waits until all of strategies are satisfied, one after the
+// other.
+func WaitForAll(strategies ...WaitStrategy) WaitStrategy {
+	return WaitFunc(func(ctx context.Context, s *Session, containerID string) error {
+		for _, strategy := range strategies {
+			err := strategy.WaitUntilReady(ctx, s, containerID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WaitForContainer waits until the container is ready according to strategy,
+// giving up after timeout.
+func (s *Session) WaitForContainer(containerID string, strategy WaitStrategy, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.waitUntilReady(ctx, containerID, strategy)
+}
+
+// waitUntilReady waits until the container is ready according to strategy.
+func (s *Session) waitUntilReady(ctx context.Context, containerID string, strategy WaitStrategy) error {
+	err := strategy.WaitUntilReady(ctx, s, containerID)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout) {
+		return errors.Join(fmt.Errorf("%w: %s", ErrNotReady, containerID), ErrTimeout)
+	}
+	return err
+}
+
+// poll calls ready every waitPollInterval until it reports that the
+// container is ready, returns an error, the container exits or ctx is done.
+func (s *Session) poll(ctx context.Context, containerID string, ready func() (bool, error)) error {
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := ready()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		info, err := s.client.ContainerInspect(ctx, containerID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Join(fmt.Errorf("%w: %s", ErrInspectingContainer, containerID), err)
+		}
+		if info.State != nil && !info.State.Running && !info.State.Restarting {
+			return fmt.Errorf("%w: %s exited with code %d", ErrContainerExited, containerID, info.State.ExitCode)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}